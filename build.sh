@@ -45,4 +45,10 @@ if [ "$GOOS" = "windows" ]; then
     OUTPUT="main_$GOOS_$GOARCH.exe"
 fi
 
-CGO_ENABLED=0 GOOS=$GOOS GOARCH=$GOARCH GO111MODULE=on go build -o "$OUTPUT" main.go
\ No newline at end of file
+# Public key of the signed self-update, see job_item update-sign
+LDFLAGS=""
+if [ -n "$JOB_ITEM_UPDATE_PUBLIC_KEY" ]; then
+    LDFLAGS="-X job_item/support.UpdatePublicKey=$JOB_ITEM_UPDATE_PUBLIC_KEY"
+fi
+
+CGO_ENABLED=0 GOOS=$GOOS GOARCH=$GOARCH GO111MODULE=on go build -ldflags "$LDFLAGS" -o "$OUTPUT" main.go
\ No newline at end of file
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	jobitem "job_item/src/controller/JobItem"
@@ -18,12 +19,14 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
 	"github.com/gin-gonic/gin"
 	"github.com/urfave/cli/v2"
 )
@@ -51,13 +54,15 @@ func main() {
 		panic(1)
 	}
 	var configYamlSupport *support.ConfigYamlSupport = support.Helper.ConfigYaml
-	if configYamlSupport.ConfigData.Job_item_version_number > VERSION_NUMBER {
+	newVersion := configYamlSupport.ConfigData.Job_item_version_number
+	updated := false
+	if newVersion > VERSION_NUMBER {
 		// fmt.Println(configYamlSupport.ConfigData.Job_item_version_number, "::", VERSION_NUMBER)
 		support.Helper.PrintGroupName("Download New Version :: " + configYamlSupport.ConfigData.Job_item_link)
-		err := configYamlSupport.DownloadNewApp(configYamlSupport.ConfigData.Job_item_version_number)
+		updated, err = configYamlSupport.DownloadNewApp(newVersion)
 		if err != nil {
-			support.Helper.PrintErrName(err.Error(), "ERR-25230903100")
-			panic(1)
+			// A bad update never stops the running version
+			support.Helper.PrintErrName("The new version is not installed, keep "+VERSION_APP+" :: "+err.Error(), "ERR-25230903100")
 		}
 	} else {
 		if is_develop {
@@ -71,59 +76,106 @@ func main() {
 
 	run_child <- "start"
 	cmdExec, err := configYamlSupport.RunChildExecsProcess()
-	if err != nil {
+	if err != nil && !updated {
 		support.Helper.PrintErrName("Error starting exec process: "+err.Error(), "ERR-303509T3200")
 		panic(1)
 	}
-	cmd, err := configYamlSupport.RunChildProcess()
-	if err != nil {
+	var cmd *exec.Cmd
+	if err == nil {
+		cmd, err = configYamlSupport.RunChildProcess()
+	}
+	if err != nil && !updated {
 		support.Helper.PrintErrName("Error starting child process: "+err.Error(), "ERR-60350903200")
 		panic(1)
 	}
 
+	// Increased before the child process is stopped on purpose, the update health check ignores that exit
+	var childGeneration atomic.Int32
+	// cmd and cmdExec are replaced by the rollback and the restart while the watcher and the signal goroutine stop them
+	var childMutex sync.Mutex
+
+	// Go back to the previous version when the new one does not keep running
+	rollbackUpdate := func() {
+		childMutex.Lock()
+		defer childMutex.Unlock()
+		childGeneration.Add(1)
+		configYamlSupport.CloseAllGroupProcesses([]*exec.Cmd{cmd, cmdExec})
+		if cmdExec != nil {
+			cmdExec.Wait()
+		}
+		err := configYamlSupport.RollbackApp(newVersion)
+		if err != nil {
+			support.Helper.PrintErrName("Error rolling back the update: "+err.Error(), "ERR-50100903201")
+			panic(1)
+		}
+		cmdExec, err = configYamlSupport.RunChildExecsProcess()
+		if err != nil {
+			support.Helper.PrintErrName("Error starting exec process: "+err.Error(), "ERR-50100903202")
+			panic(1)
+		}
+		cmd, err = configYamlSupport.RunChildProcess()
+		if err != nil {
+			support.Helper.PrintErrName("Error starting child process: "+err.Error(), "ERR-50100903203")
+			panic(1)
+		}
+	}
+	if updated && err != nil {
+		support.Helper.PrintErrName("The new version does not start: "+err.Error(), "ERR-50100903204")
+		rollbackUpdate()
+	} else if updated {
+		go func(child *exec.Cmd, generation int32) {
+			if configYamlSupport.WaitUpdateHealth(child, func() bool { return childGeneration.Load() == generation }) {
+				support.Helper.PrintGroupName(fmt.Sprintf("Version %d keeps running, the update is kept", newVersion))
+				return
+			}
+			support.Helper.PrintErrName("The child process of the new version exited or is not healthy", "ERR-50100903205")
+			run_child <- "rollback"
+		}(cmd, childGeneration.Load())
+	}
+
 	// Watch the config file and restart the child process
 	restartProcess := func() {
-		watcher, err := fsnotify.NewWatcher()
+		watchFiles := append([]string{support.Helper.ConfigYaml.Config_path}, configYamlSupport.ConfigData.Env_file...)
+		// The include glob also catches a file added later
+		watchFiles = append(watchFiles, configYamlSupport.ConfigData.Include...)
+		watchFiles = append(watchFiles, configYamlSupport.GetIncludeFiles()...)
+		watcher, err := support.ConfigWatcherConstruct(watchFiles...)
 		if err != nil {
 			support.Helper.PrintErrName("Error creating file watcher: "+err.Error(), "ERR-10351903200")
+			log.Fatal(err)
 		}
 		defer watcher.Close()
 
-		// Add a path.
-		err = watcher.Add(support.Helper.ConfigYaml.Config_path)
-		if err != nil {
-			log.Fatal(err)
-		}
-		is_done_watch := false
-		// Start listening for events.
-		for !is_done_watch {
-			select {
-			case event, ok := <-watcher.Events:
-				if !ok {
-					return
-				}
-				support.Helper.PrintGroupName("event: " + event.String())
-				support.Helper.PrintGroupName("modified file: " + event.Name)
-				configYamlSupport.CloseAllGroupProcesses([]*exec.Cmd{cmd, cmdExec})
+		for {
+			if err := watcher.WaitChange(); err != nil {
+				log.Println("error:", err)
+				return
+			}
+			// Keep the running child process when the new config is broken
+			if err := support.ValidateConfigFile(support.Helper.ConfigYaml.Config_path); err != nil {
+				support.Helper.PrintErrName("Config change not applied, keep the running process", "ERR-45100903202")
+				printConfigErrors(support.AsConfigErrors(err))
+				continue
+			}
+			support.Helper.PrintGroupName("modified file: " + support.Helper.ConfigYaml.Config_path)
+			childMutex.Lock()
+			childGeneration.Add(1)
+			configYamlSupport.CloseAllGroupProcesses([]*exec.Cmd{cmd, cmdExec})
 
-				// For cmd is not have child process, so we only wait cmdExec for it
-				err = cmdExec.Wait()
-				if err != nil {
+			// For cmd is not have child process, so we only wait cmdExec for it
+			if cmdExec != nil {
+				if err := cmdExec.Wait(); err != nil {
 					support.Helper.PrintErrName("Waiting for exec command : "+err.Error(), "ERR-20350903210")
 				}
-				time.Sleep(3 * time.Second) // Wait for 3 seconds before restarting
-				cmd = nil
-				cmdExec = nil
-				support.Helper.PrintGroupName("Restart child process...")
-				time.Sleep(3 * time.Second) // Wait for 3 seconds before restarting
-				run_child <- "restart"
-				is_done_watch = true
-			case err, ok := <-watcher.Errors:
-				if !ok {
-					return
-				}
-				log.Println("error:", err)
 			}
+			time.Sleep(3 * time.Second) // Wait for 3 seconds before restarting
+			cmd = nil
+			cmdExec = nil
+			childMutex.Unlock()
+			support.Helper.PrintGroupName("Restart child process...")
+			time.Sleep(3 * time.Second) // Wait for 3 seconds before restarting
+			run_child <- "restart"
+			return
 		}
 	}
 
@@ -153,11 +205,15 @@ func main() {
 	go func() {
 		for sig := range signalChan {
 			support.Helper.PrintGroupName("Received signal: " + sig.String())
+			// Held until the exit, the restart does not start a new child meanwhile
+			childMutex.Lock()
+			childGeneration.Add(1)
 			configYamlSupport.CloseAllGroupProcesses([]*exec.Cmd{cmd, cmdExec})
 			// For cmd is not have child process, so we only wait cmdExec for it
-			err = cmdExec.Wait()
-			if err != nil {
-				support.Helper.PrintErrName("Waiting for exec command : "+err.Error(), "ERR-20350903511")
+			if cmdExec != nil {
+				if err := cmdExec.Wait(); err != nil {
+					support.Helper.PrintErrName("Waiting for exec command : "+err.Error(), "ERR-20350903511")
+				}
 			}
 			os.Exit(0)
 		}
@@ -175,6 +231,7 @@ func main() {
 				panic(1)
 			}
 
+			childMutex.Lock()
 			cmd, err = configYamlSupport.RunChildProcess()
 			if err != nil {
 				support.Helper.PrintErrName("Error starting child process: "+err.Error(), "ERR-20350903201")
@@ -185,8 +242,12 @@ func main() {
 				fmt.Println(err)
 				panic(1)
 			}
+			childMutex.Unlock()
 			go restartProcess()
 			run_child <- "start"
+		case "rollback":
+			rollbackUpdate()
+			run_child <- "start"
 		default:
 			support.Helper.PrintGroupName("Nothing to do")
 			is_run = false
@@ -210,9 +271,16 @@ func initializeConfigYamlSupport(configYamlSupport *support.ConfigYamlSupport, c
 	var err error
 	totalCountRequest := 3
 	for range totalCountRequest {
-		confItem, err := support.ConfigYamlSupportContruct(support.ConfigYamlSupportConstructPropsType{
+		var confItem *support.ConfigYamlSupport
+		confItem, err = support.ConfigYamlSupportContruct(support.ConfigYamlSupportConstructPropsType{
 			Config_path: config,
 		})
+		var configErrors support.ConfigErrors
+		if errors.As(err, &configErrors) {
+			// The invalid config does not get better by retrying
+			printConfigErrors(configErrors)
+			return err
+		}
 		if err != nil {
 			support.Helper.PrintErrName("Error initializing config yaml support: "+err.Error(), "ERR-3030903200")
 			time.Sleep(time.Duration(time.Second) * 3)
@@ -220,13 +288,20 @@ func initializeConfigYamlSupport(configYamlSupport *support.ConfigYamlSupport, c
 
 			continue
 		}
-		*configYamlSupport = *confItem
-		err = nil
+		configYamlSupport.ApplyConfig(confItem)
 		break
 	}
 	return err
 }
 
+// printConfigErrors prints every config error on its own line.
+func printConfigErrors(configErrors support.ConfigErrors) {
+	fmt.Println("\n❌ Configuration Error:")
+	for _, configError := range configErrors {
+		fmt.Println("  " + configError.Error())
+	}
+}
+
 func initMain(configYamlSupport *support.ConfigYamlSupport, config string) error {
 	supportSupport := support.SupportConstruct("Main")
 
@@ -249,7 +324,7 @@ func initMain(configYamlSupport *support.ConfigYamlSupport, config string) error
 	supportSupport.Register(brokerConnectionSupport)
 
 	// Listen signal shutdown from child and child exec process
-	conn := brokerConnectionSupport.GetConnection(configYamlSupport.ConfigData.Broker_connection["key"].(string))
+	conn := brokerConnectionSupport.GetConnection(configYamlSupport.GetDefaultBrokerKey())
 	configYamlSupport.ListenForShutdownFromConn(conn)
 
 	// Register gin support
@@ -259,6 +334,9 @@ func initMain(configYamlSupport *support.ConfigYamlSupport, config string) error
 	return nil
 }
 
+// Set only by a build with the brokercheck tag, so the contract harness stays out of the release binary
+var brokerCheckCommand func(flagConfig []cli.Flag, flag *string) *cli.Command
+
 func initCli() bool {
 	var flag string
 
@@ -270,6 +348,15 @@ func initCli() bool {
 			Usage:   "configuration file",
 			EnvVars: []string{"CONFIG_PATH"},
 		},
+		&cli.StringFlag{
+			Name:    "profile",
+			Usage:   "profile of the config applied on config.yaml",
+			EnvVars: []string{support.CONFIG_PROFILE_ENV},
+			// Read by the config loading, and given to the child process
+			Action: func(ctx *cli.Context, profile string) error {
+				return os.Setenv(support.CONFIG_PROFILE_ENV, profile)
+			},
+		},
 	}
 
 	app := &cli.App{
@@ -284,8 +371,7 @@ func initCli() bool {
 		// Example job_item --config=/var/www/html/config.yaml
 		Action: func(ctx *cli.Context) error {
 			var configYamlSupport support.ConfigYamlSupport
-			initMain(&configYamlSupport, ctx.String("config"))
-			return nil
+			return initMain(&configYamlSupport, ctx.String("config"))
 		},
 
 		// This is with nested command
@@ -304,12 +390,15 @@ func initCli() bool {
 						return fmt.Errorf("JOB_MANAGER_UPLOAD_FILE environment variable is required")
 					}
 
-					// Check if JOB_ITEM_PROJECT_KEY environment variable is set for authentication
+					// The task token of the job, or JOB_ITEM_PROJECT_KEY for a job with expose_project_key
 					projectKey := os.Getenv("JOB_ITEM_PROJECT_KEY")
 					if projectKey == "" {
-						fmt.Printf("Error: JOB_ITEM_PROJECT_KEY environment variable is not set\n")
+						projectKey = os.Getenv("JOB_ITEM_TASK_TOKEN")
+					}
+					if projectKey == "" {
+						fmt.Printf("Error: JOB_ITEM_TASK_TOKEN or JOB_ITEM_PROJECT_KEY environment variable is not set\n")
 						fmt.Printf("Please set JOB_ITEM_PROJECT_KEY (e.g., export JOB_ITEM_PROJECT_KEY=your-secret-key)\n")
-						return fmt.Errorf("JOB_ITEM_PROJECT_KEY environment variable is required")
+						return fmt.Errorf("JOB_ITEM_TASK_TOKEN or JOB_ITEM_PROJECT_KEY environment variable is required")
 					}
 
 					// Get file path from command line arguments
@@ -449,10 +538,11 @@ func initCli() bool {
 					supportSupport.Register(harwareInfoSuppport)
 
 					// Print the broker connection details in a structured format for debugging and verification
-					brokerConnection := configYamlSupport.ConfigData.Broker_connection
-					support.Helper.PrintGroupName("Broker Connection Details:")
-					for key, value := range brokerConnection {
-						support.Helper.PrintGroupName(fmt.Sprintf("  %s: %v", key, value))
+					for _, brokerConnection := range configYamlSupport.GetBrokerConnections() {
+						support.Helper.PrintGroupName("Broker Connection Details:")
+						for key, value := range brokerConnection {
+							support.Helper.PrintGroupName(fmt.Sprintf("  %s: %v", key, support.MaskBrokerConnectionValue(key, value)))
+						}
 					}
 
 					// Initialize broker connection support
@@ -461,39 +551,36 @@ func initCli() bool {
 					brokerConnectionSupport := initBrokerConnections(&configYamlSupport)
 					supportSupport.Register(brokerConnectionSupport)
 
+					// Each connection listens only the job routed to it
+					listenUpdateEvent := event.ListenUpdateFromServerEventConstruct()
+					for _, brokCon := range configYamlSupport.GetBrokerConnections() {
+						brokerKey := support.GetBrokerConnectionKey(brokCon)
+						jobManagerEvent := event.JobManagerEventConstruct()
+						jobManagerEvent.ListenEvent(brokerKey)
+						listenUpdateEvent.Job_events[brokerKey] = &jobManagerEvent
+					}
+					// Apply the config pushed by the Job Manager without restarting
+					listenUpdateEvent.ListenUpdate()
+
+					// The main process asks it after an update, a new version not healthy is rolled back
+					if healthAddr := os.Getenv(support.CHILD_HEALTH_ENV); healthAddr != "" {
+						go serveChildHealth(healthAddr)
+					}
+
 					// Check the own event have regsiter to job manager event
-					jobManagerEvent := event.JobManagerEventConstruct()
-					postOwnInfoEvent := event.ListenOwnHardwareInfoEvent{}
-					brokCon := configYamlSupport.ConfigData.Broker_connection
-					switch brokCon["type"].(string) {
-					case "nats":
-						// Init nats broker.
-						jobManagerEvent.ListenEvent(brokCon["key"].(string))
-						if support.Helper.ConfigYaml.ConfigData.End_point != "" {
-							postOwnInfoEvent.ListenInfoHardware(brokCon["key"].(string))
-							postOwnInfoEvent.ListenInfoNetwork(brokCon["key"].(string))
-							postOwnInfoEvent.ListenInfoUsage(brokCon["key"].(string))
-						}
-					case "rabbitmq":
-						// Init rabbitmq broker.
-						jobManagerEvent.ListenEvent(brokCon["key"].(string))
-						if support.Helper.ConfigYaml.ConfigData.End_point != "" {
-							postOwnInfoEvent.ListenInfoHardware(brokCon["key"].(string))
-							postOwnInfoEvent.ListenInfoNetwork(brokCon["key"].(string))
-							postOwnInfoEvent.ListenInfoUsage(brokCon["key"].(string))
-						}
-					case "redis":
-						// Init redis broker.
-						jobManagerEvent.ListenEvent(brokCon["key"].(string))
-						if support.Helper.ConfigYaml.ConfigData.End_point != "" {
-							postOwnInfoEvent.ListenInfoHardware(brokCon["key"].(string))
-							postOwnInfoEvent.ListenInfoNetwork(brokCon["key"].(string))
-							postOwnInfoEvent.ListenInfoUsage(brokCon["key"].(string))
-						}
+					if support.Helper.ConfigYaml.ConfigData.End_point != "" {
+						postOwnInfoEvent := event.ListenOwnHardwareInfoEvent{}
+						telemetryKey := configYamlSupport.GetTelemetryConnectionKey()
+						postOwnInfoEvent.ListenInfoHardware(telemetryKey)
+						postOwnInfoEvent.ListenInfoNetwork(telemetryKey)
+						postOwnInfoEvent.ListenInfoUsage(telemetryKey)
 					}
 
 					supportSupport.PrintGroupName("Job Item is running :)")
 
+					// Running from the config cache, go back to the Job Manager once it answers
+					go configYamlSupport.RefreshFromServer()
+
 					// This function listens for the "job_item_restart" event on the event bus.
 					// When the event is triggered, it attempts to save the current configuration file (config.yaml)
 					// without making any changes to its content. This is used to restart the process because
@@ -554,10 +641,14 @@ func initCli() bool {
 
 					var cmdExecArr []*exec.Cmd
 					configYamlSupport.RunExecsProcess(&cmdExecArr)
-					if len(cmdExecArr) == 0 {
+					// The Job Manager can push a new exec later
+					if len(cmdExecArr) == 0 && configYamlSupport.ConfigData.End_point == "" {
 						fmt.Println("Nothing to do")
 						return nil
 					}
+					listenUpdateEvent := event.ListenUpdateFromServerEventConstruct()
+					listenUpdateEvent.Exec_cmds = &cmdExecArr
+					listenUpdateEvent.ListenUpdate()
 
 					// Start Listening for signals to gracefully shut down the process
 					sig := make(chan os.Signal, 1)
@@ -572,16 +663,446 @@ func initCli() bool {
 					return nil
 				},
 			},
+			{
+				Flags: append([]cli.Flag{
+					&cli.BoolFlag{
+						Name:  "local",
+						Usage: "validate the local config only, without the Job Manager data",
+					},
+				}, flagConfig...),
+				Name:  "validate",
+				Usage: "validate the config and print every problem without starting anything",
+				Action: func(ctx *cli.Context) error {
+					flag = "validate"
+					supportSupport := support.SupportConstruct("Validate")
+					supportSupport.Register(support.HardwareInfoSupportConstruct())
+					_, err := support.ConfigYamlSupportContruct(support.ConfigYamlSupportConstructPropsType{
+						Config_path: ctx.String("config"),
+						Local_only:  ctx.Bool("local"),
+					})
+					if err != nil {
+						printConfigErrors(support.AsConfigErrors(err))
+						return cli.Exit("", 1)
+					}
+					fmt.Println("✓ Configuration is valid")
+					return nil
+				},
+			},
+			{
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:     "event",
+						Usage:    "event of the job to render",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "payload",
+						Usage:    "JSON file of the data of the message, like the form_body of /job/create",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "task-id",
+						Usage: "task_id given to the job, default is a new UUID",
+					},
+					&cli.BoolFlag{
+						Name:  "run",
+						Usage: "run the rendered command locally with its output on the terminal",
+					},
+					&cli.BoolFlag{
+						Name:  "local",
+						Usage: "use the local config only, without the Job Manager data",
+					},
+				}, flagConfig...),
+				Name:  "render",
+				Usage: "render the command of the job for a sample payload without publishing anything",
+				Action: func(ctx *cli.Context) error {
+					flag = "render"
+					supportSupport := support.SupportConstruct("Render")
+					supportSupport.Register(support.HardwareInfoSupportConstruct())
+					configYamlSupport, err := support.ConfigYamlSupportContruct(support.ConfigYamlSupportConstructPropsType{
+						Config_path: ctx.String("config"),
+						Local_only:  ctx.Bool("local"),
+					})
+					if err != nil {
+						printConfigErrors(support.AsConfigErrors(err))
+						return cli.Exit("", 1)
+					}
+
+					payload, err := os.ReadFile(ctx.String("payload"))
+					if err != nil {
+						return cli.Exit("Error reading payload: "+err.Error(), 1)
+					}
+					var data interface{}
+					if err := json.Unmarshal(payload, &data); err != nil {
+						return cli.Exit("Error parsing payload: "+err.Error(), 1)
+					}
+					taskId := ctx.String("task-id")
+					if taskId == "" {
+						taskId, err = helper.GenerateUUIDv7()
+						if err != nil {
+							return cli.Exit("Error generating task_id: "+err.Error(), 1)
+						}
+					}
+					messageObject := event.MessageJson{Task_id: taskId, Data: data}
+					if values, ok := data.(map[string]interface{}); ok {
+						for key, value := range values {
+							if _, ok := value.(string); !ok {
+								fmt.Println("WARNING: " + key + " of the payload is not a string, the template does not get it")
+							}
+						}
+					}
+
+					jobs := []support.ConfigJob{}
+					for _, v := range configYamlSupport.ConfigData.Jobs {
+						if v.Event == ctx.String("event") {
+							jobs = append(jobs, v)
+						}
+					}
+					if len(jobs) == 0 {
+						return cli.Exit("No job listens on event "+ctx.String("event"), 1)
+					}
+
+					exitCode := 0
+					for _, job := range jobs {
+						command, err := event.RenderJobCommand(job.Cmd, messageObject)
+						if err != nil {
+							return cli.Exit("Error rendering the command: "+err.Error(), 1)
+						}
+						env := event.GetJobCommandEnv(configYamlSupport.ConfigData, job, taskId)
+
+						if key := configYamlSupport.GetJobConnectionKey(job); key != "" {
+							fmt.Println("Job :: " + job.Name + " (connection " + key + ")")
+						} else {
+							fmt.Println("Job :: " + job.Name)
+						}
+						if len(configYamlSupport.GetJobEvents(job)) == 0 {
+							fmt.Println("WARNING: the event is not registered on the Job Manager, the worker does not subscribe this job")
+						}
+						fmt.Println("Command :: " + support.MaskSecrets(command))
+						fmt.Println("Env ::")
+						for _, v := range env {
+							// The credential of the task is not printed
+							if strings.HasPrefix(v, "JOB_ITEM_PROJECT_KEY=") || strings.HasPrefix(v, "JOB_ITEM_TASK_TOKEN=") {
+								v = strings.SplitN(v, "=", 2)[0] + "=******"
+							}
+							fmt.Println("  " + support.MaskSecrets(v))
+						}
+						if !ctx.Bool("run") {
+							continue
+						}
+
+						// The job reads its data from <task_id>.json like on the worker
+						dataString, _ := json.Marshal(messageObject.Data)
+						dataFile := fmt.Sprint(taskId, ".json")
+						if err := os.WriteFile(dataFile, dataString, 0600); err != nil {
+							return cli.Exit("Error writing "+dataFile+": "+err.Error(), 1)
+						}
+						fmt.Println("Run ::")
+						cmd := event.NewJobCommand(command)
+						cmd.Env = env
+						cmd.Stdin = os.Stdin
+						cmd.Stdout = os.Stdout
+						cmd.Stderr = os.Stderr
+						err = cmd.Run()
+						os.Remove(dataFile)
+						var exitErr *exec.ExitError
+						if errors.As(err, &exitErr) {
+							exitCode = exitErr.ExitCode()
+						} else if err != nil {
+							return cli.Exit("Error running the command: "+err.Error(), 1)
+						}
+						fmt.Println("Exit code ::", cmd.ProcessState.ExitCode())
+					}
+					if exitCode != 0 {
+						return cli.Exit("", exitCode)
+					}
+					return nil
+				},
+			},
+			{
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:     "payload",
+						Usage:    "JSON file of the data of the message, like the form_body of /job/create",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "task-id",
+						Usage: "task_id of the task, default is a new UUID",
+					},
+					&cli.IntFlag{
+						Name:  "timeout",
+						Usage: "second before the task gets the timeout action like from the Job Manager, 0 is no timeout",
+					},
+					&cli.BoolFlag{
+						Name:  "local",
+						Usage: "use the local config only, without the Job Manager data",
+					},
+				}, flagConfig...),
+				Name:      "run",
+				Usage:     "run a task of the job on an in-process broker and print every message it publishes",
+				ArgsUsage: "<event>",
+				Action: func(ctx *cli.Context) error {
+					flag = "run"
+					eventName := ctx.Args().First()
+					if eventName == "" {
+						return cli.Exit("Usage: job_item run --payload=payload.json <event>", 1)
+					}
+					supportSupport := support.SupportConstruct("Run")
+					supportSupport.Register(support.HardwareInfoSupportConstruct())
+					configYamlSupport, err := support.ConfigYamlSupportContruct(support.ConfigYamlSupportConstructPropsType{
+						Config_path: ctx.String("config"),
+						Local_only:  ctx.Bool("local"),
+					})
+					if err != nil {
+						printConfigErrors(support.AsConfigErrors(err))
+						return cli.Exit("", 1)
+					}
+					supportSupport.Register(configYamlSupport)
+					supportSupport.Register(support.EventBusConstruct())
+
+					payload, err := os.ReadFile(ctx.String("payload"))
+					if err != nil {
+						return cli.Exit("Error reading payload: "+err.Error(), 1)
+					}
+					var data interface{}
+					if err := json.Unmarshal(payload, &data); err != nil {
+						return cli.Exit("Error parsing payload: "+err.Error(), 1)
+					}
+					taskId := ctx.String("task-id")
+					if taskId == "" {
+						taskId, err = helper.GenerateUUIDv7()
+						if err != nil {
+							return cli.Exit("Error generating task_id: "+err.Error(), 1)
+						}
+					}
+
+					// The job of the event runs even when the event is not registered on the Job Manager
+					found := false
+					for i, v := range configYamlSupport.ConfigData.Jobs {
+						if v.Event != eventName {
+							continue
+						}
+						found = true
+						if len(configYamlSupport.GetJobEvents(v)) == 0 {
+							fmt.Println("WARNING: the event is not registered on the Job Manager, the worker does not subscribe job " + v.Name)
+							configYamlSupport.ConfigData.Jobs[i].Local = true
+						}
+					}
+					if !found {
+						return cli.Exit("No job listens on event "+eventName, 1)
+					}
+
+					// Every broker connection of the config is a client of one in-process broker,
+					// what the job publishes is printed instead of reaching the Job Manager
+					server := support.MemoryBrokerServerConstruct()
+					brokerConnectionSupport := support.BrokerConnectionSupportContruct()
+					keys := []string{configYamlSupport.GetDefaultBrokerKey()}
+					for _, v := range configYamlSupport.ConfigData.Jobs {
+						keys = append(keys, configYamlSupport.GetJobConnectionKey(v))
+					}
+					connKeys := []string{}
+					for _, key := range keys {
+						if brokerConnectionSupport.GetConnection(key) != nil {
+							continue
+						}
+						connKeys = append(connKeys, key)
+						conn := support.MemorySupportConstruct(server, key)
+						conn.OnPublish = func(topic string, msg string) {
+							fmt.Println("Publish :: " + support.TopicLogicalName(topic) + " :: " + support.MaskSecrets(msg))
+						}
+						brokerConnectionSupport.RegisterConnection(key, conn)
+					}
+					supportSupport.Register(brokerConnectionSupport)
+
+					// The job reaches the notification and the share data of this process like on the worker
+					ginSupport := support.GinConstruct()
+					supportSupport.Register(ginSupport)
+					ginInitialize(ginSupport.Router)
+					childEnv := map[string]string{}
+					for _, v := range configYamlSupport.GetEnv("") {
+						key, value, _ := strings.Cut(v, "=")
+						if key == support.CONFIG_HANDOFF_ENV || value == "" {
+							continue
+						}
+						// Not on this process, the share data controller would forward the data to itself
+						if strings.HasPrefix(key, "JOB_ITEM_SHARE_DATA_") {
+							childEnv[key] = value
+							continue
+						}
+						os.Setenv(key, value)
+					}
+					for i, v := range configYamlSupport.ConfigData.Jobs {
+						env := map[string]string{}
+						for key, value := range childEnv {
+							env[key] = value
+						}
+						for key, value := range v.Env {
+							env[key] = value
+						}
+						configYamlSupport.ConfigData.Jobs[i].Env = env
+					}
+
+					for _, key := range connKeys {
+						jobManagerEvent := event.JobManagerEventConstruct()
+						jobManagerEvent.ListenEvent(key)
+					}
+
+					// The task is a message on the event like from the Job Manager
+					conn := brokerConnectionSupport.GetConnection(configYamlSupport.GetEventConnectionKey(eventName))
+					finish := make(chan string, 1)
+					_, err = conn.BasicSub(support.TopicTaskFinish(taskId), func(message string) {
+						finish <- message
+					})
+					if err != nil {
+						return cli.Exit("Error subscribing to the task finish: "+err.Error(), 1)
+					}
+					message, _ := json.Marshal(event.MessageJson{Task_id: taskId, Data: data})
+					conn.Pub(support.TopicJobEvent(configYamlSupport.ConfigData.Uuid, eventName), string(message))
+
+					var timeout <-chan time.Time
+					if ctx.Int("timeout") > 0 {
+						timeout = time.After(time.Duration(ctx.Int("timeout")) * time.Second)
+					}
+					var status string
+					for status == "" {
+						select {
+						case status = <-finish:
+						case <-timeout:
+							action, _ := json.Marshal(event.MessageJson{Task_id: taskId, Action: event.GetStatus().STATUS_TIMEOUT})
+							conn.Pub(support.TopicTaskWorker(taskId), string(action))
+							timeout = nil
+						}
+					}
+					os.Remove(fmt.Sprint(taskId, ".json"))
+
+					fmt.Println("Share data ::")
+					shareData := helper.ShareDataAll(taskId)
+					shareKeys := []string{}
+					for key := range shareData {
+						shareKeys = append(shareKeys, key)
+					}
+					sort.Strings(shareKeys)
+					for _, key := range shareKeys {
+						for _, v := range shareData[key] {
+							fmt.Println("  " + key + " :: " + support.MaskSecrets(v))
+						}
+					}
+					fmt.Println("Task " + taskId + " :: " + status)
+					if status != event.GetStatus().STATUS_FINISH {
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+			},
+			{
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "key-file",
+						Usage:    "file of the base64 ed25519 private key",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:  "generate-key",
+						Usage: "write a new private key to --key-file and print its public key",
+					},
+					&cli.IntFlag{
+						Name:  "version",
+						Usage: "version number of the binary, the job_item_version_number given by the Job Manager",
+					},
+				},
+				Name:      "update-sign",
+				Usage:     "print the job_item_sha256 and job_item_signature of a binary for the self-update",
+				ArgsUsage: "<binary>",
+				Action: func(ctx *cli.Context) error {
+					flag = "update-sign"
+					if ctx.Bool("generate-key") {
+						if _, err := os.Stat(ctx.String("key-file")); err == nil {
+							return cli.Exit("The key file "+ctx.String("key-file")+" already exists", 1)
+						}
+						publicKey, privateKey, err := support.GenerateUpdateKey()
+						if err != nil {
+							return cli.Exit("Error generating the key: "+err.Error(), 1)
+						}
+						if err := os.WriteFile(ctx.String("key-file"), []byte(privateKey+"\n"), 0600); err != nil {
+							return cli.Exit("Error writing the key file: "+err.Error(), 1)
+						}
+						fmt.Println("Public key :: " + publicKey)
+						fmt.Println("Build with :: go build -ldflags \"-X job_item/support.UpdatePublicKey=" + publicKey + "\"")
+						return nil
+					}
+					if ctx.Args().First() == "" || ctx.Int("version") <= 0 {
+						return cli.Exit("Usage: job_item update-sign --key-file=update.key --version=<number> <binary>", 1)
+					}
+					privateKey, err := os.ReadFile(ctx.String("key-file"))
+					if err != nil {
+						return cli.Exit("Error reading the key file: "+err.Error(), 1)
+					}
+					sha256Hex, signature, err := support.SignUpdate(string(privateKey), ctx.Int("version"), ctx.Args().First())
+					if err != nil {
+						return cli.Exit("Error signing: "+err.Error(), 1)
+					}
+					fmt.Println("job_item_version_number :: " + strconv.Itoa(ctx.Int("version")))
+					fmt.Println("job_item_sha256 :: " + sha256Hex)
+					fmt.Println("job_item_signature :: " + signature)
+					return nil
+				},
+			},
 		},
 	}
 
-	if err := app.Run(os.Args); err != nil {
+	if brokerCheckCommand != nil {
+		app.Commands = append(app.Commands, brokerCheckCommand(flagConfig, &flag))
+	}
+
+	if err := app.Run(moveFlagsBeforeArgs(app, os.Args, "run")); err != nil {
 		log.Fatal(err)
 	}
 	// It mean bypass
 	return flag == ""
 }
 
+// moveFlagsBeforeArgs moves the flag given after the argument of the command before it,
+// urfave/cli stops reading the flag at the first argument, like "run <event> --payload=x".
+func moveFlagsBeforeArgs(app *cli.App, args []string, name string) []string {
+	if len(args) < 2 || args[1] != name {
+		return args
+	}
+	valueFlags := map[string]bool{}
+	for _, command := range app.Commands {
+		if command.Name != name {
+			continue
+		}
+		for _, v := range command.Flags {
+			if _, ok := v.(*cli.BoolFlag); ok {
+				continue
+			}
+			for _, flagName := range v.Names() {
+				valueFlags[flagName] = true
+			}
+		}
+	}
+	flags := []string{}
+	positional := []string{}
+	for i := 2; i < len(args); i++ {
+		if args[i] == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(args[i], "-") {
+			positional = append(positional, args[i])
+			continue
+		}
+		flags = append(flags, args[i])
+		flagName := strings.TrimLeft(args[i], "-")
+		if !strings.Contains(flagName, "=") && valueFlags[flagName] && i+1 < len(args) {
+			i++
+			flags = append(flags, args[i])
+		}
+	}
+	return append(append(append([]string{}, args[:2]...), flags...), positional...)
+}
+
 func saveWithoutChange(filePath string) error {
 	// Read the current content of the file
 	data, err := os.ReadFile(filePath)
@@ -703,6 +1224,9 @@ func ginInitialize(router *gin.Engine) {
 	router.POST("/msg/share/data/:task_id", shareDataController.AddShareData)
 	router.GET("/msg/share/data/:task_id/:key", shareDataController.GetShareData)
 
+	// Broker connection health state
+	router.GET("/health", jobitem.HealthHandler)
+
 	// This is for local app Communication
 	jobGroup := router.Group("/job")
 	{
@@ -710,75 +1234,63 @@ func ginInitialize(router *gin.Engine) {
 	}
 }
 
+// serveChildHealth serves only the /health of the child process, the broker connections of the child.
+func serveChildHealth(addr string) {
+	router := gin.New()
+	router.GET("/health", jobitem.HealthHandler)
+	if err := router.Run(addr); err != nil {
+		support.Helper.PrintErrName("Error serving the health of the child process: "+err.Error(), "ERR-50100903206")
+	}
+}
+
 func initBrokerConnections(configYamlSupport *support.ConfigYamlSupport) *support.BrokerConnectionSupport {
 	brokerConnectionSupport := support.BrokerConnectionSupportContruct()
-	currentConnection := configYamlSupport.ConfigData.Broker_connection
+	brokerConnections := configYamlSupport.GetBrokerConnections()
 
 	// Check if broker connection is properly configured
-	if currentConnection == nil {
+	if len(brokerConnections) == 0 {
 		fmt.Println("\n❌ Connection Refuse :")
 		fmt.Println("Please check the job manager server connection")
 		os.Exit(1)
 	}
 
-	// Check if broker type is specified
-	brokerType, ok := currentConnection["type"]
-	if !ok || brokerType == nil {
-		fmt.Println("\n❌ Configuration Error:")
-		fmt.Println("Broker connection type is not specified")
-		fmt.Println("Please configure the broker type (nats, rabbitmq, or redis) in the Job Manager")
-		os.Exit(1)
+	for _, currentConnection := range brokerConnections {
+		registerBrokerConnection(configYamlSupport, brokerConnectionSupport, currentConnection)
 	}
+	return brokerConnectionSupport
+}
 
+func registerBrokerConnection(configYamlSupport *support.ConfigYamlSupport, brokerConnectionSupport *support.BrokerConnectionSupport, currentConnection map[string]interface{}) {
 	// Check if broker key is specified
-	brokerKey, ok := currentConnection["key"]
-	if !ok || brokerKey == nil {
+	brokerKey := support.GetBrokerConnectionKey(currentConnection)
+	if brokerKey == "" {
 		fmt.Println("\n❌ Configuration Error:")
 		fmt.Println("Broker connection key is not specified")
 		fmt.Println("Please configure the broker connection key in the Job Manager")
 		os.Exit(1)
 	}
 
-	switch brokerType.(string) {
-	case "nats":
-		natsBrokerCon := configYamlSupport.GetNatsBrokerCon(configYamlSupport.GetTypeBrokerCon(currentConnection))
-		tryRestartProcess(5, func() bool {
-			natSupport, err := support.NatsSupportConstruct(natsBrokerCon)
-			if err != nil {
-				return true
-			}
-			var gg *support.NatsSupport = &natSupport
-			brokerConnectionSupport.RegisterConnection(brokerKey.(string), gg)
-			return false
-		})
-	case "rabbitmq":
-		amqpBrokerCon := configYamlSupport.GetRabbitMQBrokenCon(configYamlSupport.GetTypeBrokerCon(currentConnection))
-		tryRestartProcess(5, func() bool {
-			amqpSupport, err := support.AMQPSupportConstruct(amqpBrokerCon)
-			if err != nil {
-				return true
-			}
-			var gg *support.AMQPSupport = amqpSupport
-			brokerConnectionSupport.RegisterConnection(brokerKey.(string), gg)
-			return false
-		})
-	case "redis":
-		redisBrokerCon := configYamlSupport.GetRedisBrokerCon(configYamlSupport.GetTypeBrokerCon(currentConnection))
-		tryRestartProcess(5, func() bool {
-			redisSupport, err := support.NewRedisSupportConstruct(redisBrokerCon)
-			if err != nil {
-				return true
-			}
-			var gg *support.RedisSupport = redisSupport
-			brokerConnectionSupport.RegisterConnection(brokerKey.(string), gg)
-			return false
-		})
-	default:
-		fmt.Println("\n❌ Configuration Error:")
-		fmt.Printf("Unsupported broker type: %v\n", brokerType)
-		fmt.Println("Supported broker types: nats, rabbitmq, redis")
-		fmt.Println("Please select a valid broker connection in the Job Manager")
+	brokerConnectionSupport.RegisterConnection(brokerKey, newBrokerConnection(configYamlSupport, currentConnection))
+}
+
+// newBrokerConnection opens a new connection from the broker connection configuration,
+// retrying until the broker accepts it. The broker type is looked up in the registry of the support package.
+func newBrokerConnection(configYamlSupport *support.ConfigYamlSupport, currentConnection map[string]interface{}) support.BrokerConnectionInterface {
+	brokerCon, err := configYamlSupport.GetTypeBrokerCon(currentConnection)
+	if err != nil {
+		printConfigErrors(support.AsConfigErrors(err))
+		fmt.Println("Please fix the broker connection in the Job Manager")
 		os.Exit(1)
 	}
-	return brokerConnectionSupport
+
+	var conn support.BrokerConnectionInterface
+	tryRestartProcess(5, func() bool {
+		newConn, err := support.NewBrokerConnection(brokerCon)
+		if err != nil {
+			return true
+		}
+		conn = newConn
+		return false
+	})
+	return conn
 }
@@ -0,0 +1,104 @@
+//go:build brokercheck
+
+package main
+
+import (
+	"fmt"
+	"job_item/support"
+	"job_item/support/brokertest"
+	"os/exec"
+
+	"github.com/urfave/cli/v2"
+)
+
+// go build -tags brokercheck adds the broker-check command
+func init() {
+	brokerCheckCommand = newBrokerCheckCommand
+}
+
+func newBrokerCheckCommand(flagConfig []cli.Flag, flag *string) *cli.Command {
+	return &cli.Command{
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:  "connection",
+				Usage: "broker connection key to check, default is the default broker connection",
+			},
+			&cli.BoolFlag{
+				Name:  "memory",
+				Usage: "check the in-memory broker without config",
+			},
+			&cli.StringFlag{
+				Name:  "restart-cmd",
+				Usage: "shell command that restarts the broker for the reconnect check",
+			},
+		}, flagConfig...),
+		Name:  "broker-check",
+		Usage: "run the broker contract check against a broker connection",
+		Action: func(ctx *cli.Context) error {
+			*flag = "broker-check"
+			support.SupportConstruct("BrokerCheck")
+			support.Helper.Register(support.EventBusConstruct())
+
+			var factory brokertest.Factory
+			opts := brokertest.Options{}
+			if ctx.Bool("memory") {
+				server := support.MemoryBrokerServerConstruct()
+				factory = func() (support.BrokerConnectionInterface, error) {
+					return support.MemorySupportConstruct(server, "memory"), nil
+				}
+				opts.Restart = func() error {
+					server.Restart()
+					return nil
+				}
+			} else {
+				var configYamlSupport support.ConfigYamlSupport
+				err := initializeConfigYamlSupport(&configYamlSupport, ctx.String("config"))
+				if err != nil {
+					return err
+				}
+				support.Helper.Register(&configYamlSupport)
+
+				key := ctx.String("connection")
+				if key == "" {
+					key = configYamlSupport.GetDefaultBrokerKey()
+				}
+				var currentConnection map[string]interface{}
+				for _, v := range configYamlSupport.GetBrokerConnections() {
+					if support.GetBrokerConnectionKey(v) == key {
+						currentConnection = v
+					}
+				}
+				if currentConnection == nil {
+					return fmt.Errorf("broker connection %q is not found", key)
+				}
+				factory = func() (support.BrokerConnectionInterface, error) {
+					conn := newBrokerConnection(&configYamlSupport, currentConnection)
+					conn.SetKey_P(key)
+					return conn, nil
+				}
+				if restartCmd := ctx.String("restart-cmd"); restartCmd != "" {
+					opts.Restart = func() error {
+						return exec.Command("sh", "-c", restartCmd).Run()
+					}
+				}
+			}
+
+			failed := 0
+			for _, result := range brokertest.Run(factory, opts) {
+				switch {
+				case result.Skipped:
+					fmt.Println("SKIP", result.Name)
+				case result.Err != nil:
+					failed++
+					fmt.Println("FAIL", result.Name, "::", result.Err)
+				default:
+					fmt.Println("PASS", result.Name)
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d broker check failed", failed)
+			}
+			return nil
+		},
+	}
+}
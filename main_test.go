@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runMain runs the job_item command in a new process of the test binary.
+func runMain(t *testing.T, args ...string) (string, int) {
+	cmd := exec.Command(os.Args[0], "-test.run=TestMainProcess")
+	cmd.Env = append(os.Environ(), "JOB_ITEM_TEST_MAIN_ARGS="+strings.Join(args, "\n"))
+	out, err := cmd.CombinedOutput()
+	var exitError *exec.ExitError
+	if errors.As(err, &exitError) {
+		return string(out), exitError.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out), 0
+}
+
+func writeTestFile(t *testing.T, path string, content string) {
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMainProcess is the process started by runMain, it does nothing on its own.
+func TestMainProcess(t *testing.T) {
+	args := os.Getenv("JOB_ITEM_TEST_MAIN_ARGS")
+	if args == "" {
+		return
+	}
+	os.Args = append([]string{"job_item"}, strings.Split(args, "\n")...)
+	main()
+}
+
+func TestValidateCommand(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.yaml")
+	invalid := filepath.Join(dir, "invalid.yaml")
+	writeTestFile(t, valid, `
+credential:
+  project_id: project
+broker_connection:
+  key: main
+  name: main
+  type: nats
+  auth_type: none
+  host: localhost
+  port: 4222
+`)
+	writeTestFile(t, invalid, `
+credential:
+  project_id: ""
+broker_connection:
+  key: main
+  name: main
+  type: nats
+  auth_type: none
+  host: localhost
+  port: nats-port
+jobs:
+  - event: backup
+`)
+
+	out, code := runMain(t, "validate", "--local", "--config="+valid)
+	if code != 0 || !strings.Contains(out, "Configuration is valid") {
+		t.Fatalf("valid config exited %d:\n%s", code, out)
+	}
+
+	out, code = runMain(t, "validate", "--local", "--config="+invalid)
+	if code == 0 {
+		t.Fatalf("invalid config exited 0:\n%s", out)
+	}
+	for _, want := range []string{
+		"credential.project_id: is required",
+		"broker_connection.port: expected number, got string",
+		"jobs[0].cmd: is required",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output misses %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package jobitem
+
+import (
+	"job_item/support"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HealthHandler reports the health state of every broker connection
+// and where the running config comes from.
+func HealthHandler(c *gin.Context) {
+	status := "ok"
+	brokers := []gin.H{}
+	if support.Helper.BrokerConnection != nil {
+		for _, conn := range support.Helper.BrokerConnection.GetConnections() {
+			connected := conn.IsConnected()
+			if !connected {
+				status = "degraded"
+			}
+			broker := gin.H{
+				"key":       conn.GetKey_P(),
+				"connected": connected,
+			}
+			if serverInfo, ok := conn.(support.BrokerServerInfoInterface); ok {
+				broker["server"] = serverInfo.GetConnectedServer()
+			}
+			brokers = append(brokers, broker)
+		}
+	}
+
+	config := gin.H{}
+	if support.Helper.ConfigYaml != nil {
+		source, cachedAt := support.Helper.ConfigYaml.GetConfigSource()
+		config["source"] = source
+		// Still serving the job, only the config may be old
+		if source == support.CONFIG_SOURCE_CACHE {
+			config["cached_at"] = cachedAt
+			config["message"] = "running from cached config of " + cachedAt.Format(time.RFC3339)
+		}
+	}
+
+	httpStatus := http.StatusOK
+	if status != "ok" {
+		httpStatus = http.StatusServiceUnavailable
+	}
+	c.JSON(httpStatus, gin.H{
+		"status":  status,
+		"brokers": brokers,
+		"config":  config,
+	})
+}
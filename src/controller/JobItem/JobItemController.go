@@ -2,7 +2,6 @@ package jobitem
 
 import (
 	"encoding/json"
-	"fmt"
 	"job_item/src/helper"
 	"job_item/support"
 	"net/http"
@@ -22,7 +21,8 @@ func CreateJobHandler(c *gin.Context) {
 		return
 	}
 
-	conn := support.Helper.BrokerConnection.GetConnection(support.Helper.ConfigYaml.ConfigData.Broker_connection["key"].(string))
+	// Publish on the connection the job of the event listens on
+	conn := support.Helper.BrokerConnection.GetConnection(support.Helper.ConfigYaml.GetEventConnectionKey(jobRequest.Event))
 	if conn == nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "message": "failed to get broker connection"})
 		return
@@ -47,12 +47,12 @@ func CreateJobHandler(c *gin.Context) {
 		return
 	}
 
-	conn.Pub(fmt.Sprintf("%s.%s", jobRequest.AppId, jobRequest.Event), string(formBodyString))
+	conn.Pub(support.TopicJobEvent(jobRequest.AppId, jobRequest.Event), string(formBodyString))
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "success",
 		"message": "Job created successfully!",
 		"job":     jobRequest,
-		"app_id":  support.Helper.ConfigYaml.ConfigData.Uuid,
+		"app_id":  support.Helper.ConfigYaml.GetConfigData().Uuid,
 	})
 }
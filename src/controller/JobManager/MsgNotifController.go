@@ -2,7 +2,6 @@ package jobmanager
 
 import (
 	"encoding/json"
-	"fmt"
 	"job_item/support"
 	"net/http"
 	"time"
@@ -32,8 +31,7 @@ func (c *MsgNotifController) AddNotif(ctx *gin.Context) {
 		return
 	}
 
-	currentConnection := support.Helper.ConfigYaml.ConfigData.Broker_connection
-	conn := support.Helper.BrokerConnection.GetConnection(currentConnection["key"].(string))
+	conn := support.Helper.BrokerConnection.GetConnection(support.Helper.ConfigYaml.GetDefaultBrokerKey())
 	if conn == nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get broker connection"})
 		return
@@ -49,7 +47,7 @@ func (c *MsgNotifController) AddNotif(ctx *gin.Context) {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to marshal payload"})
 		return
 	}
-	conn.Pub(fmt.Sprint(task_id, ".", "notif_add"), string(jsonBytes))
+	conn.Pub(support.TopicTaskNotifAdd(task_id), string(jsonBytes))
 
 	// Process the validated data
 	ctx.JSON(http.StatusOK, gin.H{"status": "success", "return": req})
@@ -0,0 +1,66 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"job_item/support"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/hoisie/mustache"
+)
+
+// RenderJobCommand renders the cmd of the job with the data of the message.
+// The value of an object is given to the template with the task_id, a list only gives the task_id.
+func RenderJobCommand(template string, messageObject MessageJson) (string, error) {
+	if _, ok := messageObject.Data.([]interface{}); ok {
+		return mustache.Render(template, map[string]string{"task_id": messageObject.Task_id}), nil
+	}
+	messageObjectParse := map[string]string{}
+	jsonData, err := json.Marshal(messageObject.Data)
+	if err != nil {
+		return "", err
+	}
+	// A value that is not a string is not given to the template
+	json.Unmarshal([]byte(jsonData), &messageObjectParse)
+	messageObjectParse["task_id"] = messageObject.Task_id
+	return mustache.Render(template, messageObjectParse), nil
+}
+
+// GetJobCommandEnv returns the env of the job process, the allowed variable of the worker,
+// the task variable and the env of the job.
+func GetJobCommandEnv(configData support.ConfigData, job support.ConfigJob, task_id string) []string {
+	// Only the allowed variable of the worker, the job asks for a secret on its env
+	envInvolve := append(support.GetJobProcessEnv(configData.Job_env.Allow),
+		// For child processes
+		// You need replace :task_id with the actual task ID on the child process
+		"JOB_MANAGER_HOST="+configData.End_point,
+		"JOB_MANAGER_RESULT_URL="+configData.End_point+"/api/worker/job_record/result/"+task_id,
+		"JOB_MANAGER_UPLOAD_FILE="+configData.End_point+"/api/worker/job_record/file/"+task_id,
+		"JOB_ITEM_TASK_ID="+task_id,
+		"JOB_ITEM_PROJECT_ID="+configData.Credential.Project_id,
+		"JOB_ITEM_MSG_NOTIF_HOST="+os.Getenv("JOB_ITEM_BASE_URL")+"/msg/notif/"+task_id,
+	)
+	// A token signed with an empty key proves nothing
+	if configData.Credential.Secret_key != "" {
+		taskToken := support.NewTaskToken(configData.Credential.Secret_key, configData.Credential.Project_id, task_id, configData.Job_env.GetTaskTokenTtl())
+		envInvolve = append(envInvolve, "JOB_ITEM_TASK_TOKEN="+taskToken)
+	}
+	// Only the job asking for it gets the project secret key
+	if job.Expose_project_key {
+		envInvolve = append(envInvolve, support.JOB_PROJECT_KEY_ENV+"="+configData.Credential.Secret_key)
+	}
+	for key, value := range job.Env {
+		envInvolve = append(envInvolve, fmt.Sprintf("%s=%s", key, value))
+	}
+	return envInvolve
+}
+
+// NewJobCommand returns the shell command running the rendered cmd of the job.
+func NewJobCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/K", command)
+	}
+	return exec.Command("bash", "-c", command)
+}
@@ -0,0 +1,52 @@
+package event
+
+import (
+	"strings"
+	"testing"
+
+	support "job_item/support"
+)
+
+func jobEnvValue(env []string, name string) (string, bool) {
+	for _, v := range env {
+		if key, value, ok := strings.Cut(v, "="); ok && key == name {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+func TestGetJobCommandEnv(t *testing.T) {
+	// A project key on the worker env is never passed through the allow list
+	t.Setenv("JOB_ITEM_PROJECT_KEY", "from-worker")
+	configData := support.ConfigData{}
+	configData.Credential.Project_id = "project"
+	configData.Credential.Secret_key = "key"
+	configData.Job_env.Allow = []string{"JOB_ITEM_*"}
+	job := support.ConfigJob{Event: "backup", Env: map[string]string{"TARGET": "s3"}}
+
+	env := GetJobCommandEnv(configData, job, "task")
+	if v, ok := jobEnvValue(env, "JOB_ITEM_PROJECT_KEY"); ok {
+		t.Errorf("JOB_ITEM_PROJECT_KEY is %q without expose_project_key", v)
+	}
+	token, _ := jobEnvValue(env, "JOB_ITEM_TASK_TOKEN")
+	claims, err := support.VerifyTaskToken("key", token)
+	if err != nil || claims.Task_id != "task" || claims.Project_id != "project" {
+		t.Errorf("JOB_ITEM_TASK_TOKEN: %+v, %v", claims, err)
+	}
+	if v, _ := jobEnvValue(env, "TARGET"); v != "s3" {
+		t.Errorf("env of the job is %q, want s3", v)
+	}
+
+	job.Expose_project_key = true
+	env = GetJobCommandEnv(configData, job, "task")
+	if v, _ := jobEnvValue(env, "JOB_ITEM_PROJECT_KEY"); v != "key" {
+		t.Errorf("JOB_ITEM_PROJECT_KEY is %q with expose_project_key, want the secret key", v)
+	}
+
+	configData.Credential.Secret_key = ""
+	env = GetJobCommandEnv(configData, support.ConfigJob{}, "task")
+	if _, ok := jobEnvValue(env, "JOB_ITEM_TASK_TOKEN"); ok {
+		t.Errorf("JOB_ITEM_TASK_TOKEN is set without secret key")
+	}
+}
@@ -8,10 +8,9 @@ import (
 	"log"
 	"os"
 	"os/exec"
-	"runtime"
+	"sync"
 	"time"
 
-	"github.com/hoisie/mustache"
 	"github.com/shirou/gopsutil/v3/host"
 )
 
@@ -32,16 +31,36 @@ type MessageJson struct {
 }
 
 func JobManagerEventConstruct() JobManagerEvent {
-	gg := JobManagerEvent{}
+	gg := JobManagerEvent{
+		job_unsubscribes: map[string][]func(){},
+		tasks:            &sync.WaitGroup{},
+		mutex:            &sync.RWMutex{},
+	}
 	return gg
 }
 
 type JobManagerEvent struct {
-	conn support.BrokerConnectionInterface
+	conn         support.BrokerConnectionInterface
+	unsubscribes []func()
+	// Unsubscribe of the job by its event
+	job_unsubscribes map[string][]func()
+	// Task running on the connection, the replaced connection is closed after them
+	tasks *sync.WaitGroup
+	// conn and closed are set by the refresh of the broker while the job message reads them
+	mutex  *sync.RWMutex
+	closed bool
+}
+
+// getConn returns the current connection of the key.
+func (c *JobManagerEvent) getConn() support.BrokerConnectionInterface {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.conn
 }
 
 // Helper function to subscribe and process job events
-func subscribeAndRunJobEvent(conn support.BrokerConnectionInterface, sub_key string, template string, project_app_uuid string, c *JobManagerEvent) (func(), error) {
+func subscribeAndRunJobEvent(conn support.BrokerConnectionInterface, sub_key string, jobConfig support.ConfigJob, project_app_uuid string, c *JobManagerEvent) (func(), error) {
+	template := jobConfig.Cmd
 	unsub, err := conn.Sub(sub_key, project_app_uuid, func(message string) {
 		go func(message string) {
 			messageObject := MessageJson{}
@@ -51,32 +70,37 @@ func subscribeAndRunJobEvent(conn support.BrokerConnectionInterface, sub_key str
 			f.WriteString(string(dataString))
 			f.Close()
 
+			// The task that does not run still ends with _failed and _finish,
+			// the Kafka broker commits the offset of the job message on _finish
+			failTask := func(err error) {
+				conn.Pub(support.TopicTaskFailed(messageObject.Task_id), err.Error())
+				conn.Pub(support.TopicTaskFinish(messageObject.Task_id), GetStatus().STATUS_ERROR)
+			}
+
 			hostInfo, err := host.Info()
 			if err != nil {
-				log.Fatalln(err)
+				log.Println("Error getting the host info:", err)
+				failTask(err)
 				return
 			}
-			conn.Pub(messageObject.Task_id+"_who", hostInfo.HostID)
-
-			var cmd string
-			if _, ok := messageObject.Data.([]interface{}); ok {
-				cmd = mustache.Render(template, map[string]string{"task_id": messageObject.Task_id})
-			} else {
-				var messageObjectParse map[string]string
-				jsonData, err := json.Marshal(messageObject.Data)
-				if err != nil {
-					fmt.Println("Error marshaling interface to JSON:", err)
-					return
-				}
-				json.Unmarshal([]byte(jsonData), &messageObjectParse)
-				messageObjectParse["task_id"] = messageObject.Task_id
-				cmd = mustache.Render(template, messageObjectParse)
+			conn.Pub(support.TopicTaskWho(messageObject.Task_id), hostInfo.HostID)
+
+			cmd, err := RenderJobCommand(template, messageObject)
+			if err != nil {
+				fmt.Println("Error marshaling interface to JSON:", err)
+				failTask(err)
+				return
 			}
 
 			jobManEvItem := JobManagerEventItem{
-				conn: c.conn,
+				conn: c.getConn(),
+				job:  jobConfig,
 			}
-			go jobManEvItem.RunGoroutine(cmd, messageObject.Task_id)
+			c.tasks.Add(1)
+			go func() {
+				defer c.tasks.Done()
+				jobManEvItem.RunGoroutine(cmd, messageObject.Task_id)
+			}()
 		}(message)
 	})
 	return unsub, err
@@ -95,89 +119,109 @@ func (c *JobManagerEvent) ListenEvent(conn_name string) {
 	// Then get the data connection by connection key
 	var initPubSubChannel = func() {
 		conn := support.Helper.BrokerConnection.GetConnection(conn_name)
+		c.mutex.Lock()
 		c.conn = conn
-		project_app_uuid := support.Helper.ConfigYaml.ConfigData.Uuid
-		job_datas := support.Helper.ConfigYaml.ConfigData.Project.Job_datas
-		jobs := support.Helper.ConfigYaml.ConfigData.Jobs
-		unsubcribes := []func(){}
-		if support.Helper.ConfigYaml.ConfigData.End_point == "" {
-			for _, v := range jobs {
-				jobConfig := v
-				sub_key := fmt.Sprint(project_app_uuid, ".", v.Event)
-				unsub, err := subscribeAndRunJobEvent(conn, sub_key, jobConfig.Cmd, project_app_uuid, c)
-				_ = append(unsubcribes, unsub)
-				if err != nil {
-					log.Println(err)
-				}
-			}
-		} else {
-			// Merged logic: for each job, check both top-level and nested job events
-			for _, v := range jobs {
-				jobConfig := v
-				isMatch := false
-				for _, x := range job_datas {
-					// Check top-level event
-					if x.Event == v.Event {
-						isMatch = true
-						sub_key := fmt.Sprint(project_app_uuid, ".", v.Event)
-						unsub, err := subscribeAndRunJobEvent(conn, sub_key, jobConfig.Cmd, project_app_uuid, c)
-						_ = append(unsubcribes, unsub)
-						if err != nil {
-							log.Println(err)
-						}
-					}
-					// Check nested events
-					if x.Nested_jobs != nil {
-						for _, nested := range *x.Nested_jobs {
-							nestedEvent := fmt.Sprint(x.Event, ".", nested.Event)
-							if nestedEvent == v.Event {
-								isMatch = true
-								sub_key := fmt.Sprint(project_app_uuid, ".", nestedEvent)
-								unsub, err := subscribeAndRunJobEvent(conn, sub_key, jobConfig.Cmd, project_app_uuid, c)
-								_ = append(unsubcribes, unsub)
-								if err != nil {
-									log.Println(err)
-								}
-							}
-						}
-					}
-				}
-				if !isMatch {
-					fmt.Println("Event :", v.Event, " not register yet. Please check on job manager with app project that you register it.")
-				}
-
+		c.mutex.Unlock()
+		configData := support.Helper.ConfigYaml.GetConfigData()
+		project_app_uuid := configData.Uuid
+		// Only the job routed to this connection
+		for _, v := range configData.Jobs {
+			if support.Helper.ConfigYaml.GetJobConnectionKey(v) == conn_name {
+				c.SubscribeJob(v)
 			}
 		}
 		// Subscribe to the "restart" event for the current project application UUID.
 		// When the event is triggered, it publishes a "job_item_restart" event to the event bus.
 		// This is used to signal that the child process should be restarted.
 		// If the subscription fails, an error is logged. Otherwise, the unsubscribe function is added to the list.
-		unsub, err := conn.BasicSub(fmt.Sprint(project_app_uuid, ".", "restart"), func(message string) {
+		// Only the default connection listens the restart event.
+		if conn_name != support.Helper.ConfigYaml.GetDefaultBrokerKey() {
+			return
+		}
+		unsub, err := conn.BasicSub(support.TopicRestart(project_app_uuid), func(message string) {
 			fmt.Println("Received restart event for project_app_uuid:", project_app_uuid)
 			support.Helper.EventBus.GetBus().Publish("job_item_restart", nil)
 		})
 		if err != nil {
 			log.Println("Error subscribing to restart event:", err)
 		} else {
-			_ = append(unsubcribes, unsub)
+			c.unsubscribes = append(c.unsubscribes, unsub)
 		}
 	}
 	initPubSubChannel()
-	// Subscribe to a custom event (replace "custom_event" and handler as needed)
-	support.Helper.EventBus.GetBus().Subscribe(c.conn.GetRefreshPubSub(), func(data interface{}) {
-		fmt.Println("Received custom_event with data:", data)
-		initPubSubChannel()
+	// The broker connection re-establishes every subscription after reconnect,
+	// so the refresh only needs to pick the current connection.
+	// After Close the connection of the key belongs to the next JobManagerEvent.
+	support.Helper.EventBus.GetBus().Subscribe(c.getConn().GetRefreshPubSub(), func(data interface{}) {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if c.closed {
+			return
+		}
+		fmt.Println("Received refresh pubsub for connection:", conn_name)
+		c.conn = support.Helper.BrokerConnection.GetConnection(conn_name)
 	})
 }
 
+// SubscribeJob subscribes the job on every event it is registered with.
+func (c *JobManagerEvent) SubscribeJob(jobConfig support.ConfigJob) {
+	project_app_uuid := support.Helper.ConfigYaml.GetConfigData().Uuid
+	events := support.Helper.ConfigYaml.GetJobEvents(jobConfig)
+	if len(events) == 0 {
+		fmt.Println("Event :", jobConfig.Event, " not register yet. Please check on job manager with app project that you register it, or set local: true on a job private to this node.")
+		return
+	}
+	for _, event := range events {
+		sub_key := support.TopicJobEvent(project_app_uuid, event)
+		unsub, err := subscribeAndRunJobEvent(c.getConn(), sub_key, jobConfig, project_app_uuid, c)
+		if err != nil {
+			log.Println(err)
+		} else {
+			c.job_unsubscribes[jobConfig.Event] = append(c.job_unsubscribes[jobConfig.Event], unsub)
+		}
+	}
+}
+
+// UnsubscribeJob unsubscribes the job, the task already running keeps running.
+func (c *JobManagerEvent) UnsubscribeJob(jobConfig support.ConfigJob) {
+	for _, unsub := range c.job_unsubscribes[jobConfig.Event] {
+		unsub()
+	}
+	delete(c.job_unsubscribes, jobConfig.Event)
+}
+
+// Close unsubscribes every subscription made by ListenEvent.
+func (c *JobManagerEvent) Close() {
+	for event, unsubs := range c.job_unsubscribes {
+		for _, unsub := range unsubs {
+			unsub()
+		}
+		delete(c.job_unsubscribes, event)
+	}
+	for _, unsub := range c.unsubscribes {
+		unsub()
+	}
+	c.unsubscribes = nil
+	c.mutex.Lock()
+	c.closed = true
+	c.mutex.Unlock()
+}
+
+// WaitTasks waits every task started by the job of this connection.
+func (c *JobManagerEvent) WaitTasks() {
+	c.tasks.Wait()
+}
+
 type JobManagerEventItem struct {
-	conn        support.BrokerConnectionInterface
+	conn support.BrokerConnectionInterface
+	// The job config, its env is the only way for a job to get a secret
+	job         support.ConfigJob
 	Last_status string
 }
 
 func (c *JobManagerEventItem) RunGoroutine(command string, task_id string) {
-	project_app_uuid := support.Helper.ConfigYaml.ConfigData.Uuid
-	unsub, err := c.conn.Sub(task_id+"_worker", project_app_uuid, func(message string) {
+	project_app_uuid := support.Helper.ConfigYaml.GetConfigData().Uuid
+	unsub, err := c.conn.Sub(support.TopicTaskWorker(task_id), project_app_uuid, func(message string) {
 		// fmt.Println(sub_key, " :: ", message)
 		messageObject := MessageJson{}
 		json.Unmarshal([]byte(message), &messageObject)
@@ -193,11 +237,11 @@ func (c *JobManagerEventItem) RunGoroutine(command string, task_id string) {
 		log.Println("RunGoroutine :: err :: 23940239409 :: ", err)
 	}
 
-	unsubListen, err := c.conn.Sub(fmt.Sprint(task_id, "_", "listen"), project_app_uuid, func(message string) {
+	unsubListen, err := c.conn.Sub(support.TopicTaskListen(task_id), project_app_uuid, func(message string) {
 		// fmt.Println(sub_key, " :: ", message)
 		fmt.Println("message pubsub :: ", message)
 		time.Sleep(1 * time.Second)
-		c.conn.Pub(fmt.Sprint(task_id, "_", "listen")+".callback", "world")
+		c.conn.Pub(support.TopicTaskListenCallback(task_id), "world")
 	})
 	if err != nil {
 		log.Println("RunGoroutine :: err :: 23940239407 :: ", err)
@@ -209,28 +253,11 @@ func (c *JobManagerEventItem) RunGoroutine(command string, task_id string) {
 		unsubListen()
 		fmt.Println("Closed goroutine")
 		time.Sleep(time.Duration(time.Second) * 3)
-		c.conn.Pub(fmt.Sprint(task_id, "_", "finish"), *last_status)
+		c.conn.Pub(support.TopicTaskFinish(task_id), *last_status)
 	}(&c.Last_status)
 
-	var cmd *exec.Cmd
-	if runtime.GOOS == "windows" {
-		cmd = exec.Command("cmd", "/K", command)
-	} else {
-		cmd = exec.Command("bash", "-c", command)
-	}
-
-	envInvolve := append(os.Environ(),
-		// For child processes
-		// You need replace :task_id with the actual task ID on the child process
-		"JOB_MANAGER_HOST="+support.Helper.ConfigYaml.ConfigData.End_point,
-		"JOB_MANAGER_RESULT_URL="+support.Helper.ConfigYaml.ConfigData.End_point+"/api/worker/job_record/result/"+task_id,
-		"JOB_MANAGER_UPLOAD_FILE="+support.Helper.ConfigYaml.ConfigData.End_point+"/api/worker/job_record/file/"+task_id,
-		"JOB_ITEM_TASK_ID="+task_id,
-		"JOB_ITEM_PROJECT_ID="+support.Helper.ConfigYaml.ConfigData.Credential.Project_id,
-		"JOB_ITEM_PROJECT_KEY="+support.Helper.ConfigYaml.ConfigData.Credential.Secret_key,
-		"JOB_ITEM_MSG_NOTIF_HOST="+os.Getenv("JOB_ITEM_BASE_URL")+"/msg/notif/"+task_id,
-	)
-	cmd.Env = envInvolve
+	cmd := NewJobCommand(command)
+	cmd.Env = GetJobCommandEnv(support.Helper.ConfigYaml.GetConfigData(), c.job, task_id)
 	c.WatchProcessCMD(cmd, task_id)
 }
 
@@ -271,45 +298,49 @@ func (c *JobManagerEventItem) WatchProcessCMD(cmd *exec.Cmd, task_id string) {
 		return
 	}
 
-	out := make([]byte, 1024)
-
 	helper.ShareDataOption(task_id, helper.ShareDataOptions{
 		DefaultTTLSeconds: 36000000,
 	})
+	// Each reader has its own buffer, both are done before cmd.Wait closes the pipes
+	readers := sync.WaitGroup{}
+	readers.Add(2)
 	go func(conn support.BrokerConnectionInterface) {
+		defer readers.Done()
+		out := make([]byte, 1024)
 		isMatchErr := false
 		errString := ""
 		for {
 			n2, err2 := stderr.Read(out)
 
 			if err2 != nil {
-				fmt.Println("stderr err :: ", err)
+				fmt.Println("stderr err :: ", err2)
 				break
 			}
 
 			fmt.Println("stderr :: ", string(out[:n2]))
 
-			if support.Helper.ConfigYaml.ConfigData.End_point != "" {
-				// Add to share data with key ERROR_MESSAGE_STDERR
-				if !isMatchErr {
-					isMatchErr = true
-					errString = string(out[:n2])
-				}
-				if errString == string(out[:n2]) {
-					go func() {
-						helper.ShareDataAdd(task_id, "ERROR_MESSAGE_STDERR", errString, 0)
-						time.Sleep(1 * time.Second)
-						conn.Pub(task_id+"_failed", errString)
-					}()
-				}
-				// Write env ERROR_MESSAGE_STDERR
+			// Published without the Job Manager too, the caller of /job/create or `run` listens on it
+			// Add to share data with key ERROR_MESSAGE_STDERR
+			if !isMatchErr {
+				isMatchErr = true
+				errString = string(out[:n2])
 			}
+			if errString == string(out[:n2]) {
+				go func() {
+					helper.ShareDataAdd(task_id, "ERROR_MESSAGE_STDERR", errString, 0)
+					time.Sleep(1 * time.Second)
+					conn.Pub(support.TopicTaskFailed(task_id), errString)
+				}()
+			}
+			// Write env ERROR_MESSAGE_STDERR
 
 			c.Last_status = GetStatus().STATUS_ERROR
 		}
 	}(c.conn)
 
 	go func(conn support.BrokerConnectionInterface) {
+		defer readers.Done()
+		out := make([]byte, 1024)
 		for {
 			// reading the bytes
 			n, err := stdout.Read(out)
@@ -319,12 +350,11 @@ func (c *JobManagerEventItem) WatchProcessCMD(cmd *exec.Cmd, task_id string) {
 			}
 			fmt.Println("stdout :: ", string(out[:n]))
 			// conn.Pub(task_id+"_process", fmt.Sprint("stdout :: ", string(out[:n])))
-			if support.Helper.ConfigYaml.ConfigData.End_point != "" {
-				conn.Pub(task_id+"_process", string(out[:n]))
-			}
+			conn.Pub(support.TopicTaskProcess(task_id), string(out[:n]))
 		}
 	}(c.conn)
 
+	readers.Wait()
 	cmd.Wait()
 }
 
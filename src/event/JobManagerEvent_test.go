@@ -0,0 +1,116 @@
+package event
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	support "job_item/support"
+)
+
+// The live config update replaces the connection and closes the JobManagerEvent
+// while the job subscription and the broker refresh read them, go test -race checks it.
+func TestJobManagerEventLiveUpdate(t *testing.T) {
+	support.SupportConstruct("Test")
+	support.Helper.Register(support.EventBusConstruct())
+	configData := support.ConfigData{}
+	configData.Uuid = "project"
+	configData.Broker_connection = map[string]interface{}{"key": "main", "type": "nats"}
+	configData.Jobs = []support.ConfigJob{{Event: "backup", Cmd: "true"}}
+	support.Helper.Register(&support.ConfigYamlSupport{ConfigData: configData})
+	support.Helper.Register(support.BrokerConnectionSupportContruct())
+
+	server := support.MemoryBrokerServerConstruct()
+	brokerConnection := support.Helper.BrokerConnection
+	brokerConnection.RegisterConnection("main", support.MemorySupportConstruct(server, "main"))
+
+	jobManagerEvent := JobManagerEventConstruct()
+	jobManagerEvent.ListenEvent("main")
+
+	wg := sync.WaitGroup{}
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for range 50 {
+			old := brokerConnection.ReplaceConnection("main", support.MemorySupportConstruct(server, "main"))
+			support.Helper.EventBus.GetBus().Publish(support.BROKER_REFRESH_PUBSUB, nil)
+			if old != nil {
+				defer old.Close()
+			}
+		}
+		brokerConnection.RemoveConnection("other")
+	}()
+	go func() {
+		defer wg.Done()
+		for range 50 {
+			jobManagerEvent.SubscribeJob(configData.Jobs[0])
+			brokerConnection.GetConnections()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range 50 {
+			if brokerConnection.GetConnection("main") == nil {
+				t.Error("connection main is missing during the update")
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	jobManagerEvent.Close()
+	support.Helper.EventBus.GetBus().Publish(support.BROKER_REFRESH_PUBSUB, nil)
+}
+
+// Every stdout line is published before WatchProcessCMD returns, while stderr is read
+// at the same time, go test -race checks the two readers do not share their buffer.
+func TestJobManagerEventItemWatchProcess(t *testing.T) {
+	support.SupportConstruct("Test")
+	support.Helper.Register(support.EventBusConstruct())
+	support.Helper.Register(&support.ConfigYamlSupport{})
+
+	conn := support.MemorySupportConstruct(support.MemoryBrokerServerConstruct(), "main")
+	defer conn.Close()
+	mutex := sync.Mutex{}
+	stdout := strings.Builder{}
+	failed := make(chan string, 1)
+	conn.OnPublish = func(topic string, msg string) {
+		switch topic {
+		case support.TopicTaskProcess("watch"):
+			mutex.Lock()
+			stdout.WriteString(msg)
+			mutex.Unlock()
+		case support.TopicTaskFailed("watch"):
+			select {
+			case failed <- msg:
+			default:
+			}
+		}
+	}
+
+	item := JobManagerEventItem{conn: conn}
+	item.WatchProcessCMD(NewJobCommand("for i in $(seq 1 200); do echo out$i; echo err$i >&2; done"), "watch")
+
+	want := strings.Builder{}
+	for i := 1; i <= 200; i++ {
+		want.WriteString("out" + strconv.Itoa(i) + "\n")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if stdout.String() != want.String() {
+		t.Fatalf("published stdout %q, want the 200 lines", stdout.String())
+	}
+	if item.Last_status != GetStatus().STATUS_ERROR {
+		t.Fatalf("Last_status = %q, want error from the stderr", item.Last_status)
+	}
+	// The first stderr is published as _failed a second later
+	select {
+	case msg := <-failed:
+		if !strings.HasPrefix(msg, "err1") {
+			t.Fatalf("_failed = %q, want the first stderr", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stderr is not published as _failed")
+	}
+}
@@ -12,6 +12,9 @@ import (
 	"github.com/shirou/gopsutil/v3/net"
 )
 
+// ListenOwnHardwareInfoEvent publishes the telemetry of this node periodically.
+// The publish is not skipped while the broker is disconnected, the broker outbox
+// keeps only the latest telemetry and drops the older one.
 type ListenOwnHardwareInfoEvent struct{}
 
 type AddPayload_MemOwnHardware struct {
@@ -70,12 +73,6 @@ func (c *ListenOwnHardwareInfoEvent) ListenInfoHardware(conn_name string) {
 
 	go func() {
 		for {
-			if !(conn).IsConnected() {
-				log.Println("Connection is not active, skipping publish for ListenInfoHardware")
-				time.Sleep(c.GetDuration(FIVE_MINUTE))
-				continue
-			}
-
 			hostInfo, err := host.Info()
 			if err != nil {
 				log.Fatalln(err)
@@ -88,7 +85,7 @@ func (c *ListenOwnHardwareInfoEvent) ListenInfoHardware(conn_name string) {
 				return
 			}
 
-			conn.Pub("listen_host_information", string(hostInfoJsonString))
+			conn.Pub(support.TopicTelemetry("host"), string(hostInfoJsonString))
 
 			time.Sleep(c.GetDuration(FIVE_MINUTE))
 		}
@@ -105,12 +102,6 @@ func (c *ListenOwnHardwareInfoEvent) ListenInfoUsage(conn_name string) {
 
 	go func() {
 		for {
-			if !conn.IsConnected() {
-				log.Println("Connection is not active, skipping publish for ListenInfoUsage")
-				time.Sleep(c.GetDuration(ONE_MINUTE))
-				continue
-			}
-
 			hostInfo, err := host.Info()
 			if err != nil {
 				log.Fatalln(err)
@@ -127,7 +118,7 @@ func (c *ListenOwnHardwareInfoEvent) ListenInfoUsage(conn_name string) {
 				Host_id:           hostInfo.HostID,
 				Data:              p,
 				Time_duration:     ONE_MINUTE,
-				Project_data_uuid: support.Helper.ConfigYaml.ConfigData.Uuid,
+				Project_data_uuid: support.Helper.ConfigYaml.GetConfigData().Uuid,
 			}
 
 			pString, err := json.Marshal(cp)
@@ -136,7 +127,7 @@ func (c *ListenOwnHardwareInfoEvent) ListenInfoUsage(conn_name string) {
 				return
 			}
 
-			conn.Pub("listen_cpu_information", string(pString))
+			conn.Pub(support.TopicTelemetry("cpu"), string(pString))
 
 			v, err := mem.VirtualMemory()
 			if err != nil {
@@ -148,7 +139,7 @@ func (c *ListenOwnHardwareInfoEvent) ListenInfoUsage(conn_name string) {
 				Host_id:           hostInfo.HostID,
 				Data:              v,
 				Time_duration:     ONE_MINUTE,
-				Project_data_uuid: support.Helper.ConfigYaml.ConfigData.Uuid,
+				Project_data_uuid: support.Helper.ConfigYaml.GetConfigData().Uuid,
 			}
 
 			vString, err := json.Marshal(mp)
@@ -157,7 +148,7 @@ func (c *ListenOwnHardwareInfoEvent) ListenInfoUsage(conn_name string) {
 				return
 			}
 
-			conn.Pub("listen_mem_information", string(vString))
+			conn.Pub(support.TopicTelemetry("mem"), string(vString))
 
 			time.Sleep(c.GetDuration(ONE_MINUTE))
 		}
@@ -174,12 +165,6 @@ func (c *ListenOwnHardwareInfoEvent) ListenInfoNetwork(conn_name string) {
 
 	go func() {
 		for {
-			if !conn.IsConnected() {
-				log.Println("Connection is not active, skipping publish for ListenInfoNetwork")
-				time.Sleep(c.GetDuration(ONE_MINUTE))
-				continue
-			}
-
 			hostInfo, err := host.Info()
 			if err != nil {
 				log.Fatalln(err)
@@ -196,7 +181,7 @@ func (c *ListenOwnHardwareInfoEvent) ListenInfoNetwork(conn_name string) {
 				Host_id:           hostInfo.HostID,
 				Data:              netInterfaces,
 				Time_duration:     ONE_MINUTE,
-				Project_data_uuid: support.Helper.ConfigYaml.ConfigData.Uuid,
+				Project_data_uuid: support.Helper.ConfigYaml.GetConfigData().Uuid,
 			}
 
 			netInterfaceString, err := json.Marshal(cp)
@@ -205,7 +190,7 @@ func (c *ListenOwnHardwareInfoEvent) ListenInfoNetwork(conn_name string) {
 				return
 			}
 
-			conn.Pub("listen_net_information", string(netInterfaceString))
+			conn.Pub(support.TopicTelemetry("net"), string(netInterfaceString))
 
 			time.Sleep(c.GetDuration(ONE_MINUTE))
 		}
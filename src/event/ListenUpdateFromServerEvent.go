@@ -1 +1,172 @@
 package event
+
+import (
+	"fmt"
+	"job_item/support"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// ListenUpdateFromServerEvent applies the config pushed by the Job Manager without restarting the process.
+// The Job Manager publishes on <project_uuid>.config_update, the worker fetches the config again,
+// compares it with the running one and applies only the change:
+//   - job     subscribe or unsubscribe the changed job event (child_process)
+//   - exec    start or stop the changed exec (child_execs_process)
+//   - broker  reconnect only the broker connection whose setting changed, the default connection
+//     is used by the main process so its change restarts like the other field
+//
+// Any other change, like the credential or the topic, restarts the child process like <project_uuid>.restart.
+func ListenUpdateFromServerEventConstruct() ListenUpdateFromServerEvent {
+	gg := ListenUpdateFromServerEvent{
+		Job_events: map[string]*JobManagerEvent{},
+		mutex:      &sync.Mutex{},
+	}
+	return gg
+}
+
+type ListenUpdateFromServerEvent struct {
+	// child_process, the JobManagerEvent of each broker connection by its key
+	Job_events map[string]*JobManagerEvent
+	// child_execs_process, the running exec
+	Exec_cmds *[]*exec.Cmd
+	mutex     *sync.Mutex
+}
+
+// ListenUpdate subscribes to the config update on the default connection.
+// Without the Job Manager there is nothing pushed, the config file watcher restarts the process.
+func (c *ListenUpdateFromServerEvent) ListenUpdate() {
+	configYaml := support.Helper.ConfigYaml
+	configData := configYaml.GetConfigData()
+	if configData.End_point == "" {
+		return
+	}
+	conn := support.Helper.BrokerConnection.GetConnection(configYaml.GetDefaultBrokerKey())
+	_, err := conn.BasicSub(support.TopicConfigUpdate(configData.Uuid), func(message string) {
+		go c.Update()
+	})
+	if err != nil {
+		log.Println("Error subscribing to config update event:", err)
+	}
+}
+
+// Update fetches the config from the Job Manager and applies the change.
+// The running config is kept when the new one can not be fetched or is invalid.
+func (c *ListenUpdateFromServerEvent) Update() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	configYaml := support.Helper.ConfigYaml
+	support.Helper.PrintGroupName("Config update from the Job Manager")
+	next, err := configYaml.FetchServerConfig()
+	if err != nil {
+		support.Helper.PrintErrName("Keep the running config, the new config is not usable :: "+err.Error(), "ERR-44100903201")
+		return
+	}
+	diff := support.DiffConfig(configYaml, next)
+	if diff.IsEmpty() {
+		support.Helper.PrintGroupName("Config update :: nothing changed")
+		return
+	}
+	if len(diff.Restart) > 0 {
+		support.Helper.PrintGroupName("Config update :: " + strings.Join(diff.Restart, ", ") + " changed, restart the child process")
+		// Only the child process asks the restart, it restarts the exec process too
+		if c.Exec_cmds == nil {
+			support.Helper.EventBus.GetBus().Publish("job_item_restart", nil)
+		}
+		return
+	}
+
+	// The broker is opened before the config is swapped, a broker that can not be opened restarts everything
+	opened := map[string]support.BrokerConnectionInterface{}
+	for _, v := range append(diff.Brokers_added, diff.Brokers_changed...) {
+		key, _ := v["key"].(string)
+		support.Helper.PrintGroupName("Config update :: open broker connection " + key)
+		conn, err := next.OpenBrokerConnection(v)
+		if err != nil {
+			support.Helper.PrintErrName("Config update :: broker connection "+key+" :: "+err.Error(), "ERR-44100903202")
+			for _, conn := range opened {
+				conn.Close()
+			}
+			if c.Exec_cmds == nil {
+				support.Helper.EventBus.GetBus().Publish("job_item_restart", nil)
+			}
+			return
+		}
+		opened[key] = conn
+	}
+
+	configYaml.ApplyConfig(next)
+	brokerConnection := support.Helper.BrokerConnection
+
+	// Broker connection, the jobs of a new or replaced connection are subscribed by ListenEvent
+	rebuilt := map[string]bool{}
+	for _, key := range diff.Brokers_removed {
+		support.Helper.PrintGroupName("Config update :: remove broker connection " + key)
+		c.closeConnection(key, brokerConnection.RemoveConnection(key))
+		rebuilt[key] = true
+	}
+	for key, conn := range opened {
+		c.closeConnection(key, brokerConnection.ReplaceConnection(key, conn))
+		rebuilt[key] = true
+		if c.Exec_cmds == nil {
+			jobManagerEvent := JobManagerEventConstruct()
+			jobManagerEvent.ListenEvent(key)
+			c.Job_events[key] = &jobManagerEvent
+		}
+	}
+	if len(opened) > 0 {
+		// The telemetry picks the new connection
+		support.Helper.EventBus.GetBus().Publish(support.BROKER_REFRESH_PUBSUB, nil)
+	}
+
+	// Job
+	if c.Exec_cmds == nil {
+		for _, v := range diff.Jobs_removed {
+			key := configYaml.GetJobConnectionKey(v)
+			if jobManagerEvent, ok := c.Job_events[key]; ok && !rebuilt[key] {
+				support.Helper.PrintGroupName("Config update :: unsubscribe job " + v.Event)
+				jobManagerEvent.UnsubscribeJob(v)
+			}
+		}
+		for _, v := range diff.Jobs_added {
+			key := configYaml.GetJobConnectionKey(v)
+			if jobManagerEvent, ok := c.Job_events[key]; ok && !rebuilt[key] {
+				support.Helper.PrintGroupName("Config update :: subscribe job " + v.Event)
+				jobManagerEvent.SubscribeJob(v)
+			}
+		}
+	}
+
+	// Exec
+	if c.Exec_cmds != nil {
+		for _, v := range diff.Execs_removed {
+			configYaml.StopExec(v)
+		}
+		for _, v := range diff.Execs_added {
+			configYaml.StartExec(v, c.Exec_cmds)
+		}
+	}
+	support.Helper.PrintGroupName(fmt.Sprintf("Config update :: applied, %d job, %d exec and %d broker connection changed",
+		len(diff.Jobs_added)+len(diff.Jobs_removed), len(diff.Execs_added)+len(diff.Execs_removed),
+		len(diff.Brokers_added)+len(diff.Brokers_changed)+len(diff.Brokers_removed)))
+}
+
+// closeConnection stops the job of the old connection and closes it once its running task finished.
+func (c *ListenUpdateFromServerEvent) closeConnection(key string, old support.BrokerConnectionInterface) {
+	jobManagerEvent, ok := c.Job_events[key]
+	delete(c.Job_events, key)
+	if ok {
+		jobManagerEvent.Close()
+	}
+	if old == nil {
+		return
+	}
+	go func() {
+		if ok {
+			jobManagerEvent.WaitTasks()
+		}
+		old.Close()
+	}()
+}
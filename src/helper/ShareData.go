@@ -200,6 +200,23 @@ func ShareDataGet(taskID, key string) ([]string, error) {
 	return nil, fmt.Errorf("not found")
 }
 
+// ShareDataAll returns every non-expired data of the task in this process by its key, the remote endpoint is not asked.
+func ShareDataAll(taskID string) map[string][]string {
+	now := time.Now()
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+	res := map[string][]string{}
+	for key, entries := range store[taskID] {
+		for _, e := range entries {
+			if !e.ExpiresAt.IsZero() && now.After(e.ExpiresAt) {
+				continue
+			}
+			res[key] = append(res[key], e.Data)
+		}
+	}
+	return res
+}
+
 // janitor periodically prunes expired entries
 func init() {
 	go func() {
@@ -1,40 +1,188 @@
 package helper
 
 import (
+	"fmt"
 	"os"
 	"reflect"
 	"regexp"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
 
-var reVar = regexp.MustCompile(`^\${(\w+)}$`)
+// Match "$${" (escaped) or ${VAR}, ${VAR:-default}, ${VAR:?error}
+var reVar = regexp.MustCompile(`\$\$\{|\$\{(\w+)(?:(:-|:\?)([^}]*))?\}`)
 
-func Fromenv(v interface{}) {
-	godotenv.Load()
-	_fromenv(reflect.ValueOf(v).Elem()) // assumes pointer to struct
+// EnvError is a ${VAR:?error} of the config whose variable is not set.
+type EnvError struct {
+	Path string
+	Msg  string
 }
 
-// recursive
-func _fromenv(rv reflect.Value) {
-	for i := 0; i < rv.NumField(); i++ {
-		fv := rv.Field(i)
-		if fv.Kind() == reflect.Ptr {
-			fv = fv.Elem()
-		}
-		if fv.Kind() == reflect.Struct {
-			_fromenv(fv)
-			continue
-		}
-		if fv.Kind() == reflect.String {
-			oldMatch := fv.String()
-			match := reVar.FindStringSubmatch(fv.String())
-			if len(match) > 1 {
-				fv.SetString(os.Getenv(match[1]))
-				if fv.String() == "" {
-					fv.SetString(oldMatch)
+type EnvErrors []EnvError
+
+func (c EnvErrors) Error() string {
+	lines := []string{}
+	for _, v := range c {
+		lines = append(lines, v.Path+": "+v.Msg)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// LoadEnvFile loads the env file into the environment, a variable already set is kept.
+func LoadEnvFile(files ...string) error {
+	if len(files) == 0 {
+		return nil
+	}
+	return godotenv.Load(files...)
+}
+
+// ReadEnvFile reads the env file into env like LoadEnvFile, the environment is not touched.
+func ReadEnvFile(env map[string]string, files ...string) error {
+	for _, file := range files {
+		values, err := godotenv.Read(file)
+		if err != nil {
+			return err
+		}
+		for key, value := range values {
+			if _, ok := env[key]; !ok {
+				env[key] = value
+			}
+		}
+	}
+	return nil
+}
+
+// EnvironMap returns a copy of the environment.
+func EnvironMap() map[string]string {
+	env := map[string]string{}
+	for _, v := range os.Environ() {
+		if key, value, ok := strings.Cut(v, "="); ok {
+			env[key] = value
+		}
+	}
+	return env
+}
+
+// Fromenv replaces the environment variable inside every string of the struct,
+// also inside the slice, the map and the nested struct:
+//   - ${VAR}          the value of VAR, kept as it is when VAR is not set so the shell of the job can expand it
+//   - ${VAR:-default} the default when VAR is not set or empty
+//   - ${VAR:?error}   an EnvErrors with the error when VAR is not set or empty
+//   - $${VAR}         the text ${VAR}
+func Fromenv(v interface{}) error {
+	return FromenvLookup(v, os.LookupEnv)
+}
+
+// FromenvLookup is Fromenv with the variable read from lookup instead of the environment.
+func FromenvLookup(v interface{}, lookup func(name string) (string, bool)) error {
+	errs := EnvErrors{}
+	WalkStrings(v, func(path string, value string) string {
+		return InterpolateLookup(path, value, &errs, lookup)
+	})
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// WalkStrings calls fn with every string of the struct and its yaml path, like "jobs[0].cmd",
+// and puts the returned string back. v is a pointer to a struct.
+func WalkStrings(v interface{}, fn func(path string, value string) string) {
+	walkStrings(reflect.ValueOf(v).Elem(), "", fn)
+}
+
+// Interpolate replaces the environment variable inside the string, path is used on the error.
+func Interpolate(path string, value string, errs *EnvErrors) string {
+	return InterpolateLookup(path, value, errs, os.LookupEnv)
+}
+
+// InterpolateLookup is Interpolate with the variable read from lookup.
+func InterpolateLookup(path string, value string, errs *EnvErrors, lookup func(name string) (string, bool)) string {
+	return reVar.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+		parts := reVar.FindStringSubmatch(match)
+		name, operator, word := parts[1], parts[2], parts[3]
+		envValue, ok := lookup(name)
+		switch operator {
+		case ":-":
+			if envValue == "" {
+				return word
+			}
+		case ":?":
+			if envValue == "" {
+				if word == "" {
+					word = name + " is not set"
 				}
+				*errs = append(*errs, EnvError{Path: path, Msg: word})
+				return match
 			}
+		default:
+			if !ok {
+				return match
+			}
+		}
+		return envValue
+	})
+}
+
+// recursive
+func walkStrings(rv reflect.Value, path string, fn func(path string, value string) string) {
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if !rv.IsNil() {
+			walkStrings(rv.Elem(), path, fn)
 		}
+	case reflect.Struct:
+		rt := rv.Type()
+		for i := 0; i < rv.NumField(); i++ {
+			if !rt.Field(i).IsExported() {
+				continue
+			}
+			walkStrings(rv.Field(i), joinEnvPath(path, fieldName(rt.Field(i))), fn)
+		}
+	case reflect.String:
+		if rv.CanSet() {
+			rv.SetString(fn(path, rv.String()))
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			walkStrings(rv.Index(i), fmt.Sprintf("%s[%d]", path, i), fn)
+		}
+	case reflect.Interface:
+		if rv.IsNil() || !rv.CanSet() {
+			return
+		}
+		// The value inside the interface can not be set, walk a copy
+		value := reflect.New(rv.Elem().Type()).Elem()
+		value.Set(rv.Elem())
+		walkStrings(value, path, fn)
+		rv.Set(value)
+	case reflect.Map:
+		for _, key := range rv.MapKeys() {
+			// The value of the map can not be set, walk a copy
+			value := reflect.New(rv.Type().Elem()).Elem()
+			value.Set(rv.MapIndex(key))
+			walkStrings(value, joinEnvPath(path, fmt.Sprint(key.Interface())), fn)
+			rv.SetMapIndex(key, value)
+		}
+	}
+}
+
+// fieldName returns the yaml name of the field, like yaml.v3 does without tag.
+func fieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+func joinEnvPath(path string, name string) string {
+	if path == "" {
+		return name
 	}
+	return path + "." + name
 }
@@ -0,0 +1,121 @@
+package helper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("JOB_ITEM_TEST_HOST", "nats.internal")
+	t.Setenv("JOB_ITEM_TEST_EMPTY", "")
+
+	tests := []struct {
+		value string
+		want  string
+		err   bool
+	}{
+		{"${JOB_ITEM_TEST_HOST}", "nats.internal", false},
+		{"nats://${JOB_ITEM_TEST_HOST}:4222", "nats://nats.internal:4222", false},
+		// Not set is kept for the shell of the job
+		{"${JOB_ITEM_TEST_MISSING}", "${JOB_ITEM_TEST_MISSING}", false},
+		{"${JOB_ITEM_TEST_EMPTY}", "", false},
+		{"${JOB_ITEM_TEST_MISSING:-4222}", "4222", false},
+		{"${JOB_ITEM_TEST_EMPTY:-4222}", "4222", false},
+		{"${JOB_ITEM_TEST_HOST:-localhost}", "nats.internal", false},
+		{"${JOB_ITEM_TEST_HOST:?host is required}", "nats.internal", false},
+		{"${JOB_ITEM_TEST_MISSING:?host is required}", "${JOB_ITEM_TEST_MISSING:?host is required}", true},
+		{"${JOB_ITEM_TEST_EMPTY:?}", "${JOB_ITEM_TEST_EMPTY:?}", true},
+		{"$${JOB_ITEM_TEST_HOST}", "${JOB_ITEM_TEST_HOST}", false},
+		{"$JOB_ITEM_TEST_HOST", "$JOB_ITEM_TEST_HOST", false},
+	}
+	for _, tt := range tests {
+		errs := EnvErrors{}
+		got := Interpolate("broker_connection.host", tt.value, &errs)
+		if got != tt.want {
+			t.Errorf("Interpolate(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+		if (len(errs) > 0) != tt.err {
+			t.Errorf("Interpolate(%q) errors = %v, want error %v", tt.value, errs, tt.err)
+		}
+	}
+}
+
+func TestInterpolateErrorMessage(t *testing.T) {
+	errs := EnvErrors{}
+	Interpolate("credential.secret_key", "${JOB_ITEM_TEST_MISSING:?set the secret key}", &errs)
+	Interpolate("credential.project_id", "${JOB_ITEM_TEST_MISSING:?}", &errs)
+	want := "credential.secret_key: set the secret key\ncredential.project_id: JOB_ITEM_TEST_MISSING is not set"
+	if errs.Error() != want {
+		t.Errorf("errors = %q, want %q", errs.Error(), want)
+	}
+}
+
+func TestFromenv(t *testing.T) {
+	t.Setenv("JOB_ITEM_TEST_HOST", "nats.internal")
+	config := struct {
+		Host  string `yaml:"host"`
+		Jobs  []struct{ Cmd string }
+		Env   map[string]string `yaml:"env"`
+		Extra map[string]interface{}
+	}{
+		Host: "${JOB_ITEM_TEST_HOST}",
+		Jobs: []struct{ Cmd string }{{Cmd: "./run ${JOB_ITEM_TEST_HOST} {{task_id}}"}},
+		Env:  map[string]string{"HOST": "${JOB_ITEM_TEST_HOST}"},
+		Extra: map[string]interface{}{
+			"port":  4222,
+			"hosts": []interface{}{"${JOB_ITEM_TEST_HOST}"},
+		},
+	}
+	if err := Fromenv(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Host != "nats.internal" || config.Jobs[0].Cmd != "./run nats.internal {{task_id}}" || config.Env["HOST"] != "nats.internal" {
+		t.Errorf("config is not interpolated: %+v", config)
+	}
+	if hosts := config.Extra["hosts"].([]interface{}); hosts[0] != "nats.internal" || config.Extra["port"] != 4222 {
+		t.Errorf("map value is not interpolated: %+v", config.Extra)
+	}
+
+	missing := struct {
+		Credential struct {
+			Secret_key string `yaml:"secret_key"`
+		} `yaml:"credential"`
+	}{}
+	missing.Credential.Secret_key = "${JOB_ITEM_TEST_MISSING:?}"
+	err := Fromenv(&missing)
+	errs, ok := err.(EnvErrors)
+	if !ok || len(errs) != 1 || errs[0].Path != "credential.secret_key" {
+		t.Errorf("Fromenv error = %v, want one error on credential.secret_key", err)
+	}
+}
+
+func TestReadEnvFile(t *testing.T) {
+	t.Setenv("JOB_ITEM_TEST_KEEP", "env")
+	file := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(file, []byte("JOB_ITEM_TEST_KEEP=file\nJOB_ITEM_TEST_FROM_FILE=file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	env := EnvironMap()
+	if err := ReadEnvFile(env, file); err != nil {
+		t.Fatal(err)
+	}
+	if env["JOB_ITEM_TEST_KEEP"] != "env" || env["JOB_ITEM_TEST_FROM_FILE"] != "file" {
+		t.Errorf("env is %q and %q, want env and file", env["JOB_ITEM_TEST_KEEP"], env["JOB_ITEM_TEST_FROM_FILE"])
+	}
+	if _, ok := os.LookupEnv("JOB_ITEM_TEST_FROM_FILE"); ok {
+		t.Errorf("ReadEnvFile changed the environment")
+	}
+	if err := ReadEnvFile(env, filepath.Join(t.TempDir(), "missing.env")); err == nil {
+		t.Errorf("missing env file gives no error")
+	}
+
+	config := struct{ Host string }{Host: "${JOB_ITEM_TEST_FROM_FILE}"}
+	err := FromenvLookup(&config, func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	})
+	if err != nil || config.Host != "file" {
+		t.Errorf("FromenvLookup gives %q, %v", config.Host, err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/x509"
 	"errors"
 	"fmt"
+	support_helper "job_item/support/helper"
 	"log"
 	"os"
 	"strconv"
@@ -14,6 +15,24 @@ import (
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+func init() {
+	RegisterBrokerType("rabbitmq", BrokerTypeRegistration{
+		Fields: []ConfigField{
+			{Name: "host", Kind: CONFIG_KIND_STRING, Required: true},
+			{Name: "port", Kind: CONFIG_KIND_NUMBER, Required: true},
+			{Name: "user", Kind: CONFIG_KIND_STRING, Required: true},
+			{Name: "password", Kind: CONFIG_KIND_STRING, Required: true},
+			{Name: "exchange", Kind: CONFIG_KIND_STRING},
+		},
+		NewConnection: func() BrokerConInterface {
+			return &AMQP_BrokerConnection{}
+		},
+		Construct: func(conn BrokerConInterface) (BrokerConnectionInterface, error) {
+			return AMQPSupportConstruct(conn.GetConnection().(AMQP_BrokerConnection))
+		},
+	})
+}
+
 type AMQPConfInfo struct {
 	AMQP_HOST     string
 	AMQP_PORT     int
@@ -25,6 +44,8 @@ type AMQPConfInfo struct {
 func AMQPSupportConstruct(props AMQP_BrokerConnection) (*AMQPSupport, error) {
 	gg := AMQPSupport{
 		amqpConfInfo: props,
+		outbox:       BrokerOutboxConstruct(props.Key),
+		subs:         BrokerSubscriptionRegistryConstruct(props.Key),
 	}
 	_, err := gg.ConnectPubSub()
 	return &gg, err
@@ -35,6 +56,10 @@ type AMQPSupport struct {
 	nc           *amqp.Connection
 	amqpConfInfo AMQP_BrokerConnection
 	key          string
+	outbox       *BrokerOutbox
+	subs         *BrokerSubscriptionRegistry
+	// The URI without the password, for the log
+	log_url string
 }
 
 func (c *AMQPSupport) GetRefreshPubSub() string {
@@ -67,12 +92,17 @@ func (c *AMQPSupport) ConnectPubSub() (*amqp.Connection, error) {
 		}
 	}
 
-	// Create AMQP URI
-	url := "amqp://" + amqpUser + ":" + amqpPassword + "@" + amqpHost + ":" + strconv.Itoa(amqpPort) + "/"
-	if c.amqpConfInfo.Secure {
-		url = "amqps://" + amqpUser + ":" + amqpPassword + "@" + amqpHost + ":" + strconv.Itoa(amqpPort) + "/"
+	// Create AMQP URI, the one of the log has the password masked
+	amqpUrl := func(password string) string {
+		scheme := "amqp://"
+		if c.amqpConfInfo.Secure {
+			scheme = "amqps://"
+		}
+		return scheme + amqpUser + ":" + password + "@" + amqpHost + ":" + strconv.Itoa(amqpPort) + "/"
 	}
-	fmt.Println("AMQP Connection inf :: ", url)
+	url := amqpUrl(amqpPassword)
+	c.log_url = amqpUrl(fmt.Sprint(MaskBrokerConnectionValue("password", amqpPassword)))
+	fmt.Println("AMQP Connection inf :: ", c.log_url)
 
 	// Retry mechanism
 	var err error
@@ -92,18 +122,20 @@ func (c *AMQPSupport) ConnectPubSub() (*amqp.Connection, error) {
 				for err := range notifyClose {
 					if err != nil {
 						fmt.Println("Disconnected from AMQP server, attempting to reconnect:", err)
+						c.subs.SetConnected(false)
 						go c.retryConnection(url) // Trigger reconnection loop
 					}
 				}
 			}()
 
 			// Create a channel
-			ch, chErr := nc.Channel()
+			ch, chErr := c.openChannel(nc)
 			if chErr != nil {
 				fmt.Println("Failed to open a channel:", chErr)
 				return nil, chErr
 			}
 			c.ch = ch
+			c.subs.SetConnected(true)
 			break
 		}
 		fmt.Println("AMQP connection failed, retrying in 5-10 seconds:", err.Error())
@@ -113,6 +145,27 @@ func (c *AMQPSupport) ConnectPubSub() (*amqp.Connection, error) {
 	return c.nc, err
 }
 
+// exchange is the topic exchange every message goes through, "job_item" without config.
+func (c *AMQPSupport) exchange() string {
+	if c.amqpConfInfo.Exchange != "" {
+		return c.amqpConfInfo.Exchange
+	}
+	return "job_item"
+}
+
+// openChannel opens the channel and declares the exchange on it.
+func (c *AMQPSupport) openChannel(nc *amqp.Connection) (*amqp.Channel, error) {
+	ch, err := nc.Channel()
+	if err != nil {
+		return nil, err
+	}
+	if err := ch.ExchangeDeclare(c.exchange(), "topic", true, false, false, false, nil); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to declare exchange: %w", err)
+	}
+	return ch, nil
+}
+
 func (c *AMQPSupport) dialAMQP(url string) (*amqp.Connection, error) {
 	if c.amqpConfInfo.Secure {
 		caCert, err := os.ReadFile(c.amqpConfInfo.CAFile)
@@ -136,13 +189,16 @@ func (c *AMQPSupport) dialAMQP(url string) (*amqp.Connection, error) {
 
 func (c *AMQPSupport) retryConnection(url string) {
 	for {
+		if c.subs.IsClosed() {
+			return
+		}
 		nc, connErr := c.dialAMQP(url)
 		if connErr == nil {
 			c.nc = nc
-			fmt.Println("Successfully reconnected to AMQP server:", url)
+			fmt.Println("Successfully reconnected to AMQP server:", c.log_url)
 
 			// Recreate the channel
-			ch, chErr := nc.Channel()
+			ch, chErr := c.openChannel(nc)
 			if chErr != nil {
 				fmt.Println("Failed to reopen channel:", chErr)
 				go c.retryConnection(url) // Recursive retry on channel failure
@@ -150,17 +206,25 @@ func (c *AMQPSupport) retryConnection(url string) {
 			}
 			c.ch = ch
 
+			// The consumer of the old channel is gone, consume again every registered subscription
+			c.subs.Resubscribe()
+
 			// Set up connection monitoring
 			go func() {
 				notifyClose := nc.NotifyClose(make(chan *amqp.Error))
 				for err := range notifyClose {
 					if err != nil {
 						fmt.Println("Disconnected from AMQP server, attempting to reconnect:", err)
+						c.subs.SetConnected(false)
 						go c.retryConnection(url) // Recursive retry on disconnect
 					}
 				}
 			}()
 
+			// Send the message queued while disconnected
+			c.outbox.Flush(c.publish)
+			c.subs.SetConnected(true)
+
 			// Publish an event to refresh pubsub
 			Helper.EventBus.GetBus().Publish("refresh_pubsub", nil)
 			break
@@ -178,188 +242,152 @@ func (c *AMQPSupport) GetObject() any {
 }
 
 // Interface from BrokerConnectionInterface
+// While the channel is not open the message is queued on the outbox
+// and flushed in order after reconnect.
 func (c *AMQPSupport) Pub(topic string, msg string) {
-	// c.ch.Publish(topic, []byte(msg))
+	c.outbox.Publish(c.IsConnected() && c.ch != nil, topic, msg, c.publish)
+}
+
+func (c *AMQPSupport) publish(topic string, msg string) error {
 	// Ensure the channel is open
-	if c.ch == nil {
-		log.Println("channel is not open")
-		return
+	if c.ch == nil || c.ch.IsClosed() {
+		return errors.New("channel is not open")
 	}
 
 	// Ensure the connection is open
-	if c.nc == nil {
-		log.Println("connection is not open")
-		return
-
+	if c.nc == nil || c.nc.IsClosed() {
+		return errors.New("connection is not open")
 	}
 	// Create a context for the publish operation
 	ctx := context.Background()
 	// Publish the message
 	err := c.ch.PublishWithContext(
 		ctx,
-		"",    // exchange
-		topic, // routing key (queue name)
-		false, // mandatory
-		false, // immediate
+		c.exchange(),                       // exchange
+		BrokerTopicName("rabbitmq", topic), // routing key, every queue bound with it gets the message
+		false,                              // mandatory
+		false,                              // immediate
 		amqp.Publishing{
 			ContentType: "text/plain",
 			Body:        []byte(msg),
 		},
 	)
 	if err != nil {
-		// if err == amqp.ErrClosed {
-		// 	// Reopen the channel
-		// 	c.ch, err = c.nc.Channel()
-		// 	if err != nil {
-		// 		log.Println(fmt.Errorf("failed to reopen channel: %w", err))
-		// 		return
-		// 	}
-		// 	// Retry message publishing
-		// 	// return c.Publish(topic, group_id, message)
-		// }
-		log.Println(fmt.Errorf("failed to publish message: %w", err))
-		return
+		return fmt.Errorf("failed to publish message: %w", err)
 	}
-
+	return nil
 }
 
 // Interface from BrokerConnectionInterface
+// The subscription is kept on the registry and consumed again after reconnect.
 func (c *AMQPSupport) Sub(topic string, group_id string, callback func(message string)) (func(), error) {
+	return c.subs.Subscribe(topic, func() (func(), error) {
+		return c.consume(topic, group_id, callback)
+	})
+}
 
-	key_topic := topic
+// declareConsumer binds a queue to the topic and consumes it on the current channel.
+// The members of a group share one named queue so each message goes to one of them,
+// without group the queue is exclusive and named by the server so every subscriber gets the message.
+// Both are auto-delete, canceling the last consumer removes the queue.
+func (c *AMQPSupport) declareConsumer(topic string, group_id string) (<-chan amqp.Delivery, func(), error) {
+	key_topic := BrokerTopicName("rabbitmq", topic)
+	ch := c.ch
+	if ch == nil {
+		return nil, nil, errors.New("channel is not open")
+	}
 
-	_, err := c.ch.QueueDeclare(key_topic, false, true, false, false, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to declare queue: %w", err)
+	var queue amqp.Queue
+	var err error
+	if group_id != "" {
+		queue, err = ch.QueueDeclare(BrokerTopicName("rabbitmq", topic+"."+group_id), false, true, false, false, nil)
+	} else {
+		queue, err = ch.QueueDeclare("", false, true, true, false, nil)
 	}
-	msgs, err := c.ch.Consume(key_topic, "", true, false, false, false, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to register consumer: %w", err)
+		return nil, nil, fmt.Errorf("failed to declare queue: %w", err)
 	}
-	go func() {
-		for msg := range msgs {
-			log.Printf("Received a message: %s", msg.Body)
-			callback(string(msg.Body))
-			// Process the message here
-		}
-	}()
-	// Return a closure to cancel the consumer
-	cancel := func() {
-		log.Println("Sub AMQPSupport :: ", key_topic, " :: Closing")
-		if _, err := c.ch.QueueDelete(key_topic, false, false, false); err != nil {
-			log.Printf("Failed to cancel consumer: %v", err)
-		}
-		log.Println("Sub AMQPSupport :: ", key_topic, " :: Closed")
+	if err := ch.QueueBind(queue.Name, key_topic, c.exchange(), false, nil); err != nil {
+		return nil, nil, fmt.Errorf("failed to bind queue: %w", err)
 	}
-	return cancel, nil
-}
-
-// Interface from BrokerConnectionInterface
-// For this message queue case we ignore group_id
-func (c *AMQPSupport) SubSync(uuidItem string, group_id string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
-	key_topic := uuidItem
-
-	_, err := c.ch.QueueDeclare(key_topic, false, true, false, false, nil)
+	tag, err := support_helper.GenerateUUIDv7()
 	if err != nil {
-		return true, fmt.Errorf("failed to declare queue: %w", err)
+		return nil, nil, err
 	}
-	msgs, err := c.ch.Consume(key_topic, "", true, false, false, false, nil)
+	msgs, err := ch.Consume(queue.Name, tag, true, false, false, false, nil)
 	if err != nil {
-		return true, fmt.Errorf("failed to register consumer: %w", err)
+		return nil, nil, fmt.Errorf("failed to register consumer: %w", err)
 	}
-
-	timeout := time.After(time.Duration(opts.Timeout_second) * time.Second)
-	var errr error
-
-	select {
-	case msg, ok := <-msgs:
-		if !ok {
-			errr = errors.New("channel closed")
-			callback(GetStatus().STATUS_ERROR, errr)
-			return true, errr
-		}
-		log.Printf("unSubscribeFinish: %s\n", msg.Body)
-		if _, errr = c.ch.QueueDelete(key_topic, false, false, false); errr != nil {
-			log.Printf("Failed to cancel consumer: %v", errr)
-		}
-		callback(string(msg.Body), nil)
-		return false, nil // Not timeout
-	case <-timeout:
-		if _, errr = c.ch.QueueDelete(key_topic, false, false, false); errr != nil {
-			log.Printf("Failed to cancel consumer: %v", errr)
+	cancel := func() {
+		if err := ch.Cancel(tag, false); err != nil && !ch.IsClosed() {
+			log.Printf("Failed to cancel consumer: %v", err)
 		}
-		return true, nil // Timeout
 	}
+	return msgs, cancel, nil
 }
 
-// Interface from BrokerConnectionInterface
-func (c *AMQPSupport) BasicSub(topic string, callback func(message string)) (func(), error) {
-	key_topic := topic
-	// if group_id == "" {
-	// 	key_topic = topic
-	// }
-	_, err := c.ch.QueueDeclare(key_topic, false, true, false, false, nil)
+// consume runs the callback for every message of the consumer.
+func (c *AMQPSupport) consume(topic string, group_id string, callback func(message string)) (func(), error) {
+	msgs, cancelConsumer, err := c.declareConsumer(topic, group_id)
 	if err != nil {
-		return nil, fmt.Errorf("failed to declare queue: %w", err)
-	}
-	msgs, err := c.ch.Consume(key_topic, "", true, false, false, false, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to register consumer: %w", err)
+		return nil, err
 	}
 	go func() {
 		for msg := range msgs {
 			log.Printf("Received a message: %s", msg.Body)
 			callback(string(msg.Body))
-			// Process the message here
 		}
 	}()
-	// Return a closure to cancel the consumer
+	// Return a closure to cancel the consumer, the queue of the other subscriber stays
+	key_topic := BrokerTopicName("rabbitmq", topic)
 	cancel := func() {
 		log.Println("Sub AMQPSupport :: ", key_topic, " :: Closing")
-		if _, err := c.ch.QueueDelete(key_topic, false, false, false); err != nil {
-			log.Printf("Failed to cancel consumer: %v", err)
-		}
+		cancelConsumer()
 		log.Println("Sub AMQPSupport :: ", key_topic, " :: Closed")
 	}
 	return cancel, nil
 }
 
-// Interface from BrokerConnectionInterface
-func (c *AMQPSupport) BasicSubSync(uuidItem string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
-	key_topic := uuidItem
-
-	_, err := c.ch.QueueDeclare(key_topic, false, true, false, false, nil)
-	if err != nil {
-		return true, fmt.Errorf("failed to declare queue: %w", err)
-	}
-	msgs, err := c.ch.Consume(key_topic, "", true, false, false, false, nil)
+// consumeSync waits one message up to the timeout. Returns (isTimeout, error).
+func (c *AMQPSupport) consumeSync(topic string, group_id string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
+	msgs, cancel, err := c.declareConsumer(topic, group_id)
 	if err != nil {
-		return true, fmt.Errorf("failed to register consumer: %w", err)
+		return true, err
 	}
-	timeout := time.After(time.Duration(opts.Timeout_second) * time.Second)
-	var errr error
+	defer cancel()
 
 	select {
 	case msg, ok := <-msgs:
 		if !ok {
-			errr = errors.New("channel closed")
-			callback(GetStatus().STATUS_ERROR, errr)
-			return true, errr
+			err = errors.New("channel closed")
+			callback(GetStatus().STATUS_ERROR, err)
+			return true, err
 		}
 		log.Printf("unSubscribeFinish: %s\n", msg.Body)
-		if _, errr = c.ch.QueueDelete(key_topic, false, false, false); errr != nil {
-			log.Printf("Failed to cancel consumer: %v", errr)
-		}
 		callback(string(msg.Body), nil)
 		return false, nil // Not timeout
-	case <-timeout:
-		if _, errr = c.ch.QueueDelete(key_topic, false, false, false); errr != nil {
-			log.Printf("Failed to cancel consumer: %v", errr)
-		}
+	case <-time.After(time.Duration(opts.Timeout_second) * time.Second):
 		return true, nil // Timeout
 	}
 }
 
+// Interface from BrokerConnectionInterface
+func (c *AMQPSupport) SubSync(uuidItem string, group_id string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
+	return c.consumeSync(uuidItem, group_id, callback, opts)
+}
+
+// Interface from BrokerConnectionInterface
+func (c *AMQPSupport) BasicSub(topic string, callback func(message string)) (func(), error) {
+	return c.subs.Subscribe(topic, func() (func(), error) {
+		return c.consume(topic, "", callback)
+	})
+}
+
+// Interface from BrokerConnectionInterface
+func (c *AMQPSupport) BasicSubSync(uuidItem string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
+	return c.consumeSync(uuidItem, "", callback, opts)
+}
+
 // Interface from BrokerConnectionInterface
 func (c *AMQPSupport) SetKey_P(key string) {
 	c.key = key
@@ -382,3 +410,12 @@ func (c *AMQPSupport) IsConnected() bool {
 	}
 	return true
 }
+
+// Interface from BrokerConnectionInterface
+func (c *AMQPSupport) Close() {
+	c.subs.Close()
+	if c.nc != nil {
+		// A close without error does not trigger the reconnect
+		c.nc.Close()
+	}
+}
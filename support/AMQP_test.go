@@ -0,0 +1,22 @@
+package support_test
+
+import (
+	"os"
+	"testing"
+)
+
+// docker run -d --name rabbitmq -p 5672:5672 rabbitmq:3
+// JOB_ITEM_TEST_RABBITMQ=127.0.0.1:5672 go test ./support -run TestAmqpBroker
+func TestAmqpBroker(t *testing.T) {
+	user := os.Getenv("JOB_ITEM_TEST_RABBITMQ_USER")
+	password := os.Getenv("JOB_ITEM_TEST_RABBITMQ_PASSWORD")
+	if user == "" {
+		user, password = "guest", "guest"
+	}
+	factory, opts := brokerFromEnv(t, "RABBITMQ", map[string]interface{}{
+		"type":     "rabbitmq",
+		"user":     user,
+		"password": password,
+	})
+	runBrokerTest(t, factory, opts)
+}
@@ -1,5 +1,7 @@
 package support
 
+import "sync"
+
 type SubSyncOpts struct {
 	Timeout_second int
 }
@@ -17,6 +19,14 @@ type BrokerConnectionInterface interface {
 	GetRefreshPubSub() string
 	BasicSub(topic string, callback func(message string)) (func(), error)
 	BasicSubSync(topic string, callback func(message string, err error), opts SubSyncOpts) (bool, error)
+	// Close unsubscribes everything and closes the connection without reconnecting
+	Close()
+}
+
+// BrokerServerInfoInterface is implemented by the connection that can tell
+// which server of the cluster it is connected to.
+type BrokerServerInfoInterface interface {
+	GetConnectedServer() string
 }
 
 func BrokerConnectionSupportContruct() *BrokerConnectionSupport {
@@ -27,15 +37,21 @@ func BrokerConnectionSupportContruct() *BrokerConnectionSupport {
 }
 
 type BrokerConnectionSupport struct {
+	// The live config update replaces the connection while the listener reads it
+	mutex    sync.RWMutex
 	conn_arr []*BrokerConnectionInterface
 }
 
 func (c *BrokerConnectionSupport) RegisterConnection(key string, conn BrokerConnectionInterface) {
 	(conn).SetKey_P(key)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	c.conn_arr = append(c.conn_arr, &conn)
 }
 
 func (c *BrokerConnectionSupport) GetConnection(key string) BrokerConnectionInterface {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	for _, v := range c.conn_arr {
 		if (*v).GetKey_P() == key {
 			Helper.PrintGroupName("GetConnection :: " + (*v).GetKey_P() + " == " + key)
@@ -45,6 +61,48 @@ func (c *BrokerConnectionSupport) GetConnection(key string) BrokerConnectionInte
 	return nil
 }
 
+// GetConnections returns every registered connection.
+func (c *BrokerConnectionSupport) GetConnections() []BrokerConnectionInterface {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	conns := make([]BrokerConnectionInterface, 0, len(c.conn_arr))
+	for _, v := range c.conn_arr {
+		conns = append(conns, (*v).GetBroker_P().(BrokerConnectionInterface))
+	}
+	return conns
+}
+
+// ReplaceConnection registers the new connection on the place of the one with the same key
+// and returns the old one, nil for a new key. The old connection is not closed,
+// the task still running on it finishes first.
+func (c *BrokerConnectionSupport) ReplaceConnection(key string, conn BrokerConnectionInterface) BrokerConnectionInterface {
+	(conn).SetKey_P(key)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i, v := range c.conn_arr {
+		if (*v).GetKey_P() == key {
+			old := (*v).GetBroker_P().(BrokerConnectionInterface)
+			c.conn_arr[i] = &conn
+			return old
+		}
+	}
+	c.conn_arr = append(c.conn_arr, &conn)
+	return nil
+}
+
+// RemoveConnection removes the connection with the key and returns it without closing it.
+func (c *BrokerConnectionSupport) RemoveConnection(key string) BrokerConnectionInterface {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i, v := range c.conn_arr {
+		if (*v).GetKey_P() == key {
+			c.conn_arr = append(c.conn_arr[:i], c.conn_arr[i+1:]...)
+			return (*v).GetBroker_P().(BrokerConnectionInterface)
+		}
+	}
+	return nil
+}
+
 func (c *BrokerConnectionSupport) GetObject() any {
 	return c
 }
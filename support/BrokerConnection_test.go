@@ -0,0 +1,74 @@
+package support_test
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+
+	support "job_item/support"
+	"job_item/support/brokertest"
+)
+
+func TestMain(m *testing.M) {
+	support.SupportConstruct("Test")
+	support.Helper.Register(support.EventBusConstruct())
+	os.Exit(m.Run())
+}
+
+// brokerFromEnv returns the factory of the broker given by JOB_ITEM_TEST_<name>=<host>:<port>,
+// the test is skipped without it. JOB_ITEM_TEST_<name>_RESTART is the shell command that
+// restarts the broker for the reconnect check.
+func brokerFromEnv(t *testing.T, name string, conn map[string]interface{}) (brokertest.Factory, brokertest.Options) {
+	address := os.Getenv("JOB_ITEM_TEST_" + name)
+	if address == "" {
+		t.Skipf("set JOB_ITEM_TEST_%s=<host>:<port> to run against a local broker", name)
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		t.Fatalf("JOB_ITEM_TEST_%s: %s", name, err)
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatalf("JOB_ITEM_TEST_%s: %s", name, err)
+	}
+	conn["key"] = "brokertest"
+	conn["name"] = "Broker test"
+	conn["host"] = host
+	conn["port"] = portNumber
+
+	factory := func() (support.BrokerConnectionInterface, error) {
+		decoded, err := support.DecodeBrokerConnection("broker_connection", conn)
+		if err != nil {
+			return nil, err
+		}
+		gg, err := support.NewBrokerConnection(decoded)
+		if err != nil {
+			return nil, err
+		}
+		gg.SetKey_P("brokertest")
+		return gg, nil
+	}
+	opts := brokertest.Options{}
+	if restart := os.Getenv("JOB_ITEM_TEST_" + name + "_RESTART"); restart != "" {
+		opts.Restart = func() error {
+			return exec.Command("sh", "-c", restart).Run()
+		}
+	}
+	return factory, opts
+}
+
+// runBrokerTest runs every check of the suite as a subtest.
+func runBrokerTest(t *testing.T, factory brokertest.Factory, opts brokertest.Options) {
+	for _, result := range brokertest.Run(factory, opts) {
+		t.Run(result.Name, func(t *testing.T) {
+			if result.Skipped {
+				t.Skip("needs a restart command")
+			}
+			if result.Err != nil {
+				t.Fatal(result.Err)
+			}
+		})
+	}
+}
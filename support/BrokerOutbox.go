@@ -0,0 +1,260 @@
+package support
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Topic class decide what the outbox do with a publish while the broker is disconnected.
+// - status    : <task_id>_finish, <task_id>_failed, <task_id>_who. Never dropped and written to disk if outbox.dir is set.
+// - output    : <task_id>_process, <task_id>.notif_add. Bounded by max_size, the oldest is dropped first.
+// - telemetry : listen_*_information. Bounded by max_telemetry_size, the oldest is dropped first.
+const (
+	OUTBOX_CLASS_STATUS    = "status"
+	OUTBOX_CLASS_OUTPUT    = "output"
+	OUTBOX_CLASS_TELEMETRY = "telemetry"
+)
+
+const (
+	OUTBOX_DEFAULT_MAX_SIZE           = 1000
+	OUTBOX_DEFAULT_MAX_TELEMETRY_SIZE = 10
+)
+
+type OutboxConfig struct {
+	// Max total message on the queue, status message is never dropped so it can exceed this value
+	Max_size int `yaml:"max_size" json:"max_size,omitempty"`
+	// Max telemetry message on the queue
+	Max_telemetry_size int `yaml:"max_telemetry_size" json:"max_telemetry_size,omitempty"`
+	// Directory to persist status message, empty mean memory only
+	Dir string `yaml:"dir" json:"dir,omitempty"`
+}
+
+type outboxEntry struct {
+	Topic      string    `json:"topic"`
+	Msg        string    `json:"msg"`
+	Class      string    `json:"class"`
+	Created_at time.Time `json:"created_at"`
+}
+
+// GetOutboxClass returns the topic class used by the outbox drop policy.
+func GetOutboxClass(topic string) string {
+	topic = TopicLogicalName(topic)
+	switch {
+	case strings.HasSuffix(topic, "_finish"), strings.HasSuffix(topic, "_failed"), strings.HasSuffix(topic, "_who"):
+		return OUTBOX_CLASS_STATUS
+	case strings.HasPrefix(topic, "listen_") && strings.HasSuffix(topic, "_information"):
+		return OUTBOX_CLASS_TELEMETRY
+	default:
+		return OUTBOX_CLASS_OUTPUT
+	}
+}
+
+func BrokerOutboxConstruct(key string) *BrokerOutbox {
+	conf := OutboxConfig{}
+	if Helper != nil && Helper.ConfigYaml != nil {
+		conf = Helper.ConfigYaml.GetConfigData().Outbox
+	}
+	if conf.Max_size <= 0 {
+		conf.Max_size = OUTBOX_DEFAULT_MAX_SIZE
+	}
+	if conf.Max_telemetry_size <= 0 {
+		conf.Max_telemetry_size = OUTBOX_DEFAULT_MAX_TELEMETRY_SIZE
+	}
+	gg := &BrokerOutbox{
+		key:     key,
+		conf:    conf,
+		entries: make([]outboxEntry, 0),
+	}
+	gg.loadFromDisk()
+	return gg
+}
+
+// BrokerOutbox queue the publish while the broker is disconnected
+// and flush it in order when the connection is back.
+type BrokerOutbox struct {
+	key     string
+	conf    OutboxConfig
+	mutex   sync.Mutex
+	flushMu sync.Mutex
+	entries []outboxEntry
+}
+
+// Publish sends the message directly when connected and nothing is waiting on the queue,
+// otherwise the message is queued behind the others to keep the order.
+func (c *BrokerOutbox) Publish(connected bool, topic string, msg string, publish func(topic string, msg string) error) {
+	if connected && c.Len() == 0 {
+		err := publish(topic, msg)
+		if err == nil {
+			return
+		}
+		Helper.PrintErrName("Outbox :: publish failed, queue the message :: "+topic+" :: "+err.Error(), "ERR-26100903204")
+	}
+	c.Push(topic, msg)
+	if connected {
+		c.Flush(publish)
+	}
+}
+
+// Push adds the message to the end of the queue and applies the drop policy.
+func (c *BrokerOutbox) Push(topic string, msg string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry := outboxEntry{
+		Topic:      topic,
+		Msg:        msg,
+		Class:      GetOutboxClass(topic),
+		Created_at: time.Now(),
+	}
+	c.entries = append(c.entries, entry)
+	c.applyDropPolicy()
+	if entry.Class == OUTBOX_CLASS_STATUS {
+		c.saveToDisk()
+	}
+}
+
+// Len returns total message waiting on the queue.
+func (c *BrokerOutbox) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.entries)
+}
+
+// Flush publishes the queued message in order.
+// It stops on the first failure and keeps the rest for the next flush.
+func (c *BrokerOutbox) Flush(publish func(topic string, msg string) error) error {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+	total := 0
+	for {
+		c.mutex.Lock()
+		if len(c.entries) == 0 {
+			c.mutex.Unlock()
+			break
+		}
+		entry := c.entries[0]
+		c.mutex.Unlock()
+
+		err := publish(entry.Topic, entry.Msg)
+		if err != nil {
+			Helper.PrintErrName(fmt.Sprintf("Outbox :: flush stopped, %d message still waiting :: %s", c.Len(), err.Error()), "ERR-26100903205")
+			return err
+		}
+
+		c.mutex.Lock()
+		c.entries = c.entries[1:]
+		if entry.Class == OUTBOX_CLASS_STATUS {
+			c.saveToDisk()
+		}
+		c.mutex.Unlock()
+		total++
+	}
+	if total > 0 {
+		Helper.PrintGroupName(fmt.Sprintf("Outbox :: flushed %d message for connection :: %s", total, c.key))
+	}
+	return nil
+}
+
+// applyDropPolicy must be called with the mutex held.
+func (c *BrokerOutbox) applyDropPolicy() {
+	for c.countClass(OUTBOX_CLASS_TELEMETRY) > c.conf.Max_telemetry_size {
+		c.dropOldest(OUTBOX_CLASS_TELEMETRY)
+	}
+	for len(c.entries) > c.conf.Max_size {
+		if !c.dropOldest(OUTBOX_CLASS_TELEMETRY) && !c.dropOldest(OUTBOX_CLASS_OUTPUT) {
+			// Only status message left, keep them all
+			return
+		}
+	}
+}
+
+func (c *BrokerOutbox) countClass(class string) int {
+	total := 0
+	for _, v := range c.entries {
+		if v.Class == class {
+			total++
+		}
+	}
+	return total
+}
+
+// dropOldest removes the oldest entry of the class, returns false when the class have no entry.
+func (c *BrokerOutbox) dropOldest(class string) bool {
+	for i, v := range c.entries {
+		if v.Class == class {
+			c.entries = append(c.entries[:i], c.entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// filePath is separated by segment app because the main, child and exec process
+// open their own connection with the same key.
+func (c *BrokerOutbox) filePath() string {
+	segment := "main"
+	if Helper != nil && Helper.Segment_app != "" {
+		segment = strings.ToLower(Helper.Segment_app)
+	}
+	return filepath.Join(c.conf.Dir, c.key+"."+segment+".outbox.jsonl")
+}
+
+// saveToDisk rewrites the status entries to the outbox file, must be called with the mutex held.
+func (c *BrokerOutbox) saveToDisk() {
+	if c.conf.Dir == "" {
+		return
+	}
+	if err := os.MkdirAll(c.conf.Dir, 0700); err != nil {
+		Helper.PrintErrName("Outbox :: cannot create dir :: "+err.Error(), "ERR-26100903201")
+		return
+	}
+	tmpPath := c.filePath() + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		Helper.PrintErrName("Outbox :: cannot write file :: "+err.Error(), "ERR-26100903202")
+		return
+	}
+	writer := bufio.NewWriter(f)
+	for _, v := range c.entries {
+		if v.Class != OUTBOX_CLASS_STATUS {
+			continue
+		}
+		line, _ := json.Marshal(v)
+		writer.Write(line)
+		writer.WriteString("\n")
+	}
+	writer.Flush()
+	f.Close()
+	if err := os.Rename(tmpPath, c.filePath()); err != nil {
+		Helper.PrintErrName("Outbox :: cannot replace file :: "+err.Error(), "ERR-26100903203")
+	}
+}
+
+// loadFromDisk restores the status entries left by the previous process.
+func (c *BrokerOutbox) loadFromDisk() {
+	if c.conf.Dir == "" {
+		return
+	}
+	f, err := os.Open(c.filePath())
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		var entry outboxEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		c.entries = append(c.entries, entry)
+	}
+	if len(c.entries) > 0 {
+		Helper.PrintGroupName(fmt.Sprintf("Outbox :: restored %d message from disk for connection :: %s", len(c.entries), c.key))
+	}
+}
@@ -0,0 +1,213 @@
+package support_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	support "job_item/support"
+)
+
+// outboxWithConfig builds the outbox with the outbox config of the running config.
+func outboxWithConfig(t *testing.T, key string, conf support.OutboxConfig) *support.BrokerOutbox {
+	previous := support.Helper.ConfigYaml
+	t.Cleanup(func() { support.Helper.ConfigYaml = previous })
+	configData := support.ConfigData{}
+	configData.Outbox = conf
+	support.Helper.ConfigYaml = &support.ConfigYamlSupport{ConfigData: configData}
+	return support.BrokerOutboxConstruct(key)
+}
+
+// flushTopics flushes the outbox and returns the published topic in order.
+func flushTopics(t *testing.T, outbox *support.BrokerOutbox) []string {
+	topics := []string{}
+	err := outbox.Flush(func(topic string, msg string) error {
+		topics = append(topics, topic)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return topics
+}
+
+func TestGetOutboxClass(t *testing.T) {
+	tests := []struct {
+		topic string
+		want  string
+	}{
+		{support.TopicTaskFinish("t1"), support.OUTBOX_CLASS_STATUS},
+		{support.TopicTaskFailed("t1"), support.OUTBOX_CLASS_STATUS},
+		{support.TopicTaskWho("t1"), support.OUTBOX_CLASS_STATUS},
+		{support.TopicTaskProcess("t1"), support.OUTBOX_CLASS_OUTPUT},
+		{support.TopicTaskNotifAdd("t1"), support.OUTBOX_CLASS_OUTPUT},
+		{support.TopicTelemetry("cpu"), support.OUTBOX_CLASS_TELEMETRY},
+		{support.TopicTelemetry("host"), support.OUTBOX_CLASS_TELEMETRY},
+		{"listen_cpu", support.OUTBOX_CLASS_OUTPUT},
+		{"anything", support.OUTBOX_CLASS_OUTPUT},
+	}
+	for _, tt := range tests {
+		if got := support.GetOutboxClass(tt.topic); got != tt.want {
+			t.Errorf("GetOutboxClass(%q) = %q, want %q", tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestBrokerOutboxDropPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		push func(outbox *support.BrokerOutbox)
+		// Total left and the first and last topic of the flush
+		want      int
+		wantFirst string
+		wantLast  string
+	}{
+		{
+			name: "telemetry keeps the last 10",
+			push: func(outbox *support.BrokerOutbox) {
+				for i := range 15 {
+					outbox.Push(support.TopicTelemetry(fmt.Sprint("cpu", i)), "{}")
+				}
+			},
+			want:      support.OUTBOX_DEFAULT_MAX_TELEMETRY_SIZE,
+			wantFirst: support.TopicTelemetry("cpu5"),
+			wantLast:  support.TopicTelemetry("cpu14"),
+		},
+		{
+			name: "output keeps the last 1000",
+			push: func(outbox *support.BrokerOutbox) {
+				for i := range 1005 {
+					outbox.Push(support.TopicTaskProcess(fmt.Sprint("t", i)), "line")
+				}
+			},
+			want:      support.OUTBOX_DEFAULT_MAX_SIZE,
+			wantFirst: support.TopicTaskProcess("t5"),
+			wantLast:  support.TopicTaskProcess("t1004"),
+		},
+		{
+			name: "telemetry is dropped before output",
+			push: func(outbox *support.BrokerOutbox) {
+				outbox.Push(support.TopicTelemetry("cpu"), "{}")
+				for i := range 1000 {
+					outbox.Push(support.TopicTaskProcess(fmt.Sprint("t", i)), "line")
+				}
+			},
+			want:      support.OUTBOX_DEFAULT_MAX_SIZE,
+			wantFirst: support.TopicTaskProcess("t0"),
+			wantLast:  support.TopicTaskProcess("t999"),
+		},
+		{
+			name: "output is dropped before status",
+			push: func(outbox *support.BrokerOutbox) {
+				for i := range 3 {
+					outbox.Push(support.TopicTaskFinish(fmt.Sprint("s", i)), "finish")
+				}
+				for i := range 1000 {
+					outbox.Push(support.TopicTaskProcess(fmt.Sprint("t", i)), "line")
+				}
+				outbox.Push(support.TopicTaskFailed("s3"), "error")
+			},
+			want:      support.OUTBOX_DEFAULT_MAX_SIZE,
+			wantFirst: support.TopicTaskFinish("s0"),
+			wantLast:  support.TopicTaskFailed("s3"),
+		},
+		{
+			name: "status is never dropped",
+			push: func(outbox *support.BrokerOutbox) {
+				for i := range 1001 {
+					outbox.Push(support.TopicTaskFinish(fmt.Sprint("s", i)), "finish")
+				}
+			},
+			want:      support.OUTBOX_DEFAULT_MAX_SIZE + 1,
+			wantFirst: support.TopicTaskFinish("s0"),
+			wantLast:  support.TopicTaskFinish("s1000"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outbox := outboxWithConfig(t, "outbox_test", support.OutboxConfig{})
+			tt.push(outbox)
+			if got := outbox.Len(); got != tt.want {
+				t.Fatalf("Len() = %d, want %d", got, tt.want)
+			}
+			topics := flushTopics(t, outbox)
+			if topics[0] != tt.wantFirst || topics[len(topics)-1] != tt.wantLast {
+				t.Fatalf("flushed %q .. %q, want %q .. %q", topics[0], topics[len(topics)-1], tt.wantFirst, tt.wantLast)
+			}
+			if outbox.Len() != 0 {
+				t.Fatalf("Len() = %d after flush, want 0", outbox.Len())
+			}
+		})
+	}
+}
+
+// Publish sends right away only with nothing waiting, a failed flush keeps the rest in order.
+func TestBrokerOutboxReplayOrder(t *testing.T) {
+	outbox := outboxWithConfig(t, "outbox_test", support.OutboxConfig{})
+	published := []string{}
+	publish := func(topic string, msg string) error {
+		published = append(published, msg)
+		return nil
+	}
+	outbox.Publish(false, support.TopicTaskProcess("t1"), "1", publish)
+	outbox.Publish(false, support.TopicTaskProcess("t1"), "2", publish)
+	outbox.Publish(false, support.TopicTaskFinish("t1"), "3", publish)
+	if len(published) != 0 {
+		t.Fatalf("published %v while disconnected", published)
+	}
+
+	failAt := "2"
+	err := outbox.Flush(func(topic string, msg string) error {
+		if msg == failAt {
+			return errors.New("broker down")
+		}
+		return publish(topic, msg)
+	})
+	if err == nil || outbox.Len() != 2 {
+		t.Fatalf("Flush() = %v with %d left, want the error with 2 left", err, outbox.Len())
+	}
+
+	// Connected with message waiting, the new one goes behind them
+	outbox.Publish(true, support.TopicTaskProcess("t1"), "4", publish)
+	if want := []string{"1", "2", "3", "4"}; !reflect.DeepEqual(published, want) {
+		t.Fatalf("published %v, want %v", published, want)
+	}
+}
+
+// Only the status message is written to outbox.dir and replayed by the next process.
+func TestBrokerOutboxDisk(t *testing.T) {
+	dir := t.TempDir()
+	conf := support.OutboxConfig{Dir: dir}
+	outbox := outboxWithConfig(t, "outbox_disk", conf)
+	outbox.Push(support.TopicTaskWho("t1"), "host")
+	outbox.Push(support.TopicTaskProcess("t1"), "line")
+	outbox.Push(support.TopicTaskFailed("t1"), "error")
+	outbox.Push(support.TopicTelemetry("cpu"), "{}")
+	outbox.Push(support.TopicTaskFinish("t1"), "error")
+
+	files, _ := filepath.Glob(filepath.Join(dir, "outbox_disk.*.outbox.jsonl"))
+	if len(files) != 1 {
+		t.Fatalf("outbox file %v, want one", files)
+	}
+
+	restored := outboxWithConfig(t, "outbox_disk", conf)
+	want := []string{support.TopicTaskWho("t1"), support.TopicTaskFailed("t1"), support.TopicTaskFinish("t1")}
+	if topics := flushTopics(t, restored); !reflect.DeepEqual(topics, want) {
+		t.Fatalf("replayed %v, want %v", topics, want)
+	}
+
+	// The flushed status is removed from the file too
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 0 {
+		t.Fatalf("outbox file still has %q after flush", data)
+	}
+	if again := outboxWithConfig(t, "outbox_disk", conf); again.Len() != 0 {
+		t.Fatalf("restored %d message after flush, want 0", again.Len())
+	}
+}
@@ -0,0 +1,160 @@
+package support
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// BrokerTypeRegistration is everything the worker needs to know about a broker type.
+// Adding a broker type means registering it from the init of its file:
+//
+//	func init() {
+//		RegisterBrokerType("nats", BrokerTypeRegistration{...})
+//	}
+type BrokerTypeRegistration struct {
+	// Field of the broker_connection map, checked before decoding
+	Fields []ConfigField
+	// Rule between the field, like host or servers, optional
+	Validate func(path string, v map[string]interface{}, errs *ConfigErrors)
+	// File field, like nkey_file, downloaded with the TLS bundle of the Job Manager when missing
+	BundleFiles []string
+	// Pointer to an empty typed connection, the map is decoded into it by the yaml tag
+	NewConnection func() BrokerConInterface
+	// Open the connection from the typed connection
+	Construct func(conn BrokerConInterface) (BrokerConnectionInterface, error)
+}
+
+var brokerTypeRegistry = map[string]BrokerTypeRegistration{}
+var brokerTypeRegistryMutex sync.RWMutex
+
+// RegisterBrokerType registers the broker type, the same name registered twice panics.
+func RegisterBrokerType(name string, registration BrokerTypeRegistration) {
+	brokerTypeRegistryMutex.Lock()
+	defer brokerTypeRegistryMutex.Unlock()
+	if _, ok := brokerTypeRegistry[name]; ok {
+		panic("broker type " + name + " is already registered")
+	}
+	brokerTypeRegistry[name] = registration
+}
+
+// GetBrokerTypeRegistration returns the registration of the broker type.
+func GetBrokerTypeRegistration(name string) (BrokerTypeRegistration, bool) {
+	brokerTypeRegistryMutex.RLock()
+	defer brokerTypeRegistryMutex.RUnlock()
+	registration, ok := brokerTypeRegistry[name]
+	return registration, ok
+}
+
+// GetBrokerTypes returns every registered broker type.
+func GetBrokerTypes() []string {
+	brokerTypeRegistryMutex.RLock()
+	defer brokerTypeRegistryMutex.RUnlock()
+	types := []string{}
+	for brokerType := range brokerTypeRegistry {
+		types = append(types, brokerType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// DecodeBrokerConnection validates the broker_connection map and decodes it into
+// the typed connection of its type, path is the path of the map in the config.
+func DecodeBrokerConnection(path string, v map[string]interface{}) (BrokerConInterface, error) {
+	if errs := ValidateBrokerConnection(path, v); len(errs) > 0 {
+		return nil, errs
+	}
+	registration, _ := GetBrokerTypeRegistration(v["type"].(string))
+	conn := registration.NewConnection()
+	if errs := decodeConfigMap(path, v, conn); len(errs) > 0 {
+		return nil, errs
+	}
+	// The typed connection is used by value like the rest of the code
+	return reflect.ValueOf(conn).Elem().Interface().(BrokerConInterface), nil
+}
+
+// NewBrokerConnection opens the connection with the constructor of its type.
+func NewBrokerConnection(conn BrokerConInterface) (BrokerConnectionInterface, error) {
+	brokerType := reflect.ValueOf(conn.GetConnection()).FieldByName("Type").String()
+	registration, ok := GetBrokerTypeRegistration(brokerType)
+	if !ok {
+		return nil, fmt.Errorf("unsupported broker type %q", brokerType)
+	}
+	return registration.Construct(conn)
+}
+
+// decodeConfigMap copies the map into the struct pointer by the yaml tag.
+// The value is read leniently, a number can come as float64 from JSON or as a string from the environment.
+func decodeConfigMap(path string, v map[string]interface{}, out any) ConfigErrors {
+	errs := ConfigErrors{}
+	rv := reflect.ValueOf(out).Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		name := strings.Split(rt.Field(i).Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		value, ok := v[name]
+		if !ok || value == nil {
+			continue
+		}
+		fv := rv.Field(i)
+		switch fv.Kind() {
+		case reflect.String:
+			switch val := value.(type) {
+			case string:
+				fv.SetString(val)
+			case int, int64, float64, bool:
+				fv.SetString(fmt.Sprint(val))
+			default:
+				errs.add(path+"."+name, "expected string, got %s", configKindOf(value))
+			}
+		case reflect.Int:
+			switch val := value.(type) {
+			case int:
+				fv.SetInt(int64(val))
+			case int64:
+				fv.SetInt(val)
+			case float64:
+				fv.SetInt(int64(val))
+			case string:
+				number, err := strconv.Atoi(val)
+				if err != nil {
+					errs.add(path+"."+name, "expected number, got %q", val)
+					continue
+				}
+				fv.SetInt(int64(number))
+			default:
+				errs.add(path+"."+name, "expected number, got %s", configKindOf(value))
+			}
+		case reflect.Bool:
+			fv.SetBool(isTrue(value))
+		case reflect.Slice:
+			if fv.Type().Elem().Kind() != reflect.String {
+				continue
+			}
+			fv.Set(reflect.ValueOf(configStringList(value)))
+		}
+	}
+	return errs
+}
+
+// configHas returns true when the field is set and not an empty string.
+func configHas(v map[string]interface{}, name string) bool {
+	switch value := v[name].(type) {
+	case nil:
+		return false
+	case string:
+		return value != ""
+	}
+	return true
+}
+
+// configString returns the string field, empty when it is not a string.
+func configString(v map[string]interface{}, name string) string {
+	value, _ := v[name].(string)
+	return value
+}
@@ -0,0 +1,190 @@
+package support_test
+
+import (
+	"archive/zip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	support "job_item/support"
+)
+
+// registryTestConnection is the typed connection of the broker type registered by the test.
+type registryTestConnection struct {
+	Name   string   `yaml:"name"`
+	Key    string   `yaml:"key"`
+	Type   string   `yaml:"type"`
+	Port   int      `yaml:"port"`
+	Label  string   `yaml:"label"`
+	Tags   []string `yaml:"tags"`
+	Secure bool     `yaml:"secure"`
+}
+
+func (c registryTestConnection) GetConnection() any {
+	return c
+}
+
+var registerTestBrokerOnce sync.Once
+
+// registerTestBroker registers "registry_test" once, the registry is global to the package.
+func registerTestBroker() {
+	registerTestBrokerOnce.Do(func() {
+		server := support.MemoryBrokerServerConstruct()
+		support.RegisterBrokerType("registry_test", support.BrokerTypeRegistration{
+			Fields: []support.ConfigField{
+				{Name: "port", Kind: support.CONFIG_KIND_NUMBER, Required: true},
+				{Name: "label", Kind: support.CONFIG_KIND_STRING},
+				{Name: "tags", Kind: support.CONFIG_KIND_LIST},
+			},
+			Validate: func(path string, v map[string]interface{}, errs *support.ConfigErrors) {
+				if v["label"] == "forbidden" {
+					*errs = append(*errs, support.ConfigError{Path: path + ".label", Msg: "is forbidden"})
+				}
+			},
+			NewConnection: func() support.BrokerConInterface {
+				return &registryTestConnection{}
+			},
+			Construct: func(conn support.BrokerConInterface) (support.BrokerConnectionInterface, error) {
+				return support.MemorySupportConstruct(server, conn.GetConnection().(registryTestConnection).Key), nil
+			},
+		})
+	})
+}
+
+func TestRegisterBrokerTypeDuplicate(t *testing.T) {
+	registerTestBroker()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("registering registry_test twice did not panic")
+		}
+	}()
+	support.RegisterBrokerType("registry_test", support.BrokerTypeRegistration{})
+}
+
+func TestDecodeBrokerConnection(t *testing.T) {
+	registerTestBroker()
+	tests := []struct {
+		name    string
+		conn    map[string]interface{}
+		want    registryTestConnection
+		wantErr string
+	}{
+		{
+			name: "every field kind",
+			conn: map[string]interface{}{"port": 4222, "label": "main", "tags": []interface{}{"a", "b"}, "secure": true},
+			want: registryTestConnection{Port: 4222, Label: "main", Tags: []string{"a", "b"}, Secure: true},
+		},
+		{
+			name: "lenient value from JSON and the environment",
+			conn: map[string]interface{}{"port": "4222", "tags": "a, b", "secure": "true"},
+			want: registryTestConnection{Port: 4222, Tags: []string{"a", "b"}, Secure: true},
+		},
+		{
+			name: "float from JSON",
+			conn: map[string]interface{}{"port": float64(4222)},
+			want: registryTestConnection{Port: 4222},
+		},
+		{
+			name:    "wrong kind",
+			conn:    map[string]interface{}{"port": "nats-port", "tags": 1},
+			wantErr: "broker_connection.port: expected number, got string\nbroker_connection.tags: expected list, got number",
+		},
+		{
+			name:    "required field",
+			conn:    map[string]interface{}{"label": "main"},
+			wantErr: "broker_connection.port: is required",
+		},
+		{
+			name:    "rule of the type",
+			conn:    map[string]interface{}{"port": 1, "label": "forbidden"},
+			wantErr: "broker_connection.label: is forbidden",
+		},
+		{
+			name:    "unknown type",
+			conn:    map[string]interface{}{"type": "zeromq"},
+			wantErr: `broker_connection.type: unsupported broker type "zeromq"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := map[string]interface{}{"key": "registry", "name": "Registry", "type": "registry_test"}
+			for k, v := range tt.conn {
+				conn[k] = v
+			}
+			decoded, err := support.DecodeBrokerConnection("broker_connection", conn)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("DecodeBrokerConnection() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want
+			want.Key, want.Name, want.Type = "registry", "Registry", "registry_test"
+			if got, ok := decoded.(registryTestConnection); !ok || !reflect.DeepEqual(got, want) {
+				t.Fatalf("DecodeBrokerConnection() = %#v, want %#v", decoded, want)
+			}
+
+			// The constructor of the type opens it
+			opened, err := support.NewBrokerConnection(decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer opened.Close()
+			if _, ok := opened.(*support.MemorySupport); !ok {
+				t.Fatalf("NewBrokerConnection() = %T, want the connection of registry_test", opened)
+			}
+		})
+	}
+}
+
+// A missing nkey_file or creds_file is downloaded with the TLS bundle into its directory,
+// an existing one is kept as it is.
+func TestBrokerBundleFiles(t *testing.T) {
+	downloads := atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/worker/config/tls/download" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		downloads.Add(1)
+		archive := zip.NewWriter(w)
+		f, _ := archive.Create("worker.creds")
+		f.Write([]byte("from the bundle"))
+		archive.Close()
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	configYaml := &support.ConfigYamlSupport{}
+	configYaml.ConfigData.End_point = server.URL
+	conn := map[string]interface{}{
+		"key": "main", "name": "main", "type": "nats", "host": "localhost", "port": 4222,
+		"auth_type": "creds", "creds_file": filepath.Join(dir, "nats", "worker.creds"),
+	}
+	if _, err := configYaml.GetTypeBrokerCon(conn); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "nats", "worker.creds"))
+	if err != nil || string(data) != "from the bundle" {
+		t.Fatalf("creds_file = %q, %v, want the file of the bundle", data, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "nats", "worker.creds"), []byte("local"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := configYaml.GetTypeBrokerCon(conn); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "nats", "worker.creds")); string(data) != "local" || downloads.Load() != 1 {
+		t.Fatalf("existing creds_file rewritten to %q after %d download", data, downloads.Load())
+	}
+}
@@ -0,0 +1,146 @@
+package support
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Connection lifecycle event published on the EventBus with the connection key as argument.
+const (
+	BROKER_EVENT_CONNECTED    = "broker_connected"
+	BROKER_EVENT_DISCONNECTED = "broker_disconnected"
+	BROKER_EVENT_RECONNECTED  = "broker_reconnected"
+)
+
+type brokerSubscription struct {
+	topic     string
+	subscribe func() (func(), error)
+	cancel    func()
+}
+
+func BrokerSubscriptionRegistryConstruct(key string) *BrokerSubscriptionRegistry {
+	return &BrokerSubscriptionRegistry{
+		key:  key,
+		subs: map[int]*brokerSubscription{},
+	}
+}
+
+// BrokerSubscriptionRegistry keeps every active subscription of a connection
+// so the broker can re-establish them after reconnect, and keeps the health state
+// of the connection.
+type BrokerSubscriptionRegistry struct {
+	key          string
+	mutex        sync.Mutex
+	lastId       int
+	subs         map[int]*brokerSubscription
+	connected    bool
+	hasConnected bool
+	closed       bool
+}
+
+// Subscribe runs the subscribe function and keeps it to run again on Resubscribe.
+// The returned function unsubscribes and is safe to call more than once.
+func (c *BrokerSubscriptionRegistry) Subscribe(topic string, subscribe func() (func(), error)) (func(), error) {
+	cancel, err := subscribe()
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	c.lastId++
+	id := c.lastId
+	c.subs[id] = &brokerSubscription{
+		topic:     topic,
+		subscribe: subscribe,
+		cancel:    cancel,
+	}
+	c.mutex.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mutex.Lock()
+			sub, ok := c.subs[id]
+			delete(c.subs, id)
+			c.mutex.Unlock()
+			if ok && sub.cancel != nil {
+				sub.cancel()
+			}
+		})
+	}, nil
+}
+
+// Resubscribe re-establishes every subscription on the new connection.
+// The old cancel function is dropped because it belongs to the closed connection.
+func (c *BrokerSubscriptionRegistry) Resubscribe() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, sub := range c.subs {
+		cancel, err := sub.subscribe()
+		if err != nil {
+			Helper.PrintErrName("Resubscribe "+sub.topic+" failed :: "+err.Error(), "ERR-27100903201")
+			continue
+		}
+		sub.cancel = cancel
+	}
+	Helper.PrintGroupName(fmt.Sprint("Resubscribed ", len(c.subs), " subscription for connection :: ", c.key))
+}
+
+// Close cancels every subscription and marks the connection as closed,
+// the broker stops reconnecting once the connection is closed.
+func (c *BrokerSubscriptionRegistry) Close() {
+	c.mutex.Lock()
+	subs := c.subs
+	c.subs = map[int]*brokerSubscription{}
+	c.closed = true
+	c.mutex.Unlock()
+	for _, sub := range subs {
+		if sub.cancel != nil {
+			sub.cancel()
+		}
+	}
+	c.SetConnected(false)
+}
+
+// IsClosed returns true after Close.
+func (c *BrokerSubscriptionRegistry) IsClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closed
+}
+
+// Len returns total active subscription.
+func (c *BrokerSubscriptionRegistry) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.subs)
+}
+
+// SetConnected updates the health state and publishes the lifecycle event when the state changes.
+func (c *BrokerSubscriptionRegistry) SetConnected(connected bool) {
+	c.mutex.Lock()
+	if c.connected == connected {
+		c.mutex.Unlock()
+		return
+	}
+	c.connected = connected
+	event := BROKER_EVENT_DISCONNECTED
+	if connected {
+		event = BROKER_EVENT_CONNECTED
+		if c.hasConnected {
+			event = BROKER_EVENT_RECONNECTED
+		}
+		c.hasConnected = true
+	}
+	c.mutex.Unlock()
+
+	if Helper != nil && Helper.EventBus != nil {
+		Helper.EventBus.GetBus().Publish(event, c.key)
+	}
+}
+
+// IsConnected returns the last known health state.
+func (c *BrokerSubscriptionRegistry) IsConnected() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.connected
+}
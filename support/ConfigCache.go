@@ -0,0 +1,201 @@
+package support
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Where the config of the running worker comes from
+const (
+	CONFIG_SOURCE_LOCAL  = "local"
+	CONFIG_SOURCE_SERVER = "server"
+	CONFIG_SOURCE_CACHE  = "cache"
+)
+
+const DEFAULT_CONFIG_CACHE_PATH = ".job_item_cache/config.cache"
+const DEFAULT_CONFIG_CACHE_REFRESH_SECOND = 60
+
+// ConfigCacheConfig is the config_cache of the config.
+// The last config received from the Job Manager is kept encrypted on disk, so the worker
+// still starts when the Job Manager is down, and goes back to the Job Manager once it is up.
+type ConfigCacheConfig struct {
+	// Skip the cache, the worker does not start without the Job Manager
+	Disabled bool `yaml:"disabled" json:"disabled,omitempty"`
+	// Relative to config.yaml, default .job_item_cache/config.cache
+	Path string `yaml:"path" json:"path,omitempty"`
+	// How often the Job Manager is tried again while running from the cache, default 60
+	Refresh_second int `yaml:"refresh_second" json:"refresh_second,omitempty"`
+}
+
+func (c ConfigCacheConfig) GetPath() string {
+	if c.Path == "" {
+		return DEFAULT_CONFIG_CACHE_PATH
+	}
+	return c.Path
+}
+
+func (c ConfigCacheConfig) GetRefreshInterval() time.Duration {
+	if c.Refresh_second <= 0 {
+		return DEFAULT_CONFIG_CACHE_REFRESH_SECOND * time.Second
+	}
+	return time.Duration(c.Refresh_second) * time.Second
+}
+
+type configCacheContent struct {
+	Saved_at time.Time `json:"saved_at"`
+	// What the Job Manager returned, merged into the local config like a fresh response
+	Server_data ConfigData `json:"server_data"`
+}
+
+// configCacheKey derives the AES-256 key from the credential, a cache is only readable
+// with the project and secret key that saved it.
+func (c *ConfigYamlSupport) configCacheKey() []byte {
+	key := sha256.Sum256([]byte("job_item config cache\x00" + c.ConfigData.Credential.Project_id + "\x00" + c.ConfigData.Credential.Secret_key))
+	return key[:]
+}
+
+// configCacheAad binds the cache to the end point and the project.
+func (c *ConfigYamlSupport) configCacheAad() []byte {
+	return []byte(c.ConfigData.End_point + "\x00" + c.ConfigData.Credential.Project_id)
+}
+
+// saveConfigCache encrypts the server data with AES-GCM and replaces the cache file.
+func (c *ConfigYamlSupport) saveConfigCache(serverData ConfigData) error {
+	if c.ConfigData.Config_cache.Disabled {
+		return nil
+	}
+	content, err := json.Marshal(configCacheContent{Saved_at: time.Now(), Server_data: serverData})
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(c.configCacheKey())
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := gcm.Seal(nonce, nonce, content, c.configCacheAad())
+
+	path := c.ConfigData.Config_cache.GetPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	// Write then rename, a crash never leaves half a cache
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(sealed); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// loadConfigCache decrypts the cache and returns the server data and when it was saved.
+func (c *ConfigYamlSupport) loadConfigCache() (ConfigData, time.Time, error) {
+	if c.ConfigData.Config_cache.Disabled {
+		return ConfigData{}, time.Time{}, errors.New("config cache is disabled")
+	}
+	sealed, err := os.ReadFile(c.ConfigData.Config_cache.GetPath())
+	if err != nil {
+		return ConfigData{}, time.Time{}, err
+	}
+	block, err := aes.NewCipher(c.configCacheKey())
+	if err != nil {
+		return ConfigData{}, time.Time{}, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return ConfigData{}, time.Time{}, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return ConfigData{}, time.Time{}, errors.New("config cache is corrupted")
+	}
+	content, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], c.configCacheAad())
+	if err != nil {
+		return ConfigData{}, time.Time{}, errors.New("config cache can not be decrypted, the credential or the end_point changed")
+	}
+	cache := configCacheContent{}
+	if err := json.Unmarshal(content, &cache); err != nil {
+		return ConfigData{}, time.Time{}, fmt.Errorf("config cache is corrupted: %w", err)
+	}
+	return cache.Server_data, cache.Saved_at, nil
+}
+
+// ConfigServerStatusError is the Job Manager answering the config request with another status than 200.
+type ConfigServerStatusError struct {
+	Status_code int
+	Status      string
+}
+
+func (c *ConfigServerStatusError) Error() string {
+	return fmt.Sprintf("server returned non-200 status: %d %s", c.Status_code, c.Status)
+}
+
+// useConfigCache applies the cached server data when the Job Manager can not be reached.
+// A Job Manager refusing the request, like a wrong secret key, is not a reason to use the cache.
+func (c *ConfigYamlSupport) useConfigCache(serverErr error) error {
+	var statusErr *ConfigServerStatusError
+	if errors.As(serverErr, &statusErr) && statusErr.Status_code < 500 {
+		return serverErr
+	}
+	serverData, savedAt, err := c.loadConfigCache()
+	if err != nil {
+		c.printGroupName("No usable config cache :: " + err.Error())
+		return serverErr
+	}
+	c.applyServerConfig(serverData)
+	c.config_source = CONFIG_SOURCE_CACHE
+	c.cached_at = savedAt
+	c.printGroupName("WARNING: Job Manager is unreachable, running from cached config of " + savedAt.Format(time.RFC3339))
+	return nil
+}
+
+// GetConfigSource returns local, server or cache, and when the cache was saved.
+func (c *ConfigYamlSupport) GetConfigSource() (string, time.Time) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.config_source, c.cached_at
+}
+
+// RefreshFromServer tries the Job Manager again while the worker runs from the cache.
+// Once it answers, the cache is saved and the worker restarts with the fresh config.
+func (c *ConfigYamlSupport) RefreshFromServer() {
+	if c.config_source != CONFIG_SOURCE_CACHE {
+		return
+	}
+	ticker := time.NewTicker(c.ConfigData.Config_cache.GetRefreshInterval())
+	defer ticker.Stop()
+	for range ticker.C {
+		// Load on a copy, the running config stays until the restart
+		probe := ConfigYamlSupport{Config_path: c.Config_path, ConfigData: c.GetConfigData()}
+		if err := probe.loadServerCOnfig(); err != nil {
+			c.printGroupName("Job Manager is still unreachable, keep the cached config :: " + err.Error())
+			continue
+		}
+		c.printGroupName("Job Manager is reachable again, restart with the fresh config")
+		if Helper != nil && Helper.EventBus != nil {
+			Helper.EventBus.GetBus().Publish("job_item_restart", nil)
+		}
+		return
+	}
+}
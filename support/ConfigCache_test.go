@@ -0,0 +1,90 @@
+package support
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func configCacheTestSupport(path string) *ConfigYamlSupport {
+	c := &ConfigYamlSupport{}
+	c.ConfigData.End_point = "http://job-manager"
+	c.ConfigData.Credential.Project_id = "project"
+	c.ConfigData.Credential.Secret_key = "key"
+	c.ConfigData.Config_cache.Path = path
+	return c
+}
+
+func TestConfigCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "config.cache")
+	c := configCacheTestSupport(path)
+
+	serverData := ConfigData{}
+	serverData.Jobs = []ConfigJob{{Event: "backup", Cmd: "backup.sh"}}
+	serverData.Credential.Secret_key = "from-server"
+	if err := c.saveConfigCache(serverData); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	sealed, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read cache: %v", err)
+	}
+	if bytes.Contains(sealed, []byte("backup.sh")) || bytes.Contains(sealed, []byte("from-server")) {
+		t.Fatalf("cache file is not encrypted")
+	}
+
+	loaded, savedAt, err := c.loadConfigCache()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if savedAt.IsZero() {
+		t.Errorf("saved_at is zero")
+	}
+	if !reflect.DeepEqual(loaded.Jobs, serverData.Jobs) || loaded.Credential.Secret_key != "from-server" {
+		t.Errorf("loaded %+v, want %+v", loaded, serverData)
+	}
+
+	// Another credential or end point can not read it
+	other := configCacheTestSupport(path)
+	other.ConfigData.Credential.Secret_key = "other"
+	if _, _, err := other.loadConfigCache(); err == nil {
+		t.Errorf("cache is readable with another secret key")
+	}
+	other = configCacheTestSupport(path)
+	other.ConfigData.End_point = "http://other"
+	if _, _, err := other.loadConfigCache(); err == nil {
+		t.Errorf("cache is readable with another end_point")
+	}
+
+	// A changed byte fails the authentication
+	sealed[len(sealed)-1] ^= 1
+	if err := os.WriteFile(path, sealed, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.loadConfigCache(); err == nil {
+		t.Errorf("tampered cache is loaded")
+	}
+	if err := os.WriteFile(path, []byte("short"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.loadConfigCache(); err == nil {
+		t.Errorf("truncated cache is loaded")
+	}
+}
+
+func TestConfigCacheDisabled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.cache")
+	c := configCacheTestSupport(path)
+	c.ConfigData.Config_cache.Disabled = true
+	if err := c.saveConfigCache(ConfigData{}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("cache is written while disabled")
+	}
+	if _, _, err := c.loadConfigCache(); err == nil {
+		t.Errorf("cache is loaded while disabled")
+	}
+}
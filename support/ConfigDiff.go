@@ -0,0 +1,172 @@
+package support
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// ConfigDiff is what changed between the running config and the new one from the Job Manager.
+// A job is known by its connection and event, an exec by its key (or its name without key)
+// and a broker connection by its key. A changed job or exec is removed then added again.
+type ConfigDiff struct {
+	Jobs_added    []ConfigJob
+	Jobs_removed  []ConfigJob
+	Execs_added   []ExecConfig
+	Execs_removed []ExecConfig
+	// The broker connection config, with its new setting for a changed one
+	Brokers_added   []map[string]interface{}
+	Brokers_changed []map[string]interface{}
+	Brokers_removed []string
+	// The config field that can not be applied in place, like the credential or the topic
+	Restart []string
+}
+
+// IsEmpty returns true when nothing changed.
+func (c ConfigDiff) IsEmpty() bool {
+	return len(c.Jobs_added) == 0 && len(c.Jobs_removed) == 0 &&
+		len(c.Execs_added) == 0 && len(c.Execs_removed) == 0 &&
+		len(c.Brokers_added) == 0 && len(c.Brokers_changed) == 0 && len(c.Brokers_removed) == 0 &&
+		len(c.Restart) == 0
+}
+
+// GetExecKey returns the key of the exec, the name when the key is empty.
+func GetExecKey(execConfig ExecConfig) string {
+	if execConfig.Key != "" {
+		return execConfig.Key
+	}
+	return execConfig.Name
+}
+
+// DiffConfig compares the running config with the next one.
+func DiffConfig(current *ConfigYamlSupport, next *ConfigYamlSupport) ConfigDiff {
+	diff := ConfigDiff{}
+
+	// Broker connection
+	currentBrokers := map[string]map[string]interface{}{}
+	for _, v := range current.GetBrokerConnections() {
+		key, _ := v["key"].(string)
+		currentBrokers[key] = v
+	}
+	nextBrokers := map[string]bool{}
+	for _, v := range next.GetBrokerConnections() {
+		key, _ := v["key"].(string)
+		nextBrokers[key] = true
+		old, ok := currentBrokers[key]
+		if !ok {
+			diff.Brokers_added = append(diff.Brokers_added, v)
+		} else if !configEqual(old, v) {
+			diff.Brokers_changed = append(diff.Brokers_changed, v)
+		}
+	}
+	for _, v := range current.GetBrokerConnections() {
+		key, _ := v["key"].(string)
+		if !nextBrokers[key] {
+			diff.Brokers_removed = append(diff.Brokers_removed, key)
+		}
+	}
+	// The main process listens the shutdown on the default connection,
+	// so the default connection is only replaced by a restart
+	defaultChanged := current.GetDefaultBrokerKey() != next.GetDefaultBrokerKey()
+	for _, v := range diff.Brokers_changed {
+		if v["key"] == next.GetDefaultBrokerKey() {
+			defaultChanged = true
+		}
+	}
+	if defaultChanged {
+		diff.Restart = append(diff.Restart, "broker_connection")
+	}
+
+	// Job, also changed when the event registered on the project changed
+	jobKey := func(c *ConfigYamlSupport, job ConfigJob) string {
+		return c.GetJobConnectionKey(job) + "\x00" + job.Event
+	}
+	currentJobs := map[string]ConfigJob{}
+	for _, v := range current.ConfigData.Jobs {
+		currentJobs[jobKey(current, v)] = v
+	}
+	nextJobs := map[string]bool{}
+	for _, v := range next.ConfigData.Jobs {
+		key := jobKey(next, v)
+		nextJobs[key] = true
+		old, ok := currentJobs[key]
+		if ok && configEqual(old, v) && configEqual(current.GetJobEvents(old), next.GetJobEvents(v)) {
+			continue
+		}
+		if ok {
+			diff.Jobs_removed = append(diff.Jobs_removed, old)
+		}
+		diff.Jobs_added = append(diff.Jobs_added, v)
+	}
+	for _, v := range current.ConfigData.Jobs {
+		if !nextJobs[jobKey(current, v)] {
+			diff.Jobs_removed = append(diff.Jobs_removed, v)
+		}
+	}
+
+	// Exec
+	currentExecs := map[string]ExecConfig{}
+	for _, v := range current.ConfigData.Execs {
+		currentExecs[GetExecKey(v)] = v
+	}
+	nextExecs := map[string]bool{}
+	for _, v := range next.ConfigData.Execs {
+		nextExecs[GetExecKey(v)] = true
+		old, ok := currentExecs[GetExecKey(v)]
+		if ok && configEqual(old, v) {
+			continue
+		}
+		if ok {
+			diff.Execs_removed = append(diff.Execs_removed, old)
+		}
+		diff.Execs_added = append(diff.Execs_added, v)
+	}
+	for _, v := range current.ConfigData.Execs {
+		if !nextExecs[GetExecKey(v)] {
+			diff.Execs_removed = append(diff.Execs_removed, v)
+		}
+	}
+
+	diff.Restart = append(diff.Restart, diffRestartFields(current.ConfigData, next.ConfigData)...)
+	return diff
+}
+
+// diffRestartFields returns the name of every other changed field of the config.
+func diffRestartFields(current ConfigData, next ConfigData) []string {
+	// Applied in place
+	for _, v := range []*ConfigData{&current, &next} {
+		v.Jobs = nil
+		v.Execs = nil
+		v.Broker_connection = nil
+		v.Broker_connections = nil
+		v.Project.Job_datas = nil
+	}
+	fields := []string{}
+	currentValue := reflect.ValueOf(current)
+	nextValue := reflect.ValueOf(next)
+	for i := 0; i < currentValue.NumField(); i++ {
+		field := currentValue.Type().Field(i)
+		// Not handed off to the child process, already applied on the other value
+		if field.Tag.Get("json") == "-" {
+			continue
+		}
+		if configEqual(currentValue.Field(i).Interface(), nextValue.Field(i).Interface()) {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields = append(fields, name)
+	}
+	return fields
+}
+
+// configEqual compares the JSON of both value, the config handed off to the child process
+// went through JSON so a number of the YAML is a float64 there.
+func configEqual(a any, b any) bool {
+	jsonA, errA := json.Marshal(a)
+	jsonB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(jsonA, jsonB)
+}
@@ -0,0 +1,159 @@
+package support_test
+
+import (
+	"reflect"
+	"testing"
+
+	support "job_item/support"
+)
+
+func diffTestConfig() support.ConfigData {
+	configData := support.ConfigData{}
+	configData.Credential.Project_id = "project"
+	configData.Credential.Secret_key = "key"
+	configData.Broker_connection = map[string]interface{}{"key": "main", "type": "nats", "host": "localhost", "port": 4222}
+	configData.Broker_connections = []map[string]interface{}{
+		{"key": "other", "type": "redis", "host": "localhost", "port": 6379},
+	}
+	configData.Jobs = []support.ConfigJob{
+		{Event: "backup", Cmd: "backup.sh"},
+		{Event: "report", Cmd: "report.sh", Connection: "other"},
+	}
+	configData.Execs = []support.ExecConfig{
+		{Name: "web", Key: "web", Cmd: "web.sh"},
+		{Name: "cron", Cmd: "cron.sh"},
+	}
+	return configData
+}
+
+func diffConfig(next func(configData *support.ConfigData)) support.ConfigDiff {
+	current := &support.ConfigYamlSupport{ConfigData: diffTestConfig()}
+	nextConfig := &support.ConfigYamlSupport{ConfigData: diffTestConfig()}
+	next(&nextConfig.ConfigData)
+	return support.DiffConfig(current, nextConfig)
+}
+
+func TestDiffConfigNothing(t *testing.T) {
+	diff := diffConfig(func(configData *support.ConfigData) {
+		// Through the JSON handoff a number of the YAML comes back as a float64
+		configData.Broker_connection["port"] = float64(4222)
+	})
+	if !diff.IsEmpty() {
+		t.Errorf("diff is %+v, want empty", diff)
+	}
+}
+
+func TestDiffConfigJob(t *testing.T) {
+	diff := diffConfig(func(configData *support.ConfigData) {
+		configData.Jobs = []support.ConfigJob{
+			{Event: "backup", Cmd: "backup-v2.sh"},
+			{Event: "cleanup", Cmd: "cleanup.sh"},
+		}
+	})
+	added := []string{}
+	for _, v := range diff.Jobs_added {
+		added = append(added, v.Event)
+	}
+	removed := []string{}
+	for _, v := range diff.Jobs_removed {
+		removed = append(removed, v.Event)
+	}
+	if !reflect.DeepEqual(added, []string{"backup", "cleanup"}) {
+		t.Errorf("job added %v", added)
+	}
+	if !reflect.DeepEqual(removed, []string{"backup", "report"}) {
+		t.Errorf("job removed %v", removed)
+	}
+	if len(diff.Restart) > 0 || len(diff.Execs_added)+len(diff.Execs_removed) > 0 {
+		t.Errorf("diff is %+v, want only the job", diff)
+	}
+}
+
+func TestDiffConfigJobConnection(t *testing.T) {
+	// The same event moved to another connection is removed from the old one
+	diff := diffConfig(func(configData *support.ConfigData) {
+		configData.Jobs[0].Connection = "other"
+	})
+	if len(diff.Jobs_added) != 1 || diff.Jobs_added[0].Connection != "other" {
+		t.Errorf("job added %+v", diff.Jobs_added)
+	}
+	if len(diff.Jobs_removed) != 1 || diff.Jobs_removed[0].Connection != "" {
+		t.Errorf("job removed %+v", diff.Jobs_removed)
+	}
+}
+
+func TestDiffConfigExec(t *testing.T) {
+	diff := diffConfig(func(configData *support.ConfigData) {
+		configData.Execs = []support.ExecConfig{
+			{Name: "web", Key: "web", Cmd: "web.sh", Attempt: 5},
+			{Name: "worker", Cmd: "worker.sh"},
+		}
+	})
+	added := []string{}
+	for _, v := range diff.Execs_added {
+		added = append(added, support.GetExecKey(v))
+	}
+	removed := []string{}
+	for _, v := range diff.Execs_removed {
+		removed = append(removed, support.GetExecKey(v))
+	}
+	if !reflect.DeepEqual(added, []string{"web", "worker"}) {
+		t.Errorf("exec added %v", added)
+	}
+	if !reflect.DeepEqual(removed, []string{"web", "cron"}) {
+		t.Errorf("exec removed %v", removed)
+	}
+	if len(diff.Restart) > 0 {
+		t.Errorf("restart %v", diff.Restart)
+	}
+}
+
+func TestDiffConfigBroker(t *testing.T) {
+	diff := diffConfig(func(configData *support.ConfigData) {
+		configData.Broker_connections = []map[string]interface{}{
+			{"key": "other", "type": "redis", "host": "redis", "port": 6379},
+			{"key": "extra", "type": "memory"},
+		}
+	})
+	if len(diff.Brokers_added) != 1 || diff.Brokers_added[0]["key"] != "extra" {
+		t.Errorf("broker added %v", diff.Brokers_added)
+	}
+	if len(diff.Brokers_changed) != 1 || diff.Brokers_changed[0]["host"] != "redis" {
+		t.Errorf("broker changed %v", diff.Brokers_changed)
+	}
+	if len(diff.Brokers_removed) > 0 || len(diff.Restart) > 0 {
+		t.Errorf("diff is %+v, want only the broker", diff)
+	}
+
+	diff = diffConfig(func(configData *support.ConfigData) {
+		configData.Broker_connections = nil
+	})
+	if !reflect.DeepEqual(diff.Brokers_removed, []string{"other"}) {
+		t.Errorf("broker removed %v", diff.Brokers_removed)
+	}
+}
+
+func TestDiffConfigRestart(t *testing.T) {
+	diff := diffConfig(func(configData *support.ConfigData) {
+		configData.Broker_connection["host"] = "nats"
+	})
+	if !reflect.DeepEqual(diff.Restart, []string{"broker_connection"}) {
+		t.Errorf("default broker changed, restart %v", diff.Restart)
+	}
+
+	diff = diffConfig(func(configData *support.ConfigData) {
+		configData.Credential.Secret_key = "new"
+		configData.Topic.Prefix = "team"
+	})
+	if !reflect.DeepEqual(diff.Restart, []string{"credential", "topic"}) {
+		t.Errorf("restart %v, want credential and topic", diff.Restart)
+	}
+
+	// Not handed off to the child process, not a reason to restart
+	diff = diffConfig(func(configData *support.ConfigData) {
+		configData.Include = []string{"jobs/*.yaml"}
+	})
+	if !diff.IsEmpty() {
+		t.Errorf("diff is %+v, want empty", diff)
+	}
+}
@@ -0,0 +1,67 @@
+package support
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// The main process hands the resolved config to child_process and child_execs_process
+// through a temp file readable only by the user. The path is passed on JOB_ITEM_CONFIG_FILE
+// and the child deletes the file once it is read, so the config and its secret never
+// show up in the environment of any process.
+const CONFIG_HANDOFF_ENV = "JOB_ITEM_CONFIG_FILE"
+
+type configHandoff struct {
+	Config_data ConfigData `json:"config_data"`
+	// Path of the value resolved from a secret://, to hide it from the log of the child
+	Secret_paths []string `json:"secret_paths,omitempty"`
+	// Where the main process got the config, for the health of the child
+	Config_source string    `json:"config_source"`
+	Cached_at     time.Time `json:"cached_at"`
+}
+
+// writeConfigHandoff writes the config for one child process and returns the path of the file.
+func (c *ConfigYamlSupport) writeConfigHandoff() (string, error) {
+	content, err := json.Marshal(configHandoff{
+		Config_data:   c.ConfigData,
+		Secret_paths:  c.secret_paths,
+		Config_source: c.config_source,
+		Cached_at:     c.cached_at,
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshal config handoff: %w", err)
+	}
+	// os.CreateTemp creates the file with mode 0600
+	file, err := os.CreateTemp("", "job_item_config_*.json")
+	if err != nil {
+		return "", fmt.Errorf("create config handoff: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(content); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("write config handoff: %w", err)
+	}
+	return file.Name(), nil
+}
+
+// readConfigHandoff reads the config written by the main process and deletes the file.
+func (c *ConfigYamlSupport) readConfigHandoff(path string) error {
+	content, err := os.ReadFile(path)
+	os.Remove(path)
+	if err != nil {
+		return fmt.Errorf("read config handoff: %w", err)
+	}
+	handoff := configHandoff{}
+	if err := json.Unmarshal(content, &handoff); err != nil {
+		return fmt.Errorf("unmarshal config handoff: %w", err)
+	}
+	c.ConfigData = handoff.Config_data
+	c.secret_paths = handoff.Secret_paths
+	c.config_source = handoff.Config_source
+	c.cached_at = handoff.Cached_at
+	// The variable points to a deleted file, the process started by the child must not see it
+	os.Unsetenv(CONFIG_HANDOFF_ENV)
+	return nil
+}
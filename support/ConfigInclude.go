@@ -0,0 +1,105 @@
+package support
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"dario.cat/mergo"
+	"gopkg.in/yaml.v3"
+)
+
+// Name of the profile applied on the config, set by --profile too
+const CONFIG_PROFILE_ENV = "JOB_ITEM_PROFILE"
+
+// loadIncludes merges every file matched by the include of the config, in the order of the
+// include then of the file name. The job, exec and other list are appended, the other value
+// only fills what config.yaml left empty, like the server config.
+func (c *ConfigYamlSupport) loadIncludes() error {
+	errs := ConfigErrors{}
+	c.include_files = nil
+	for i, pattern := range c.ConfigData.Include {
+		path := fmt.Sprintf("include[%d]", i)
+		files, err := filepath.Glob(c.configRelative(pattern))
+		if err != nil {
+			errs.add(path, "%s", err.Error())
+			continue
+		}
+		// A glob may match nothing yet, a plain file name must be there
+		if len(files) == 0 && !hasGlobMeta(pattern) {
+			errs.add(path, "file %s does not exist", pattern)
+			continue
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			yamlFile, err := os.ReadFile(file)
+			if err != nil {
+				errs.add(path, "%s", err.Error())
+				continue
+			}
+			include := ConfigData{}
+			if err := yaml.Unmarshal(yamlFile, &include); err != nil {
+				errs.add(path, "%s: %s", file, err.Error())
+				continue
+			}
+			if len(include.Include) > 0 || len(include.Profiles) > 0 {
+				errs.add(path, "%s: include and profiles are only read from %s", file, c.Config_path)
+				continue
+			}
+			if err := mergo.Merge(&c.ConfigData, include, mergo.WithAppendSlice); err != nil {
+				errs.add(path, "%s: %s", file, err.Error())
+				continue
+			}
+			c.include_files = append(c.include_files, file)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// applyProfile overlays the profile selected by JOB_ITEM_PROFILE, its value overrides the config
+// and the include. An empty value of the profile, like false or 0, does not override.
+// The other profiles are dropped so their ${VAR} is never required.
+func (c *ConfigYamlSupport) applyProfile() error {
+	profiles := c.ConfigData.Profiles
+	c.ConfigData.Profiles = nil
+	c.profile = os.Getenv(CONFIG_PROFILE_ENV)
+	if c.profile == "" {
+		return nil
+	}
+	profile, ok := profiles[c.profile]
+	if !ok {
+		return ConfigErrors{{Path: "profiles", Msg: fmt.Sprintf("profile %q is not defined", c.profile)}}
+	}
+	if len(profile.Include) > 0 || len(profile.Profiles) > 0 {
+		return ConfigErrors{{Path: "profiles." + c.profile, Msg: "include and profiles can not be set in a profile"}}
+	}
+	return mergo.Merge(&c.ConfigData, profile, mergo.WithOverride)
+}
+
+// GetIncludeFiles returns every file merged by the include.
+func (c *ConfigYamlSupport) GetIncludeFiles() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.include_files
+}
+
+// GetProfile returns the profile applied on the config, empty without profile.
+func (c *ConfigYamlSupport) GetProfile() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.profile
+}
+
+func hasGlobMeta(pattern string) bool {
+	for _, r := range pattern {
+		switch r {
+		case '*', '?', '[', '\\':
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package support
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// includeTestSupport writes every file into a temp dir and returns the config of config.yaml in it.
+func includeTestSupport(t *testing.T, files map[string]string) *ConfigYamlSupport {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &ConfigYamlSupport{Config_path: filepath.Join(dir, "config.yaml"), config_dir: dir}
+}
+
+func includeJobEvents(c *ConfigYamlSupport) []string {
+	events := []string{}
+	for _, v := range c.ConfigData.Jobs {
+		events = append(events, v.Event)
+	}
+	return events
+}
+
+// The include is merged in its order then in the order of the file name of a glob.
+func TestLoadIncludesOrder(t *testing.T) {
+	c := includeTestSupport(t, map[string]string{
+		"config.yaml": `
+end_point: http://manager
+include:
+  - jobs/*.yaml
+  - extra.yaml
+jobs:
+  - event: main
+    cmd: main.sh
+`,
+		"jobs/b.yaml": "jobs:\n  - event: b\n    cmd: b.sh\n",
+		"jobs/a.yaml": "end_point: http://other\njobs:\n  - event: a\n    cmd: a.sh\n",
+		"extra.yaml":  "jobs:\n  - event: extra\n    cmd: extra.sh\n",
+	})
+	t.Setenv(CONFIG_PROFILE_ENV, "")
+	if err := c.LoadConfigYaml(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := includeJobEvents(c), []string{"main", "a", "b", "extra"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("jobs %v, want %v", got, want)
+	}
+	// The include only fills what config.yaml left empty
+	if c.ConfigData.End_point != "http://manager" {
+		t.Fatalf("end_point = %q, want the one of config.yaml", c.ConfigData.End_point)
+	}
+	dir := filepath.Dir(c.Config_path)
+	want := []string{filepath.Join(dir, "jobs", "a.yaml"), filepath.Join(dir, "jobs", "b.yaml"), filepath.Join(dir, "extra.yaml")}
+	if got := c.GetIncludeFiles(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("GetIncludeFiles() = %v, want %v", got, want)
+	}
+}
+
+func TestLoadIncludesError(t *testing.T) {
+	tests := []struct {
+		name    string
+		include string
+		files   map[string]string
+		want    []string
+	}{
+		{
+			name:    "glob without match",
+			include: "jobs/*.yaml",
+			want:    []string{},
+		},
+		{
+			name:    "missing plain file",
+			include: "jobs.yaml",
+			want:    []string{"include[0]: file jobs.yaml does not exist"},
+		},
+		{
+			name:    "nested include",
+			include: "jobs.yaml",
+			files:   map[string]string{"jobs.yaml": "include:\n  - other.yaml\n"},
+			want:    []string{"include[0]: %s/jobs.yaml: include and profiles are only read from %s/config.yaml"},
+		},
+		{
+			name:    "profiles in an include",
+			include: "jobs.yaml",
+			files:   map[string]string{"jobs.yaml": "profiles:\n  prod:\n    end_point: http://prod\n"},
+			want:    []string{"include[0]: %s/jobs.yaml: include and profiles are only read from %s/config.yaml"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := includeTestSupport(t, tt.files)
+			c.ConfigData.Include = []string{tt.include}
+			got := []string{}
+			var errs ConfigErrors
+			if err := c.loadIncludes(); errors.As(err, &errs) {
+				for _, v := range errs {
+					got = append(got, v.Error())
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			dir := filepath.Dir(c.Config_path)
+			want := []string{}
+			for _, v := range tt.want {
+				want = append(want, strings.ReplaceAll(v, "%s", dir))
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("loadIncludes() = %q, want %q", got, want)
+			}
+			if len(c.GetIncludeFiles()) != 0 {
+				t.Fatalf("GetIncludeFiles() = %v, want none", c.GetIncludeFiles())
+			}
+		})
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": `
+include:
+  - manager.yaml
+profiles:
+  prod:
+    end_point: http://prod
+    register_jobs: false
+  broken:
+    include:
+      - other.yaml
+`,
+		"manager.yaml": "end_point: http://include\nregister_jobs: true\n",
+	}
+
+	// The profile overrides the include, its empty value does not
+	t.Setenv(CONFIG_PROFILE_ENV, "prod")
+	c := includeTestSupport(t, files)
+	if err := c.LoadConfigYaml(); err != nil {
+		t.Fatal(err)
+	}
+	if c.ConfigData.End_point != "http://prod" || !c.ConfigData.Register_jobs || c.GetProfile() != "prod" {
+		t.Fatalf("end_point %q register_jobs %v profile %q, want the prod end_point over the include",
+			c.ConfigData.End_point, c.ConfigData.Register_jobs, c.GetProfile())
+	}
+	if c.ConfigData.Profiles != nil {
+		t.Fatalf("profiles %v are kept after the apply", c.ConfigData.Profiles)
+	}
+
+	// Without profile the include is kept
+	t.Setenv(CONFIG_PROFILE_ENV, "")
+	c = includeTestSupport(t, files)
+	if err := c.LoadConfigYaml(); err != nil {
+		t.Fatal(err)
+	}
+	if c.ConfigData.End_point != "http://include" || c.GetProfile() != "" {
+		t.Fatalf("end_point %q profile %q, want the include without profile", c.ConfigData.End_point, c.GetProfile())
+	}
+
+	for profile, want := range map[string]string{
+		"staging": `profiles: profile "staging" is not defined`,
+		"broken":  "profiles.broken: include and profiles can not be set in a profile",
+	} {
+		t.Setenv(CONFIG_PROFILE_ENV, profile)
+		c = includeTestSupport(t, files)
+		if err := c.LoadConfigYaml(); err == nil || err.Error() != want {
+			t.Fatalf("profile %s: LoadConfigYaml() = %v, want %q", profile, err, want)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package support
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigError is one problem of the config with the path of the field,
+// for example "broker_connection.port: expected number, got string".
+type ConfigError struct {
+	Path string
+	Msg  string
+}
+
+func (c ConfigError) Error() string {
+	if c.Path == "" {
+		return c.Msg
+	}
+	return c.Path + ": " + c.Msg
+}
+
+// ConfigErrors collects every problem of the config.
+type ConfigErrors []ConfigError
+
+func (c ConfigErrors) Error() string {
+	lines := []string{}
+	for _, v := range c {
+		lines = append(lines, v.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (c *ConfigErrors) add(path string, format string, args ...any) {
+	*c = append(*c, ConfigError{Path: path, Msg: fmt.Sprintf(format, args...)})
+}
+
+// AsConfigErrors returns the config errors inside the error, a yaml type error
+// becomes one config error per line.
+func AsConfigErrors(err error) ConfigErrors {
+	var configErrors ConfigErrors
+	if errors.As(err, &configErrors) {
+		return configErrors
+	}
+	var typeError *yaml.TypeError
+	if errors.As(err, &typeError) {
+		for _, v := range typeError.Errors {
+			configErrors = append(configErrors, ConfigError{Msg: v})
+		}
+		return configErrors
+	}
+	return ConfigErrors{{Msg: err.Error()}}
+}
+
+// Kind of a broker connection field
+const (
+	CONFIG_KIND_STRING = "string"
+	CONFIG_KIND_NUMBER = "number"
+	CONFIG_KIND_BOOL   = "bool"
+	// A list or a comma separated string
+	CONFIG_KIND_LIST = "list"
+)
+
+type ConfigField struct {
+	Name     string
+	Kind     string
+	Required bool
+}
+
+// configKindOf returns the kind of the decoded yaml or json value.
+func configKindOf(value any) string {
+	switch value.(type) {
+	case string:
+		return CONFIG_KIND_STRING
+	case int, int64, float64:
+		return CONFIG_KIND_NUMBER
+	case bool:
+		return CONFIG_KIND_BOOL
+	case []interface{}, []string:
+		return CONFIG_KIND_LIST
+	case map[string]interface{}:
+		return "map"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// configKindMatch returns true when the value can be read as the kind.
+func configKindMatch(kind string, value any) bool {
+	valueKind := configKindOf(value)
+	switch kind {
+	case CONFIG_KIND_BOOL:
+		// secure can be true, 1 or "true" like the decoder accepts
+		return valueKind == CONFIG_KIND_BOOL || valueKind == CONFIG_KIND_NUMBER || valueKind == CONFIG_KIND_STRING
+	case CONFIG_KIND_LIST:
+		return valueKind == CONFIG_KIND_LIST || valueKind == CONFIG_KIND_STRING
+	case CONFIG_KIND_NUMBER:
+		// port: "${PORT}" is a string after the environment value is put in,
+		// a secret:// value is only a string when the config is checked without fetching it
+		if text, ok := value.(string); ok {
+			_, err := strconv.Atoi(text)
+			return err == nil || IsSecretRef(text)
+		}
+	}
+	return valueKind == kind
+}
+
+var brokerCommonFields = []ConfigField{
+	{Name: "key", Kind: CONFIG_KIND_STRING, Required: true},
+	{Name: "name", Kind: CONFIG_KIND_STRING, Required: true},
+	{Name: "type", Kind: CONFIG_KIND_STRING, Required: true},
+}
+
+var brokerTlsFields = []ConfigField{
+	{Name: "secure", Kind: CONFIG_KIND_BOOL},
+	{Name: "ca_file", Kind: CONFIG_KIND_STRING},
+	{Name: "cert_file", Kind: CONFIG_KIND_STRING},
+	{Name: "key_file", Kind: CONFIG_KIND_STRING},
+}
+
+// ValidateBrokerConnection checks one broker connection map, path is the path of the map in the config.
+func ValidateBrokerConnection(path string, v map[string]interface{}) ConfigErrors {
+	errs := ConfigErrors{}
+	checkFields := func(fields []ConfigField) {
+		for _, field := range fields {
+			value, ok := v[field.Name]
+			if !ok || value == nil {
+				if field.Required {
+					errs.add(path+"."+field.Name, "is required")
+				}
+				continue
+			}
+			if !configKindMatch(field.Kind, value) {
+				errs.add(path+"."+field.Name, "expected %s, got %s", field.Kind, configKindOf(value))
+			}
+		}
+	}
+	checkFields(brokerCommonFields)
+	brokerType, _ := v["type"].(string)
+	if brokerType == "" {
+		return errs
+	}
+	registration, ok := GetBrokerTypeRegistration(brokerType)
+	if !ok {
+		errs.add(path+".type", "unsupported broker type %q, expected one of %s", brokerType, strings.Join(GetBrokerTypes(), ", "))
+		return errs
+	}
+	checkFields(registration.Fields)
+	checkFields(brokerTlsFields)
+	validateBrokerRules(path, v, &errs)
+	if registration.Validate != nil {
+		registration.Validate(path, v, &errs)
+	}
+	return errs
+}
+
+// validateBrokerRules checks the TLS rule shared by every broker type,
+// the rule of the type itself is the Validate of its registration.
+func validateBrokerRules(path string, v map[string]interface{}, errs *ConfigErrors) {
+	if configHas(v, "cert_file") != configHas(v, "key_file") {
+		errs.add(path+".key_file", "cert_file and key_file must be set together")
+	}
+}
+
+// configHasOne returns true when one of the field is set.
+func configHasOne(v map[string]interface{}, names ...string) bool {
+	for _, name := range names {
+		if configHas(v, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func validateBrokerEndPoint(path string, v map[string]interface{}, data ConfigData, errs *ConfigErrors) {
+	switch v["type"] {
+	case "http", "websocket":
+		if endPoint, _ := v["end_point"].(string); endPoint == "" && data.End_point == "" {
+			errs.add(path+".end_point", "is required without the Job Manager end_point")
+		}
+	}
+}
+
+func isTrue(value any) bool {
+	switch val := value.(type) {
+	case bool:
+		return val
+	case int:
+		return val != 0
+	case float64:
+		return val != 0
+	case string:
+		return val == "true" || val == "1"
+	}
+	return false
+}
+
+func validateUrl(path string, value string, errs *ConfigErrors) {
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		errs.add(path, "expected http or https URL, got %q", value)
+	}
+}
+
+// ValidateConfigData checks the whole config, the local YAML merged with the server data,
+// and returns every problem found.
+func ValidateConfigData(data ConfigData) ConfigErrors {
+	errs := ConfigErrors{}
+
+	if data.End_point != "" {
+		validateUrl("end_point", data.End_point, &errs)
+		if data.Credential.Secret_key == "" {
+			errs.add("credential.secret_key", "is required when end_point is set")
+		}
+	}
+	if data.Credential.Project_id == "" {
+		errs.add("credential.project_id", "is required")
+	}
+
+	// Broker connection
+	keys := map[string]bool{}
+	if data.Broker_connection != nil {
+		errs = append(errs, ValidateBrokerConnection("broker_connection", data.Broker_connection)...)
+		if key, ok := data.Broker_connection["key"].(string); ok {
+			keys[key] = true
+		}
+	}
+	listKeys := map[string]bool{}
+	for i, v := range data.Broker_connections {
+		path := fmt.Sprintf("broker_connections[%d]", i)
+		errs = append(errs, ValidateBrokerConnection(path, v)...)
+		if key, ok := v["key"].(string); ok {
+			if listKeys[key] {
+				errs.add(path+".key", "duplicate key %q", key)
+			} else if keys[key] {
+				errs.add(path+".key", "duplicate key %q, already used by broker_connection", key)
+			}
+			listKeys[key] = true
+			keys[key] = true
+		}
+	}
+	// Without end_point there is no broker connection from the server
+	if data.Broker_connection == nil && len(data.Broker_connections) == 0 && data.End_point == "" {
+		errs.add("broker_connection", "is required without end_point")
+	}
+	// The http and websocket broker default to the Job Manager end_point
+	validateBrokerEndPoint("broker_connection", data.Broker_connection, data, &errs)
+	for i, v := range data.Broker_connections {
+		validateBrokerEndPoint(fmt.Sprintf("broker_connections[%d]", i), v, data, &errs)
+	}
+	if data.Telemetry_connection != "" && len(keys) > 0 && !keys[data.Telemetry_connection] {
+		errs.add("telemetry_connection", "unknown broker connection key %q", data.Telemetry_connection)
+	}
+
+	for i, job := range data.Jobs {
+		path := fmt.Sprintf("jobs[%d]", i)
+		if job.Event == "" {
+			errs.add(path+".event", "is required")
+		}
+		if job.Cmd == "" {
+			errs.add(path+".cmd", "is required")
+		}
+		if job.Connection != "" && len(keys) > 0 && !keys[job.Connection] {
+			errs.add(path+".connection", "unknown broker connection key %q", job.Connection)
+		}
+	}
+
+	execKeys := map[string]bool{}
+	for i, execItem := range data.Execs {
+		path := fmt.Sprintf("execs[%d]", i)
+		if execItem.Name == "" {
+			errs.add(path+".name", "is required")
+		}
+		if execItem.Cmd == "" {
+			errs.add(path+".cmd", "is required")
+		}
+		if execItem.Attempt < 0 {
+			errs.add(path+".attempt", "must be 0 or more")
+		}
+		if execItem.Key != "" {
+			if execKeys[execItem.Key] {
+				errs.add(path+".key", "duplicate key %q", execItem.Key)
+			}
+			execKeys[execItem.Key] = true
+		}
+	}
+
+	if data.Config_cache.Refresh_second < 0 {
+		errs.add("config_cache.refresh_second", "must be 0 or more")
+	}
+	if data.Update.Health_second < 0 {
+		errs.add("update.health_second", "must be 0 or more")
+	}
+	if data.Job_env.Token_ttl_second < 0 {
+		errs.add("job_env.token_ttl_second", "must be 0 or more")
+	}
+	for i, name := range data.Job_env.Allow {
+		if name == "" || name == "*" {
+			errs.add(fmt.Sprintf("job_env.allow[%d]", i), "expected a variable name or a prefix like AWS_*, got %q", name)
+		}
+	}
+
+	if data.Outbox.Max_size < 0 {
+		errs.add("outbox.max_size", "must be 0 or more")
+	}
+	if data.Outbox.Max_telemetry_size < 0 {
+		errs.add("outbox.max_telemetry_size", "must be 0 or more")
+	}
+	return errs
+}
@@ -0,0 +1,122 @@
+package support_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	support "job_item/support"
+)
+
+// validConfigData is a config without problem, every case breaks one part of it.
+func validConfigData() support.ConfigData {
+	configData := support.ConfigData{}
+	configData.Credential.Project_id = "project"
+	configData.Broker_connection = map[string]interface{}{
+		"key": "main", "name": "main", "type": "nats", "auth_type": "none", "host": "localhost", "port": 4222,
+	}
+	configData.Jobs = []support.ConfigJob{{Event: "backup", Cmd: "backup.sh"}}
+	return configData
+}
+
+func TestValidateConfigData(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(configData *support.ConfigData)
+		want   []string
+	}{
+		{
+			name:   "valid",
+			change: func(configData *support.ConfigData) {},
+			want:   []string{},
+		},
+		{
+			name: "field kind",
+			change: func(configData *support.ConfigData) {
+				configData.Broker_connection["port"] = "nats-port"
+			},
+			want: []string{"broker_connection.port: expected number, got string"},
+		},
+		{
+			name: "number from the environment",
+			change: func(configData *support.ConfigData) {
+				configData.Broker_connection["port"] = "4222"
+			},
+			want: []string{},
+		},
+		{
+			name: "required field of the type",
+			change: func(configData *support.ConfigData) {
+				delete(configData.Broker_connection, "host")
+			},
+			want: []string{"broker_connection.host: host and port or servers is required"},
+		},
+		{
+			name: "unknown broker type",
+			change: func(configData *support.ConfigData) {
+				configData.Broker_connection["type"] = "zeromq"
+			},
+			want: []string{`broker_connection.type: unsupported broker type "zeromq", expected one of ` + strings.Join(support.GetBrokerTypes(), ", ")},
+		},
+		{
+			name: "every problem in one run",
+			change: func(configData *support.ConfigData) {
+				configData.Credential.Project_id = ""
+				configData.Broker_connections = []map[string]interface{}{
+					{"key": "main", "name": "other", "type": "redis", "host": "localhost", "port": true, "password": "", "db": 0},
+				}
+				configData.Jobs = append(configData.Jobs, support.ConfigJob{Event: "report", Connection: "missing"})
+				configData.Execs = []support.ExecConfig{{Name: "web", Cmd: "web.sh", Key: "web"}, {Cmd: "cron.sh", Key: "web", Attempt: -1}}
+				configData.Outbox.Max_size = -1
+			},
+			want: []string{
+				"credential.project_id: is required",
+				"broker_connections[0].port: expected number, got bool",
+				`broker_connections[0].key: duplicate key "main", already used by broker_connection`,
+				"jobs[1].cmd: is required",
+				`jobs[1].connection: unknown broker connection key "missing"`,
+				"execs[1].name: is required",
+				"execs[1].attempt: must be 0 or more",
+				`execs[1].key: duplicate key "web"`,
+				"outbox.max_size: must be 0 or more",
+			},
+		},
+		{
+			name: "end_point",
+			change: func(configData *support.ConfigData) {
+				configData.End_point = "ftp://manager"
+			},
+			want: []string{
+				`end_point: expected http or https URL, got "ftp://manager"`,
+				"credential.secret_key: is required when end_point is set",
+			},
+		},
+		{
+			name: "http broker without end_point",
+			change: func(configData *support.ConfigData) {
+				configData.Broker_connection = map[string]interface{}{"key": "main", "name": "main", "type": "http"}
+			},
+			want: []string{"broker_connection.end_point: is required without the Job Manager end_point"},
+		},
+		{
+			name: "no broker without end_point",
+			change: func(configData *support.ConfigData) {
+				configData.Broker_connection = nil
+			},
+			want: []string{"broker_connection: is required without end_point"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configData := validConfigData()
+			tt.change(&configData)
+			got := []string{}
+			for _, v := range support.ValidateConfigData(configData) {
+				got = append(got, v.Error())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ValidateConfigData() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package support
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"job_item/src/helper"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Wait the end of the burst of event, an editor saves with several write or a rename
+const CONFIG_WATCH_DEBOUNCE = 500 * time.Millisecond
+
+// ConfigWatcherConstruct watches the directory of every file, not the file itself,
+// so the file replaced by a rename (atomic save of the editor) is still seen.
+// A file can be a glob pattern, a directory that does not exist or is a glob itself is skipped.
+func ConfigWatcherConstruct(files ...string) (*ConfigWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	gg := &ConfigWatcher{
+		watcher:  watcher,
+		patterns: []string{},
+		debounce: CONFIG_WATCH_DEBOUNCE,
+	}
+	dirs := map[string]bool{}
+	for _, file := range files {
+		absFile, err := filepath.Abs(file)
+		if err != nil {
+			watcher.Close()
+			return nil, err
+		}
+		gg.patterns = append(gg.patterns, absFile)
+		dir := filepath.Dir(absFile)
+		if dirs[dir] || hasGlobMeta(dir) {
+			continue
+		}
+		dirs[dir] = true
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+	return gg, nil
+}
+
+type ConfigWatcher struct {
+	watcher  *fsnotify.Watcher
+	patterns []string
+	debounce time.Duration
+}
+
+// isWatched returns true when the file is one of the watched file or matches a watched glob.
+func (c *ConfigWatcher) isWatched(file string) bool {
+	for _, pattern := range c.patterns {
+		if ok, _ := filepath.Match(pattern, file); ok || pattern == file {
+			return true
+		}
+	}
+	return false
+}
+
+// WaitChange blocks until a watched file changed and no other event came for the debounce time.
+// The event of the other file of the directory and the chmod only event are ignored.
+func (c *ConfigWatcher) WaitChange() error {
+	var timer *time.Timer
+	var fire <-chan time.Time
+	for {
+		select {
+		case event, ok := <-c.watcher.Events:
+			if !ok {
+				return errors.New("config watcher is closed")
+			}
+			absName, err := filepath.Abs(event.Name)
+			if err != nil || !c.isWatched(absName) || event.Op == fsnotify.Chmod {
+				continue
+			}
+			Helper.PrintGroupName("Config file event: " + event.String())
+			if timer == nil {
+				timer = time.NewTimer(c.debounce)
+			} else {
+				timer.Reset(c.debounce)
+			}
+			fire = timer.C
+		case <-fire:
+			return nil
+		case err, ok := <-c.watcher.Errors:
+			if !ok {
+				return errors.New("config watcher is closed")
+			}
+			Helper.PrintErrName("Config watcher error: "+err.Error(), "ERR-45100903201")
+		}
+	}
+}
+
+// Close stops watching.
+func (c *ConfigWatcher) Close() error {
+	return c.watcher.Close()
+}
+
+// ValidateConfigFile checks the config file like `validate --local`, so a bad local edit
+// is caught before anything restarts. The running process is not touched: the working dir
+// is kept, the env_file is read into a copy of the environment and a secret:// value
+// is only checked, not fetched. The Job Manager is not asked.
+func ValidateConfigFile(path string) error {
+	c := ConfigYamlSupport{
+		Config_path: path,
+		config_dir:  filepath.Dir(path),
+	}
+	if err := c.LoadConfigYaml(); err != nil {
+		return AsConfigErrors(err)
+	}
+	env := helper.EnvironMap()
+	err := c.interpolateEnv(func(file string) error {
+		return helper.ReadEnvFile(env, file)
+	}, func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	})
+	if err != nil {
+		return err
+	}
+	errs := ConfigErrors{}
+	helper.WalkStrings(&c.ConfigData, func(path string, value string) string {
+		if IsSecretRef(value) {
+			if _, _, _, err := parseSecretRef(value); err != nil {
+				errs.add(path, "%s", err.Error())
+			}
+		}
+		return value
+	})
+	errs = append(errs, ValidateConfigData(c.ConfigData)...)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package support_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	support "job_item/support"
+)
+
+func writeConfigTestFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigTestFile(t, filepath.Join(dir, "config.yaml"), `
+env_file:
+  - .env
+include:
+  - jobs/*.yaml
+credential:
+  project_id: ${JOB_ITEM_TEST_PROJECT:?set it on .env}
+broker_connection:
+  key: main
+  name: main
+  type: nats
+  auth_type: none
+  host: localhost
+  port: secret://env/JOB_ITEM_TEST_PORT_NOT_SET
+`)
+	writeConfigTestFile(t, filepath.Join(dir, ".env"), "JOB_ITEM_TEST_PROJECT=project\n")
+	writeConfigTestFile(t, filepath.Join(dir, "jobs", "backup.yaml"), `
+jobs:
+  - event: backup
+    cmd: backup.sh
+`)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := support.ValidateConfigFile(filepath.Join(dir, "config.yaml")); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	// Nothing of the running process changed
+	if now, _ := os.Getwd(); now != wd {
+		t.Errorf("working dir changed to %s", now)
+	}
+	if _, ok := os.LookupEnv("JOB_ITEM_TEST_PROJECT"); ok {
+		t.Errorf("the env_file is loaded into the environment")
+	}
+
+	// The invalid include and the bad secret reference are reported
+	writeConfigTestFile(t, filepath.Join(dir, "jobs", "broken.yaml"), `
+jobs:
+  - cmd: broken.sh
+`)
+	writeConfigTestFile(t, filepath.Join(dir, "config.yaml"), `
+env_file:
+  - .env
+include:
+  - jobs/*.yaml
+credential:
+  project_id: ${JOB_ITEM_TEST_PROJECT}
+  secret_key: secret://nowhere/key
+broker_connection:
+  key: main
+  name: main
+  type: nats
+  auth_type: none
+  host: localhost
+`)
+	err = support.ValidateConfigFile(filepath.Join(dir, "config.yaml"))
+	var configErrors support.ConfigErrors
+	if !errors.As(err, &configErrors) {
+		t.Fatalf("validate error is %v, want ConfigErrors", err)
+	}
+	paths := []string{}
+	for _, v := range configErrors {
+		paths = append(paths, v.Path)
+	}
+	joined := strings.Join(paths, ",")
+	if !strings.Contains(joined, "credential.secret_key") || !strings.Contains(joined, "jobs[1]") {
+		t.Errorf("errors are on %s, want credential.secret_key and jobs[1]", joined)
+	}
+
+	// A missing env_file is reported like at startup
+	os.Remove(filepath.Join(dir, ".env"))
+	err = support.ValidateConfigFile(filepath.Join(dir, "config.yaml"))
+	if !errors.As(err, &configErrors) || configErrors[0].Path != "env_file[0]" {
+		t.Errorf("validate error is %v, want env_file[0]", err)
+	}
+}
@@ -14,16 +14,15 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"job_item/src/helper"
 	support_helper "job_item/support/helper"
 	"job_item/support/model"
-	"log"
 	"net/http"
 	"os"
 	"os/exec"
-	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strconv"
@@ -37,19 +36,27 @@ import (
 )
 
 type NatsBrokerConnection struct {
-	Name      string `yaml:"name"`
-	Key       string `yaml:"key"`
-	Type      string `yaml:"type"`
-	Host      string `yaml:"host"`
-	Port      int    `yaml:"port"`
-	Auth_type string `yaml:"auth_type"`
-	User      string `yaml:"user"`
-	Password  string `yaml:"password"`
-	Token     string `yaml:"token"`
-	Secure    bool   `yaml:"secure"`
-	CAFile    string `yaml:"ca_file"`
-	CertFile  string `yaml:"cert_file"`
-	KeyFile   string `yaml:"key_file"`
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+	Type string `yaml:"type"`
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	// Server list of the cluster, "host:port" or "nats://host:port"
+	Servers   []string `yaml:"servers"`
+	Auth_type string   `yaml:"auth_type"`
+	User      string   `yaml:"user"`
+	Password  string   `yaml:"password"`
+	Token     string   `yaml:"token"`
+	// auth_type nkey, the seed or the path of the seed file
+	Nkey_seed string `yaml:"nkey_seed"`
+	Nkey_file string `yaml:"nkey_file"`
+	// auth_type creds, the content or the path of the .creds file
+	Creds      string `yaml:"creds"`
+	Creds_file string `yaml:"creds_file"`
+	Secure     bool   `yaml:"secure"`
+	CAFile     string `yaml:"ca_file"`
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
 }
 
 func (c NatsBrokerConnection) GetConnection() any {
@@ -75,12 +82,28 @@ func (c AMQP_BrokerConnection) GetConnection() any {
 	return c
 }
 
+const (
+	REDIS_MODE_STANDALONE = "standalone"
+	REDIS_MODE_SENTINEL   = "sentinel"
+	REDIS_MODE_CLUSTER    = "cluster"
+)
+
 type RedisBrokerConnection struct {
-	Name     string `yaml:"name"`
-	Key      string `yaml:"key"`
-	Type     string `yaml:"type"`
-	Host     string `yaml:"host"`
-	Port     int    `yaml:"port"`
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+	Type string `yaml:"type"`
+	// standalone (default), sentinel or cluster
+	Mode string `yaml:"mode"`
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	// mode sentinel
+	Master_name       string   `yaml:"master_name"`
+	Sentinel_addrs    []string `yaml:"sentinel_addrs"`
+	Sentinel_password string   `yaml:"sentinel_password"`
+	// mode cluster, the seed node
+	Cluster_addrs []string `yaml:"cluster_addrs"`
+	// ACL user, empty is the default user
+	Username string `yaml:"username"`
 	Password string `yaml:"password"`
 	Db       int    `yaml:"db"`
 	Secure   bool   `yaml:"secure"`
@@ -93,6 +116,71 @@ func (c RedisBrokerConnection) GetConnection() any {
 	return c
 }
 
+type MqttBrokerConnection struct {
+	Name      string `yaml:"name"`
+	Key       string `yaml:"key"`
+	Type      string `yaml:"type"`
+	Host      string `yaml:"host"`
+	Port      int    `yaml:"port"`
+	User      string `yaml:"user"`
+	Password  string `yaml:"password"`
+	Client_id string `yaml:"client_id"`
+	Secure    bool   `yaml:"secure"`
+	CAFile    string `yaml:"ca_file"`
+	CertFile  string `yaml:"cert_file"`
+	KeyFile   string `yaml:"key_file"`
+}
+
+func (c MqttBrokerConnection) GetConnection() any {
+	return c
+}
+
+type KafkaBrokerConnection struct {
+	Name           string   `yaml:"name"`
+	Key            string   `yaml:"key"`
+	Type           string   `yaml:"type"`
+	Host           string   `yaml:"host"`
+	Port           int      `yaml:"port"`
+	Brokers        []string `yaml:"brokers"`
+	Sasl_mechanism string   `yaml:"sasl_mechanism"`
+	User           string   `yaml:"user"`
+	Password       string   `yaml:"password"`
+	Topic_prefix   string   `yaml:"topic_prefix"`
+	Events_topic   string   `yaml:"events_topic"`
+	Secure         bool     `yaml:"secure"`
+	CAFile         string   `yaml:"ca_file"`
+	CertFile       string   `yaml:"cert_file"`
+	KeyFile        string   `yaml:"key_file"`
+}
+
+func (c KafkaBrokerConnection) GetConnection() any {
+	return c
+}
+
+type HttpBrokerConnection struct {
+	Name         string `yaml:"name"`
+	Key          string `yaml:"key"`
+	Type         string `yaml:"type"`
+	End_point    string `yaml:"end_point"`
+	Poll_timeout int    `yaml:"poll_timeout"`
+}
+
+func (c HttpBrokerConnection) GetConnection() any {
+	return c
+}
+
+type WebSocketBrokerConnection struct {
+	Name        string `yaml:"name"`
+	Key         string `yaml:"key"`
+	Type        string `yaml:"type"`
+	End_point   string `yaml:"end_point"`
+	Ack_timeout int    `yaml:"ack_timeout"`
+}
+
+func (c WebSocketBrokerConnection) GetConnection() any {
+	return c
+}
+
 type BrokerConInterface interface {
 	GetConnection() any
 }
@@ -102,6 +190,16 @@ type ConfigJob struct {
 	Name  string `yaml:"name"`
 	Event string `yaml:"event"`
 	Cmd   string `yaml:"cmd"`
+	// Key of the broker connection the job listens on, empty mean the default connection
+	Connection string `yaml:"connection"`
+	// Environment of the job process, a secret:// value is resolved
+	Env map[string]string `yaml:"env" json:"env,omitempty"`
+	// Private to this node, subscribed without being registered on the Job Manager project.
+	// Triggered by /job/create or anything publishing on its topic
+	Local bool `yaml:"local" json:"local,omitempty"`
+	// Give the project secret key on JOB_ITEM_PROJECT_KEY, for a script the Job Manager does not
+	// accept JOB_ITEM_TASK_TOKEN from yet
+	Expose_project_key bool `yaml:"expose_project_key" json:"expose_project_key,omitempty"`
 	// Import
 	Pub_type string
 }
@@ -128,19 +226,48 @@ type ConfigData struct {
 	End_point string `yaml:"end_point"`
 	// Credential for the job item
 	Credential Credential `yaml:"credential"`
+	// Env file loaded before the ${VAR} of the config is replaced, relative to the config file
+	Env_file []string `yaml:"env_file" json:"env_file,omitempty"`
 	// Import
-	Uuid                    string
-	Broker_connection       map[string]interface{} `json:"broker_connection,omitempty"`
-	Project                 model.ProjectDataView
-	Jobs                    []ConfigJob
+	Uuid              string
+	Broker_connection map[string]interface{} `json:"broker_connection,omitempty"`
+	// Additional broker connections, each one registered by its key
+	Broker_connections []map[string]interface{} `yaml:"broker_connections" json:"broker_connections,omitempty"`
+	// Key of the broker connection used to publish the hardware telemetry, empty mean the default connection
+	Telemetry_connection string `yaml:"telemetry_connection" json:"telemetry_connection,omitempty"`
+	Project              model.ProjectDataView
+	Jobs                 []ConfigJob
+	// Register on the Job Manager project the event of the job that is not registered yet, the local job is skipped
+	Register_jobs           bool         `yaml:"register_jobs" json:"register_jobs,omitempty"`
 	Execs                   []ExecConfig `json:"execs,omitempty"` // Add execs property
 	Job_item_version_number int          `json:"job_item_version_number,omitempty"`
 	Job_item_version        string       `json:"job_item_version,omitempty"`
 	Job_item_link           string       `json:"job_item_link,omitempty"`
+	// SHA-256 hex of the binary of Job_item_link and its ed25519 signature in base64
+	Job_item_sha256    string `json:"job_item_sha256,omitempty"`
+	Job_item_signature string `json:"job_item_signature,omitempty"`
+	// Self-update from the Job Manager
+	Update UpdateConfig `yaml:"update" json:"update,omitempty"`
+	// Queue the publish while the broker is disconnected
+	Outbox OutboxConfig `yaml:"outbox" json:"outbox,omitempty"`
+	// Prefix and namespace of every topic
+	Topic TopicConfig `yaml:"topic" json:"topic,omitempty"`
+	// Encrypted copy of the last config from the Job Manager
+	Config_cache ConfigCacheConfig `yaml:"config_cache" json:"config_cache,omitempty"`
+	// Environment given to the job command
+	Job_env JobEnvConfig `yaml:"job_env" json:"job_env,omitempty"`
+	// Provider of the secret:// value, the child process gets the resolved value and does not need it
+	Secrets SecretsConfig `yaml:"secrets" json:"-"`
+	// Other config file merged into this one, a glob pattern relative to config.yaml
+	Include []string `yaml:"include" json:"-"`
+	// Named overlay of the config selected by --profile or JOB_ITEM_PROFILE
+	Profiles map[string]ConfigData `yaml:"profiles" json:"-"`
 }
 
 type ConfigYamlSupportConstructPropsType struct {
 	Config_path string
+	// Skip the request to the Job Manager, only the local config is loaded
+	Local_only bool
 }
 
 func ConfigYamlSupportContruct(props ConfigYamlSupportConstructPropsType) (*ConfigYamlSupport, error) {
@@ -152,7 +279,7 @@ func ConfigYamlSupportContruct(props ConfigYamlSupportConstructPropsType) (*Conf
 	stat, err := os.Stat(filepath.Dir(props.Config_path))
 	if err != nil {
 		gg.printGroupName("Error getting directory stat: " + err.Error())
-		panic(1)
+		return nil, err
 	}
 	if stat.IsDir() {
 		gg.printGroupName("Set working dir :: " + filepath.Dir(props.Config_path))
@@ -163,41 +290,114 @@ func ConfigYamlSupportContruct(props ConfigYamlSupportConstructPropsType) (*Conf
 	err = os.Chdir(filepath.Dir(props.Config_path))
 	if err != nil {
 		gg.printGroupName("Error chdir :: " + err.Error())
-		panic(1)
+		return nil, err
 	}
 
-	gg.LoadConfigYaml()
-	gg.useEnvToYamlValue()
-	if gg.ConfigData.End_point != "" {
-		err = gg.loadServerCOnfig()
+	err = gg.LoadConfigYaml()
+	if err != nil {
+		return nil, AsConfigErrors(err)
+	}
+	err = gg.useEnvToYamlValue()
+	if err != nil {
+		return nil, err
+	}
+	if configFile := os.Getenv(CONFIG_HANDOFF_ENV); configFile != "" {
+		// Child process, the main process already loaded and resolved the config
+		err = gg.readConfigHandoff(configFile)
 		if err != nil {
 			return nil, err
 		}
+		gg.printGroupName("Using the config handed off by the main process")
+	} else if gg.ConfigData.End_point != "" && !props.Local_only {
+		err = gg.loadServerCOnfig()
+		if err != nil {
+			// The Job Manager is down, start from the last config it gave
+			err = gg.useConfigCache(err)
+			if err != nil {
+				return nil, err
+			}
+		}
 	} else {
-		gg.printGroupName("WARNING: End point is not set, using local config only")
+		if props.Local_only {
+			gg.printGroupName("Local only, the config from the Job Manager is skipped")
+		} else {
+			gg.printGroupName("WARNING: End point is not set, using local config only")
+		}
 		// If not requesting from server, set Uuid to Project_id
 		gg.ConfigData.Uuid = gg.ConfigData.Credential.Project_id
+		gg.config_source = CONFIG_SOURCE_LOCAL
+	}
+
+	err = gg.resolveSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the local config merged with the server data before anything starts
+	if errs := ValidateConfigData(gg.ConfigData); len(errs) > 0 {
+		return nil, errs
 	}
 	return &gg, nil
 }
 
+// configRelative returns the path of a file relative to config.yaml.
+func (c *ConfigYamlSupport) configRelative(path string) string {
+	if c.config_dir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(c.config_dir, path)
+}
+
 func (c *ConfigYamlSupport) printGroupName(printText string) {
-	fmt.Println(Helper.Segment_app, " >> ", printText)
+	fmt.Println(Helper.Segment_app, " >> ", MaskSecrets(printText))
 }
 
 type ConfigYamlSupport struct {
 	ConfigData        ConfigData
 	child_process_app *string
 	Config_path       string
+	// Directory of the relative include and env_file, empty is the working dir set by the construct
+	config_dir string
+	// Path of the value resolved from a secret://, like "credential.secret_key"
+	secret_paths []string
+	// local, server or cache, and when the cache was saved
+	config_source string
+	cached_at     time.Time
+	// Every file merged by the include and the applied profile
+	include_files []string
+	profile       string
+	// Guards the swap of the config by a live update, the reader takes a snapshot with GetConfigData
+	mutex sync.RWMutex
+}
+
+// GetConfigData returns a snapshot of the running config.
+// A live update swaps the whole ConfigData and never changes it in place, so the snapshot stays consistent.
+func (c *ConfigYamlSupport) GetConfigData() ConfigData {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.ConfigData
+}
+
+// ApplyConfig swaps the running config for another loaded one, like the one returned by FetchServerConfig.
+func (c *ConfigYamlSupport) ApplyConfig(next *ConfigYamlSupport) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ConfigData = next.ConfigData
+	c.Config_path = next.Config_path
+	c.child_process_app = next.child_process_app
+	c.secret_paths = next.secret_paths
+	c.config_source = next.config_source
+	c.cached_at = next.cached_at
+	c.include_files = next.include_files
+	c.profile = next.profile
 }
 
-// LoadConfigYaml loads the configuration from a YAML file.
-// It panics if the file cannot be read or parsed.
-func (c *ConfigYamlSupport) LoadConfigYaml() {
+// LoadConfigYaml loads the configuration from a YAML file, then merges the include and the profile.
+// It returns an error if the file cannot be read or parsed.
+func (c *ConfigYamlSupport) LoadConfigYaml() error {
 	yamlFile, err := os.ReadFile(c.Config_path)
 	if err != nil {
-		fmt.Println("Problem open file config.yaml ", err)
-		panic(1)
+		return fmt.Errorf("problem open file %s: %w", c.Config_path, err)
 	}
 	c.ConfigData = ConfigData{}
 
@@ -206,8 +406,7 @@ func (c *ConfigYamlSupport) LoadConfigYaml() {
 	if identityId == "" {
 		idenityIdNew, err := support_helper.GenerateUUIDv7()
 		if err != nil {
-			fmt.Println("Failed to generate UUID:", err)
-			panic(1)
+			return fmt.Errorf("failed to generate UUID: %w", err)
 		}
 		identityId = idenityIdNew
 	}
@@ -215,25 +414,50 @@ func (c *ConfigYamlSupport) LoadConfigYaml() {
 
 	err = yaml.Unmarshal(yamlFile, &c.ConfigData)
 	if err != nil {
-		log.Fatalf("Unmarshal: %v", err)
+		return err
+	}
+	if err := c.loadIncludes(); err != nil {
+		return err
 	}
+	return c.applyProfile()
 }
 
-// useEnvToYamlValue updates the configuration values using environment variables.
-func (c *ConfigYamlSupport) useEnvToYamlValue() {
-	helper.Fromenv(&c.ConfigData)
+// useEnvToYamlValue loads the env_file then replaces every ${VAR} of the configuration.
+// It returns ConfigErrors for a missing env file or a ${VAR:?error} without value.
+func (c *ConfigYamlSupport) useEnvToYamlValue() error {
+	return c.interpolateEnv(func(file string) error {
+		return helper.LoadEnvFile(file)
+	}, os.LookupEnv)
 }
 
-func (c *ConfigYamlSupport) GetEnv() []string {
+// interpolateEnv loads every env_file with loadEnvFile then replaces every ${VAR} with the value of lookup.
+func (c *ConfigYamlSupport) interpolateEnv(loadEnvFile func(file string) error, lookup func(name string) (string, bool)) error {
+	errs := ConfigErrors{}
+	for i, envFile := range c.ConfigData.Env_file {
+		if err := loadEnvFile(c.configRelative(envFile)); err != nil {
+			errs.add(fmt.Sprintf("env_file[%d]", i), "%s", err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	err := helper.FromenvLookup(&c.ConfigData, lookup)
+	var envErrors helper.EnvErrors
+	if errors.As(err, &envErrors) {
+		for _, v := range envErrors {
+			errs.add(v.Path, "%s", v.Msg)
+		}
+		return errs
+	}
+	return err
+}
+
+// GetEnv returns the environment of child_process and child_execs_process,
+// configFile is the config written by writeConfigHandoff.
+func (c *ConfigYamlSupport) GetEnv(configFile string) []string {
 	baseURL := "http://localhost:"
 	baseURL += strconv.Itoa(Helper.Gin.Port)
 
-	configData, err := json.Marshal(c.ConfigData)
-	if err != nil {
-		c.printGroupName("Error marshalling ConfigData to JSON: " + err.Error())
-		panic(1)
-	}
-
 	// Prepare share-data env values for child process
 	shareBase := os.Getenv("JOB_ITEM_SHARE_DATA_BASE")
 	if shareBase == "" {
@@ -251,8 +475,9 @@ func (c *ConfigYamlSupport) GetEnv() []string {
 	return []string{
 		// For child and child exec processes
 		"JOB_ITEM_IDENTITY_ID=" + c.ConfigData.Identity_id,
+		CONFIG_PROFILE_ENV + "=" + c.profile,
 		"JOB_ITEM_BASE_URL=" + baseURL,
-		"JOB_ITEM_CONFIG_DATA=" + string(configData),
+		CONFIG_HANDOFF_ENV + "=" + configFile,
 		// Specific endpoints for child process to add/get share data
 		"JOB_ITEM_SHARE_DATA_HOST=" + shareHost,
 		"JOB_ITEM_SHARE_DATA_ADD=" + jobItemShareDataAdd,
@@ -274,24 +499,31 @@ func (c *ConfigYamlSupport) GetEnvForExecProcess() []string {
 	}
 }
 
-// loadServerCOnfig requests the configuration from the server and updates the ConfigData.
+// loadServerCOnfig requests the configuration from the server and updates the ConfigData,
+// then registers the missing job event and saves the cache.
 // It returns an error if the request fails or the response is invalid.
 func (c *ConfigYamlSupport) loadServerCOnfig() error {
-	var param = map[string]interface{}{}
-
-	if os.Getenv("JOB_ITEM_CONFIG_DATA") != "" {
-		// If JOB_ITEM_CONFIG_DATA is set, use it as the config data
-		configDataString := os.Getenv("JOB_ITEM_CONFIG_DATA")
-		var configData ConfigData
-		err := json.Unmarshal([]byte(configDataString), &configData)
-		if err != nil {
-			c.printGroupName("Error unmarshalling JOB_ITEM_CONFIG_DATA: " + err.Error())
-			panic(1)
-		}
-		c.ConfigData = configData
-		c.printGroupName("Using JOB_ITEM_CONFIG_DATA from environment variable")
-		return nil
+	serverData, err := c.requestServerConfig()
+	if err != nil {
+		return err
 	}
+	c.applyServerConfig(serverData)
+	c.config_source = CONFIG_SOURCE_SERVER
+	registered, err := c.registerMissingJobs()
+	if err != nil {
+		c.printGroupName("WARNING: the job event is not registered on the Job Manager :: " + err.Error())
+	}
+	// The cache starts with the registered event too
+	serverData.Project.Job_datas = append(serverData.Project.Job_datas, registered...)
+	if err := c.saveConfigCache(serverData); err != nil {
+		c.printGroupName("WARNING: the config cache is not saved :: " + err.Error())
+	}
+	return nil
+}
+
+// requestServerConfig requests the configuration from the server without applying it.
+func (c *ConfigYamlSupport) requestServerConfig() (ConfigData, error) {
+	var param = map[string]interface{}{}
 
 	param["project_id"] = c.ConfigData.Credential.Project_id
 	param["secret_key"] = c.ConfigData.Credential.Secret_key
@@ -320,7 +552,7 @@ func (c *ConfigYamlSupport) loadServerCOnfig() error {
 	request.Header.Set("Content-Type", "application/json")
 	if err != nil {
 		c.printGroupName("Failed to create HTTP request to " + endpoint + " :: " + err.Error())
-		return err
+		return ConfigData{}, err
 		// panic(1)
 	}
 
@@ -334,7 +566,7 @@ func (c *ConfigYamlSupport) loadServerCOnfig() error {
 		c.printGroupName("  - Invalid endpoint URL")
 		c.printGroupName("  - Firewall blocking the connection")
 		c.printGroupName("Original error: " + err.Error())
-		return err
+		return ConfigData{}, err
 	}
 	defer response.Body.Close()
 
@@ -342,7 +574,7 @@ func (c *ConfigYamlSupport) loadServerCOnfig() error {
 	if response.StatusCode != http.StatusOK {
 		c.printGroupName("ERROR: Server returned HTTP " + fmt.Sprint(response.StatusCode) + " status code")
 		c.printGroupName("Expected 200 OK but got " + response.Status)
-		return fmt.Errorf("server returned non-200 status: %d %s", response.StatusCode, response.Status)
+		return ConfigData{}, &ConfigServerStatusError{Status_code: response.StatusCode, Status: response.Status}
 	}
 
 	c.printGroupName("Successfully connected to server (HTTP " + fmt.Sprint(response.StatusCode) + ")")
@@ -353,110 +585,49 @@ func (c *ConfigYamlSupport) loadServerCOnfig() error {
 	if err != nil {
 		c.printGroupName("ERROR: Failed to parse server response JSON :: " + err.Error())
 		c.printGroupName("This could mean the server returned invalid JSON format")
-		panic(1)
+		return ConfigData{}, err
 	}
 
 	c.printGroupName("Configuration successfully received from server")
-	// configData := bodyData["return"].(ConfigData)
-	c.ConfigData.Broker_connection = bodyData.Return.Broker_connection
-	c.ConfigData.Job_item_version_number = bodyData.Return.Job_item_version_number
-	c.ConfigData.Job_item_link = bodyData.Return.Job_item_link
-	mergo.Merge(&c.ConfigData, bodyData.Return)
-	return nil
+	return bodyData.Return, nil
 }
 
-func (c *ConfigYamlSupport) DownloadNewApp(versionNumber int) error {
-
-	url := c.ConfigData.Job_item_link
-	outputPath := ""
-	os_type := runtime.GOOS
-	switch os_type {
-	case "windows":
-		outputPath = fmt.Sprint("job_item_", versionNumber, ".exe")
-	case "darwin":
-		outputPath = fmt.Sprint("job_item_", versionNumber)
-	case "linux":
-		outputPath = fmt.Sprint("job_item_", versionNumber)
-	}
-	c.child_process_app = &outputPath
-
-	c.replaceApp(outputPath)
-
-	// Check the file first is exist or not
-	_, err := os.Stat(outputPath)
-	if err == nil {
-		return nil
-	}
+// applyServerConfig merges the data of the Job Manager into the local config.
+func (c *ConfigYamlSupport) applyServerConfig(serverData ConfigData) {
+	c.ConfigData.Broker_connection = serverData.Broker_connection
+	c.ConfigData.Job_item_version_number = serverData.Job_item_version_number
+	c.ConfigData.Job_item_link = serverData.Job_item_link
+	c.ConfigData.Job_item_sha256 = serverData.Job_item_sha256
+	c.ConfigData.Job_item_signature = serverData.Job_item_signature
+	mergo.Merge(&c.ConfigData, serverData)
+}
 
-	out, err := os.Create(outputPath)
-	if err != nil {
-		return err
+// FetchServerConfig loads config.yaml and the Job Manager data again and returns the new config.
+// Only a fetch, the running config is not touched, no job event is registered and the cache is kept.
+func (c *ConfigYamlSupport) FetchServerConfig() (*ConfigYamlSupport, error) {
+	next := ConfigYamlSupport{
+		Config_path:       c.Config_path,
+		child_process_app: c.child_process_app,
 	}
-	defer out.Close()
-
-	// Send HTTP GET request
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
+	if err := next.LoadConfigYaml(); err != nil {
+		return nil, AsConfigErrors(err)
 	}
-	defer resp.Body.Close()
-
-	// Check response status code
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned non-200 status code: %d", resp.StatusCode)
+	if err := next.useEnvToYamlValue(); err != nil {
+		return nil, err
 	}
-
-	// Copy the response body to the output file
-	_, err = io.Copy(out, resp.Body)
+	serverData, err := next.requestServerConfig()
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	// Make the downloaded file executable
-	if os_type != "windows" {
-		err = makeExecutable(outputPath)
-		if err != nil {
-			return err
-		}
+	next.applyServerConfig(serverData)
+	next.config_source = CONFIG_SOURCE_SERVER
+	if err := next.resolveSecrets(); err != nil {
+		return nil, err
 	}
-
-	return nil
-}
-
-// replaceApp replaces the current application with a new version.
-// It handles signals to ensure a graceful replacement process.
-func (c *ConfigYamlSupport) replaceApp(app_new string) {
-	go func() {
-		defer func() {
-			var cmd *exec.Cmd
-			// os_type := runtime.GOOS
-			fmt.Println("Replace the current app to be new version")
-			executablePath, _ := os.Executable()
-			cmd = ReplaceApp(executablePath, app_new)
-			err := cmd.Run()
-			if err != nil {
-				fmt.Println(err)
-			}
-		}()
-		// Create a channel to receive signals
-		sigCh := make(chan os.Signal, 1)
-
-		// Notify the sigCh channel for SIGINT and SIGTERM signals
-		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-
-		// Block until a signal is received
-		sig := <-sigCh
-		Helper.PrintGroupName(fmt.Sprintf("Received signal: %v\n", sig))
-	}()
-}
-
-func makeExecutable(path string) error {
-	cmd := exec.Command("chmod", "+x", path)
-	err := cmd.Run()
-	if err != nil {
-		return err
+	if errs := ValidateConfigData(next.ConfigData); len(errs) > 0 {
+		return nil, errs
 	}
-	return nil
+	return &next, nil
 }
 
 // printOutput reads and prints the output from the provided reader.
@@ -520,7 +691,8 @@ func (c *ConfigYamlSupport) DownloadAndExtractCerts(endpoint, destDir, appID, ap
 		if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
 			return err
 		}
-		outFile, err := os.Create(outPath)
+		// The bundle can carry private key and NATS credential
+		outFile, err := os.OpenFile(outPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
 		if err != nil {
 			return err
 		}
@@ -539,170 +711,172 @@ func (c *ConfigYamlSupport) DownloadAndExtractCerts(endpoint, destDir, appID, ap
 	return nil
 }
 
-// GetTypeBrokerCon returns the appropriate broker connection type based on the provided configuration.
-func (c *ConfigYamlSupport) GetTypeBrokerCon(v map[string]interface{}) BrokerConInterface {
-	switch v["type"].(string) {
-	case "nats":
-		natsConf := NatsBrokerConnection{}
-		natsConf.Host = v["host"].(string)
-		natsConf.Key = v["key"].(string)
-		natsConf.Name = v["name"].(string)
-		switch port := v["port"].(type) {
-		case int:
-			natsConf.Port = port
-		case float64:
-			natsConf.Port = int(port)
-		default:
-			panic(fmt.Sprintf("unexpected type for port: %T", port))
-		}
-		natsConf.Type = v["type"].(string)
-		if v["auth_type"] != nil {
-			natsConf.Auth_type = v["auth_type"].(string)
-		} else {
-			fmt.Println("NATS ERR : You need define auth_type : [none | user_password | token], on job_manager")
-			panic(1)
-		}
-		if v["user"] != nil {
-			natsConf.User = v["user"].(string)
-		}
-		if v["password"] != nil {
-			natsConf.Password = v["password"].(string)
-		}
-		if v["token"] != nil {
-			natsConf.Token = v["token"].(string)
-		}
-		// Parse TLS fields
-		if v["secure"] != nil {
-			switch val := v["secure"].(type) {
-			case bool:
-				natsConf.Secure = val
-			case float64:
-				natsConf.Secure = val != 0
-			case string:
-				natsConf.Secure = val == "true" || val == "1"
-			}
-		}
-		if v["ca_file"] != nil {
-			natsConf.CAFile = v["ca_file"].(string)
-		}
-		if v["cert_file"] != nil {
-			natsConf.CertFile = v["cert_file"].(string)
+// GetTypeBrokerCon decodes the broker connection map into the typed connection of its type,
+// like NatsBrokerConnection, and downloads the TLS bundle it needs from the Job Manager.
+func (c *ConfigYamlSupport) GetTypeBrokerCon(v map[string]interface{}) (BrokerConInterface, error) {
+	conn, err := DecodeBrokerConnection("broker_connection", v)
+	if err != nil {
+		return nil, err
+	}
+	if c.ConfigData.End_point == "" {
+		return conn, nil
+	}
+	endpoint := fmt.Sprintf("%s/api/worker/config/tls/download", c.ConfigData.End_point)
+	if isTrue(v["secure"]) && configHas(v, "ca_file") {
+		certDir := filepath.Dir(configString(v, "ca_file"))
+		if err := c.DownloadAndExtractCerts(endpoint, certDir, c.ConfigData.Credential.Project_id, c.ConfigData.Credential.Secret_key); err != nil {
+			return nil, err
 		}
-		if v["key_file"] != nil {
-			natsConf.KeyFile = v["key_file"].(string)
+	}
+	registration, _ := GetBrokerTypeRegistration(v["type"].(string))
+	for _, name := range registration.BundleFiles {
+		bundleFile := configString(v, name)
+		if bundleFile == "" {
+			continue
 		}
-		if Helper.ConfigYaml.ConfigData.End_point != "" && natsConf.Secure && natsConf.CAFile != "" {
-			certDir := filepath.Dir(natsConf.CAFile)
-			endpoint := fmt.Sprintf("%s/api/worker/config/tls/download", c.ConfigData.End_point)
-			if err := c.DownloadAndExtractCerts(endpoint, certDir, c.ConfigData.Credential.Project_id, c.ConfigData.Credential.Secret_key); err != nil {
-				panic(err)
-			}
+		if _, err := os.Stat(bundleFile); err == nil {
+			continue
 		}
-		return natsConf
-	case "rabbitmq":
-		rabbitmqConf := AMQP_BrokerConnection{}
-		rabbitmqConf.Host = v["host"].(string)
-		rabbitmqConf.Key = v["key"].(string)
-		rabbitmqConf.Name = v["name"].(string)
-		switch port := v["port"].(type) {
-		case int:
-			rabbitmqConf.Port = port
-		case float64:
-			rabbitmqConf.Port = int(port)
-		default:
-			panic(fmt.Sprintf("unexpected type for port: %T", port))
+		if err := c.DownloadAndExtractCerts(endpoint, filepath.Dir(bundleFile), c.ConfigData.Credential.Project_id, c.ConfigData.Credential.Secret_key); err != nil {
+			return nil, err
 		}
-		rabbitmqConf.Type = v["type"].(string)
-		rabbitmqConf.User = v["user"].(string)
-		rabbitmqConf.Password = v["password"].(string)
-		if v["exchange"] != nil {
-			rabbitmqConf.Exchange = v["exchange"].(string)
+	}
+	return conn, nil
+}
+
+// OpenBrokerConnection decodes the broker connection config and opens it once.
+func (c *ConfigYamlSupport) OpenBrokerConnection(v map[string]interface{}) (BrokerConnectionInterface, error) {
+	brokerCon, err := c.GetTypeBrokerCon(v)
+	if err != nil {
+		return nil, err
+	}
+	return NewBrokerConnection(brokerCon)
+}
+
+// configStringList reads a config value that can be a list or a comma separated string.
+func configStringList(value any) []string {
+	list := []string{}
+	switch items := value.(type) {
+	case []interface{}:
+		for _, item := range items {
+			list = append(list, fmt.Sprint(item))
 		}
-		// Parse TLS/mTLS fields
-		if v["secure"] != nil {
-			switch val := v["secure"].(type) {
-			case bool:
-				rabbitmqConf.Secure = val
-			case float64:
-				rabbitmqConf.Secure = val != 0
-			case string:
-				rabbitmqConf.Secure = val == "true" || val == "1"
+	case []string:
+		list = append(list, items...)
+	case string:
+		for _, item := range strings.Split(items, ",") {
+			if strings.TrimSpace(item) != "" {
+				list = append(list, strings.TrimSpace(item))
 			}
 		}
-		if v["ca_file"] != nil {
-			rabbitmqConf.CAFile = v["ca_file"].(string)
-		}
-		if v["cert_file"] != nil {
-			rabbitmqConf.CertFile = v["cert_file"].(string)
-		}
-		if v["key_file"] != nil {
-			rabbitmqConf.KeyFile = v["key_file"].(string)
-		}
-		if Helper.ConfigYaml.ConfigData.End_point != "" && rabbitmqConf.Secure && rabbitmqConf.CAFile != "" {
-			certDir := filepath.Dir(rabbitmqConf.CAFile)
-			endpoint := fmt.Sprintf("%s/api/worker/config/tls/download", c.ConfigData.End_point)
-			if err := c.DownloadAndExtractCerts(endpoint, certDir, c.ConfigData.Credential.Project_id, c.ConfigData.Credential.Secret_key); err != nil {
-				panic(err)
-			}
+	}
+	return list
+}
+
+// GetBrokerConnectionKey returns the key of the broker connection configuration,
+// empty when it is missing or not a string. ValidateConfigData reports both.
+func GetBrokerConnectionKey(v map[string]interface{}) string {
+	key, _ := v["key"].(string)
+	return key
+}
+
+// GetBrokerConnections returns every broker connection configuration,
+// the default broker_connection first then the broker_connections list.
+// The connection with a key already listed is skipped, ValidateConfigData reports it.
+func (c *ConfigYamlSupport) GetBrokerConnections() []map[string]interface{} {
+	conns := []map[string]interface{}{}
+	keys := map[string]bool{}
+	add := func(v map[string]interface{}) {
+		if v == nil {
+			return
 		}
-		return rabbitmqConf
-	case "redis":
-		redisConf := RedisBrokerConnection{}
-		redisConf.Host = v["host"].(string)
-		redisConf.Key = v["key"].(string)
-		redisConf.Name = v["name"].(string)
-		switch port := v["port"].(type) {
-		case int:
-			redisConf.Port = port
-		case float64:
-			redisConf.Port = int(port)
-		default:
-			panic(fmt.Sprintf("unexpected type for port: %T", port))
+		key := GetBrokerConnectionKey(v)
+		if keys[key] {
+			return
 		}
-		redisConf.Type = v["type"].(string)
-		if v["password"] != nil {
-			redisConf.Password = v["password"].(string)
-		} else {
-			fmt.Println("REDIS ERR : You need define password")
-			panic(1)
+		keys[key] = true
+		conns = append(conns, v)
+	}
+	configData := c.GetConfigData()
+	add(configData.Broker_connection)
+	for _, v := range configData.Broker_connections {
+		add(v)
+	}
+	return conns
+}
+
+// MaskBrokerConnectionValue hides the credential of a broker connection field for printing.
+func MaskBrokerConnectionValue(key string, value any) any {
+	switch key {
+	case "password", "token", "nkey_seed", "creds", "sentinel_password":
+		if value == nil || value == "" {
+			return value
 		}
-		if v["db"] != nil {
-			redisConf.Db = int(v["db"].(float64))
-		} else {
-			fmt.Println("REDIS ERR : You need define db")
-			panic(1)
+		return "******"
+	}
+	if text, ok := value.(string); ok && IsSecretValue(text) {
+		return "******"
+	}
+	return value
+}
+
+// GetDefaultBrokerKey returns the key of the default broker connection.
+// Without broker_connection the first item of broker_connections is the default.
+func (c *ConfigYamlSupport) GetDefaultBrokerKey() string {
+	conns := c.GetBrokerConnections()
+	if len(conns) == 0 {
+		return ""
+	}
+	return GetBrokerConnectionKey(conns[0])
+}
+
+// GetJobConnectionKey returns the key of the broker connection the job listens on.
+func (c *ConfigYamlSupport) GetJobConnectionKey(job ConfigJob) string {
+	if job.Connection != "" {
+		return job.Connection
+	}
+	return c.GetDefaultBrokerKey()
+}
+
+// GetJobEvents returns the event the job subscribes to. With the Job Manager the event
+// must be registered on the project, as a job data or a nested job "<event>.<nested>", unless the job is local.
+func (c *ConfigYamlSupport) GetJobEvents(job ConfigJob) []string {
+	configData := c.GetConfigData()
+	if configData.End_point == "" || job.Local {
+		return []string{job.Event}
+	}
+	events := []string{}
+	for _, x := range configData.Project.Job_datas {
+		if x.Event == job.Event {
+			events = append(events, job.Event)
 		}
-		// Parse TLS/mTLS fields
-		if v["secure"] != nil {
-			switch val := v["secure"].(type) {
-			case bool:
-				redisConf.Secure = val
-			case float64:
-				redisConf.Secure = val != 0
-			case string:
-				redisConf.Secure = val == "true" || val == "1"
+		if x.Nested_jobs != nil {
+			for _, nested := range *x.Nested_jobs {
+				if fmt.Sprint(x.Event, ".", nested.Event) == job.Event {
+					events = append(events, job.Event)
+				}
 			}
 		}
-		if v["ca_file"] != nil {
-			redisConf.CAFile = v["ca_file"].(string)
-		}
-		if v["cert_file"] != nil {
-			redisConf.CertFile = v["cert_file"].(string)
-		}
-		if v["key_file"] != nil {
-			redisConf.KeyFile = v["key_file"].(string)
-		}
-		if Helper.ConfigYaml.ConfigData.End_point != "" && redisConf.Secure && redisConf.CAFile != "" {
-			certDir := filepath.Dir(redisConf.CAFile)
-			endpoint := fmt.Sprintf("%s/api/worker/config/tls/download", c.ConfigData.End_point)
-			if err := c.DownloadAndExtractCerts(endpoint, certDir, c.ConfigData.Credential.Project_id, c.ConfigData.Credential.Secret_key); err != nil {
-				panic(err)
-			}
+	}
+	return events
+}
+
+// GetEventConnectionKey returns the key of the broker connection of the job with the event.
+func (c *ConfigYamlSupport) GetEventConnectionKey(event string) string {
+	for _, v := range c.GetConfigData().Jobs {
+		if v.Event == event {
+			return c.GetJobConnectionKey(v)
 		}
-		return redisConf
 	}
+	return c.GetDefaultBrokerKey()
+}
 
-	return nil
+// GetTelemetryConnectionKey returns the key of the broker connection used for the hardware telemetry.
+func (c *ConfigYamlSupport) GetTelemetryConnectionKey() string {
+	if telemetryConnection := c.GetConfigData().Telemetry_connection; telemetryConnection != "" {
+		return telemetryConnection
+	}
+	return c.GetDefaultBrokerKey()
 }
 
 // GetObject returns the ConfigYamlSupport object.
@@ -710,24 +884,6 @@ func (c *ConfigYamlSupport) GetObject() any {
 	return c
 }
 
-// GetNatsBrokerCon returns the NATS broker connection from the provided interface.
-func (c ConfigYamlSupport) GetNatsBrokerCon(gg BrokerConInterface) NatsBrokerConnection {
-	kk := gg.GetConnection().(NatsBrokerConnection)
-	return kk
-}
-
-// GetRabbitMQBrokenCon returns the RabbitMQ broker connection from the provided interface.
-func (c ConfigYamlSupport) GetRabbitMQBrokenCon(gg BrokerConInterface) AMQP_BrokerConnection {
-	kk := gg.GetConnection().(AMQP_BrokerConnection)
-	return kk
-}
-
-// GetRedisBrokerCon returns the Redis broker connection from the provided interface.
-func (c ConfigYamlSupport) GetRedisBrokerCon(gg BrokerConInterface) RedisBrokerConnection {
-	kk := gg.GetConnection().(RedisBrokerConnection)
-	return kk
-}
-
 // RunChildProcess starts a child process with the current configuration.
 // It returns the command object and any error encountered.
 func (c *ConfigYamlSupport) RunChildProcess() (*exec.Cmd, error) {
@@ -737,7 +893,18 @@ func (c *ConfigYamlSupport) RunChildProcess() (*exec.Cmd, error) {
 	}
 
 	// Set environment variables for the command
-	cmd.Env = append(os.Environ(), c.GetEnv()...)
+	configFile, err := c.writeConfigHandoff()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Env = append(os.Environ(), c.GetEnv(configFile)...)
+	// The child serves its /health there, the update health check asks it
+	healthAddr, err := reserveHealthAddr()
+	if err != nil {
+		os.Remove(configFile)
+		return nil, err
+	}
+	cmd.Env = append(cmd.Env, CHILD_HEALTH_ENV+"="+healthAddr)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -752,11 +919,13 @@ func (c *ConfigYamlSupport) RunChildProcess() (*exec.Cmd, error) {
 
 	err = cmd.Start()
 	if err != nil {
+		os.Remove(configFile)
 		return nil, err
 	}
 
 	go printOutput(stdout)
 	go printOutput(stderr)
+	childHealthAddrs.Store(cmd, healthAddr)
 
 	// Wait for the command to finish with a timeout
 	err = waitWithTimeout(cmd, 2*time.Second)
@@ -776,7 +945,11 @@ func (c *ConfigYamlSupport) RunChildExecsProcess() (*exec.Cmd, error) {
 		return nil, fmt.Errorf("failed to create command for child exec process: %w", err)
 	}
 	// Set environment variables for the command
-	cmd.Env = append(os.Environ(), c.GetEnv()...)
+	configFile, err := c.writeConfigHandoff()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Env = append(os.Environ(), c.GetEnv(configFile)...)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -791,6 +964,7 @@ func (c *ConfigYamlSupport) RunChildExecsProcess() (*exec.Cmd, error) {
 
 	err = cmd.Start()
 	if err != nil {
+		os.Remove(configFile)
 		return nil, err
 	}
 
@@ -810,119 +984,186 @@ func (c *ConfigYamlSupport) RunChildExecsProcess() (*exec.Cmd, error) {
 // RunExecsProcess runs all exec commands defined in the configuration.
 // It captures their output, retries on failure, and handles timeouts.
 func (c *ConfigYamlSupport) RunExecsProcess(cmd *[]*exec.Cmd) {
+	for _, execConfig := range c.ConfigData.Execs {
+		c.StartExec(execConfig, cmd)
+	}
+}
+
+// execHandle is the running process of one exec, so the live config update can stop it.
+type execHandle struct {
+	mutex   sync.Mutex
+	cmd     *exec.Cmd
+	stopped bool
+}
+
+func (h *execHandle) setCmd(cmd *exec.Cmd) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.cmd = cmd
+}
+
+func (h *execHandle) isStopped() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.stopped
+}
+
+// Running exec by its key
+var execHandles = map[string]*execHandle{}
+var execHandlesMutex sync.Mutex
+
+// StopExec stops the exec without restarting it and without the cascade exit.
+func (c *ConfigYamlSupport) StopExec(execConfig ExecConfig) {
+	execHandlesMutex.Lock()
+	handle, ok := execHandles[GetExecKey(execConfig)]
+	delete(execHandles, GetExecKey(execConfig))
+	execHandlesMutex.Unlock()
+	if !ok {
+		return
+	}
+	handle.mutex.Lock()
+	handle.stopped = true
+	cmdItem := handle.cmd
+	handle.mutex.Unlock()
+	Helper.PrintGroupName(fmt.Sprintf("Stopping exec: %s\n", execConfig.Name))
+	c.CloseAllGroupProcesses([]*exec.Cmd{cmdItem})
+}
+
+// StartExec runs one exec, it is restarted on failure up to its attempt.
+func (c *ConfigYamlSupport) StartExec(execConfig ExecConfig, cmd *[]*exec.Cmd) {
 	retryCountFirstStart := 5     // Number of retry attempts
 	retryDelay := 2 * time.Second // Delay between retries
 
-	for _, execConfig := range c.ConfigData.Execs {
-		Helper.PrintGroupName(fmt.Sprintf("Running exec: %s, Key: %s, Cmd: %s\n", execConfig.Name, execConfig.Key, execConfig.Cmd))
+	handle := &execHandle{}
+	execHandlesMutex.Lock()
+	execHandles[GetExecKey(execConfig)] = handle
+	execHandlesMutex.Unlock()
 
-		// Resolve working directory
-		workingDir := execConfig.Working_dir
-		if !filepath.IsAbs(workingDir) {
-			workingDir = filepath.Join(filepath.Dir(c.Config_path), workingDir)
-		}
+	Helper.PrintGroupName(fmt.Sprintf("Running exec: %s, Key: %s, Cmd: %s\n", execConfig.Name, execConfig.Key, execConfig.Cmd))
 
-		for attempt := 1; attempt <= retryCountFirstStart; attempt++ {
+	// Resolve working directory
+	workingDir := execConfig.Working_dir
+	if !filepath.IsAbs(workingDir) {
+		workingDir = filepath.Join(filepath.Dir(c.Config_path), workingDir)
+	}
 
-			totalRestartAttempts := execConfig.Attempt
-			if totalRestartAttempts <= 0 {
-				totalRestartAttempts = 3 // Default to 3 attempts if not specified
-			}
+	for attempt := 1; attempt <= retryCountFirstStart; attempt++ {
 
-			// Create the command
-			cmdItem := NewMonitoredCmd(c.createForExecCommand(execConfig, workingDir))
-			*cmd = append(*cmd, cmdItem.Cmd)
+		totalRestartAttempts := execConfig.Attempt
+		if totalRestartAttempts <= 0 {
+			totalRestartAttempts = 3 // Default to 3 attempts if not specified
+		}
 
-			// Use the helper function to set up pipes
-			stdout, stderr, err := setupCommandPipes(cmdItem.Cmd, execConfig.Name)
-			if err != nil {
-				*cmd = (*cmd)[:len(*cmd)-1] // Remove the last command if there's an error
-				continue
-			}
+		// Create the command
+		cmdItem := NewMonitoredCmd(c.createForExecCommand(execConfig, workingDir))
+		*cmd = append(*cmd, cmdItem.Cmd)
 
-			// Capture and log stdout and stderr
-			go printOutputWithIdentity(stdout, execConfig.Name)
-			go printOutputWithIdentity(stderr, execConfig.Name)
+		// Use the helper function to set up pipes
+		stdout, stderr, err := setupCommandPipes(cmdItem.Cmd, execConfig.Name)
+		if err != nil {
+			*cmd = (*cmd)[:len(*cmd)-1] // Remove the last command if there's an error
+			continue
+		}
 
-			// Start the command
-			err = cmdItem.Cmd.Start()
-			if err != nil {
-				Helper.PrintErrName(fmt.Sprintf("Error starting command for %s (Attempt %d/%d): %v\n", execConfig.Name, attempt, retryCountFirstStart, err), "ERR-CMD-START")
-				*cmd = (*cmd)[:len(*cmd)-1] // Remove the last command if there's an error
-				if attempt == retryCountFirstStart {
-					Helper.PrintErrName(fmt.Sprintf("Failed to execute command for %s after %d attempts\n", execConfig.Name, retryCountFirstStart), "ERR-CMD-FAIL")
-				}
-				if attempt < retryCountFirstStart {
-					Helper.PrintGroupName(fmt.Sprintf("Retrying command for %s after %v...\n", execConfig.Name, retryDelay))
-					time.Sleep(retryDelay) // Add delay before retrying
-				}
-				continue
+		// Capture and log stdout and stderr
+		go printOutputWithIdentity(stdout, execConfig.Name)
+		go printOutputWithIdentity(stderr, execConfig.Name)
+
+		// Start the command
+		err = cmdItem.Cmd.Start()
+		if err != nil {
+			Helper.PrintErrName(fmt.Sprintf("Error starting command for %s (Attempt %d/%d): %v\n", execConfig.Name, attempt, retryCountFirstStart, err), "ERR-CMD-START")
+			*cmd = (*cmd)[:len(*cmd)-1] // Remove the last command if there's an error
+			if attempt == retryCountFirstStart {
+				Helper.PrintErrName(fmt.Sprintf("Failed to execute command for %s after %d attempts\n", execConfig.Name, retryCountFirstStart), "ERR-CMD-FAIL")
 			}
+			if attempt < retryCountFirstStart {
+				Helper.PrintGroupName(fmt.Sprintf("Retrying command for %s after %v...\n", execConfig.Name, retryDelay))
+				time.Sleep(retryDelay) // Add delay before retrying
+			}
+			continue
+		}
 
-			// Log the running process
-			Helper.PrintGroupName(fmt.Sprintf("Command '%s' is running with PID: %d\n", execConfig.Name, cmdItem.Cmd.Process.Pid))
+		handle.setCmd(cmdItem.Cmd)
 
-			// Wait for the command and handle restarts
-			go func(cmdItem *MonitoredCmd, execName string) {
-				for restartAttempt := 0; restartAttempt <= totalRestartAttempts; restartAttempt++ {
-					err := cmdItem.Wait()
-					if err != nil {
-						for in, c := range *cmd {
-							if c.Process.Pid == cmdItem.Cmd.Process.Pid {
-								*cmd = (*cmd)[:in] // Remove the last command if there's an error
-								break
-							}
-						}
+		// Log the running process
+		Helper.PrintGroupName(fmt.Sprintf("Command '%s' is running with PID: %d\n", execConfig.Name, cmdItem.Cmd.Process.Pid))
 
-						if restartAttempt == totalRestartAttempts {
-							Helper.PrintErrName(fmt.Sprintf("Command '%s' failed after %d attempts. Giving up.\n", execName, totalRestartAttempts), "ERR-2344233432")
-							c.ShutdownMainProcess()
+		// Wait for the command and handle restarts
+		go func(cmdItem *MonitoredCmd, execName string) {
+			for restartAttempt := 0; restartAttempt <= totalRestartAttempts; restartAttempt++ {
+				err := cmdItem.Wait()
+				if handle.isStopped() {
+					// Removed or changed by the live config update
+					for in, c := range *cmd {
+						if c.Process.Pid == cmdItem.Cmd.Process.Pid {
+							*cmd = append((*cmd)[:in], (*cmd)[in+1:]...)
 							break
 						}
-
-						Helper.PrintErrName(fmt.Sprintf("Command '%s' finished with error: %v. Restarting... (Attempt %d/%d)\n", execName, err, restartAttempt+1, totalRestartAttempts), "ERR-234233432")
-
-						if execConfig.Cascade_exit {
-							Helper.PrintGroupName(fmt.Sprintf("Cascade exit enabled for command '%s'. Exiting process.\n", execName))
-							c.ShutdownMainProcess()
+					}
+					Helper.PrintGroupName(fmt.Sprintf("Command '%s' is stopped.\n", execName))
+					break
+				}
+				if err != nil {
+					for in, c := range *cmd {
+						if c.Process.Pid == cmdItem.Cmd.Process.Pid {
+							*cmd = (*cmd)[:in] // Remove the last command if there's an error
 							break
 						}
+					}
 
-						time.Sleep(2 * time.Second) // Wait before restarting
+					if restartAttempt == totalRestartAttempts {
+						Helper.PrintErrName(fmt.Sprintf("Command '%s' failed after %d attempts. Giving up.\n", execName, totalRestartAttempts), "ERR-2344233432")
+						c.ShutdownMainProcess()
+						break
+					}
 
-						cmdItem = NewMonitoredCmd(c.createForExecCommand(execConfig, workingDir))
+					Helper.PrintErrName(fmt.Sprintf("Command '%s' finished with error: %v. Restarting... (Attempt %d/%d)\n", execName, err, restartAttempt+1, totalRestartAttempts), "ERR-234233432")
 
-						stdout, stderr, err := setupCommandPipes(cmdItem.Cmd, execConfig.Name)
-						if err != nil {
-							*cmd = (*cmd)[:len(*cmd)-1] // Remove the last command if there's an error
-							continue
-						}
+					if execConfig.Cascade_exit {
+						Helper.PrintGroupName(fmt.Sprintf("Cascade exit enabled for command '%s'. Exiting process.\n", execName))
+						c.ShutdownMainProcess()
+						break
+					}
 
-						// Capture and log stdout and stderr
-						go printOutputWithIdentity(stdout, execConfig.Name)
-						go printOutputWithIdentity(stderr, execConfig.Name)
-
-						cmdItem.Cmd.Start()
-						*cmd = append(*cmd, cmdItem.Cmd)
-					} else {
-						Helper.PrintGroupName(fmt.Sprintf("Command '%s' finished successfully.\n", execName))
-						for in, c := range *cmd {
-							if c.Process.Pid == cmdItem.Cmd.Process.Pid {
-								*cmd = (*cmd)[:in] // Remove the last command if there's an error
-								break
-							}
-						}
-						if execConfig.Cascade_exit {
-							Helper.PrintGroupName(fmt.Sprintf("Cascade exit enabled for command '%s'. Exiting process.\n", execName))
-							c.ShutdownMainProcess()
-						}
+					time.Sleep(2 * time.Second) // Wait before restarting
+					if handle.isStopped() {
 						break
 					}
+
+					cmdItem = NewMonitoredCmd(c.createForExecCommand(execConfig, workingDir))
+
+					stdout, stderr, err := setupCommandPipes(cmdItem.Cmd, execConfig.Name)
+					if err != nil {
+						*cmd = (*cmd)[:len(*cmd)-1] // Remove the last command if there's an error
+						continue
+					}
+
+					// Capture and log stdout and stderr
+					go printOutputWithIdentity(stdout, execConfig.Name)
+					go printOutputWithIdentity(stderr, execConfig.Name)
+
+					cmdItem.Cmd.Start()
+					handle.setCmd(cmdItem.Cmd)
+					*cmd = append(*cmd, cmdItem.Cmd)
+				} else {
+					Helper.PrintGroupName(fmt.Sprintf("Command '%s' finished successfully.\n", execName))
+					for in, c := range *cmd {
+						if c.Process.Pid == cmdItem.Cmd.Process.Pid {
+							*cmd = (*cmd)[:in] // Remove the last command if there's an error
+							break
+						}
+					}
+					if execConfig.Cascade_exit {
+						Helper.PrintGroupName(fmt.Sprintf("Cascade exit enabled for command '%s'. Exiting process.\n", execName))
+						c.ShutdownMainProcess()
+					}
+					break
 				}
-			}(cmdItem, execConfig.Name)
+			}
+		}(cmdItem, execConfig.Name)
 
-			break // Exit retry loop on success
-		}
+		break // Exit retry loop on success
 	}
 }
 
@@ -931,7 +1172,7 @@ func (c *ConfigYamlSupport) ListenForShutdownFromConn(conn BrokerConnectionInter
 	if identityId == "" {
 		identityId = c.ConfigData.Identity_id
 	}
-	_, err := conn.BasicSub(identityId+".shutdown", func(msg string) {
+	_, err := conn.BasicSub(TopicShutdown(identityId), func(msg string) {
 		fmt.Println("Received shutdown signal from broker connection")
 		p, _ := os.FindProcess(os.Getpid())
 		p.Signal(syscall.SIGINT)
@@ -945,12 +1186,12 @@ func (c *ConfigYamlSupport) ListenForShutdownFromConn(conn BrokerConnectionInter
 func (c *ConfigYamlSupport) ShutdownMainProcess() {
 	identityId := os.Getenv("JOB_ITEM_IDENTITY_ID")
 	fmt.Println("Shutting down main process with identity ID:", identityId)
-	conn := Helper.BrokerConnection.GetConnection(c.ConfigData.Broker_connection["key"].(string))
+	conn := Helper.BrokerConnection.GetConnection(c.GetDefaultBrokerKey())
 	if conn == nil {
 		fmt.Println("No broker connection available")
 		return
 	}
-	conn.Pub(identityId+".shutdown", "")
+	conn.Pub(TopicShutdown(identityId), "")
 }
 
 // monitorCommand listens for when an *exec.Cmd exits, either successfully or with an error.
@@ -966,12 +1207,31 @@ func monitorCommand(cmd *exec.Cmd, name string) {
 	}()
 }
 
+// Closed once the process started by waitWithTimeout exited, by its *exec.Cmd.
+// The entry is removed once closed, so a restarted process does not leak it.
+var processExits sync.Map
+
+// ProcessExited returns a channel closed once the child process exited,
+// nil once the exit was seen and for another process.
+func ProcessExited(cmd *exec.Cmd) <-chan struct{} {
+	exited, ok := processExits.Load(cmd)
+	if !ok {
+		return nil
+	}
+	return exited.(chan struct{})
+}
+
 // waitWithTimeout waits for the command to finish with a timeout.
 // If the timeout is reached, it returns nil without killing the process.
 func waitWithTimeout(cmd *exec.Cmd, timeout time.Duration) error {
 	done := make(chan error, 1)
+	exited := make(chan struct{})
+	processExits.Store(cmd, exited)
 	go func() {
 		done <- cmd.Wait()
+		close(exited)
+		processExits.Delete(cmd)
+		childHealthAddrs.Delete(cmd)
 	}()
 
 	select {
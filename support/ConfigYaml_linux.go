@@ -11,10 +11,22 @@ import (
 	"syscall"
 )
 
-func ReplaceApp(executablePath string, app_new string) *exec.Cmd {
-	cmd := exec.Command("sh", "-c", "rm "+executablePath+" || true && mv "+app_new+" "+executablePath+" && exit")
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-	return cmd
+// ReplaceApp keeps the running executable as <exe>.prev with a hard link (a copy on another file system),
+// then renames the new one over it. The rename is atomic, the executable is never missing or half written.
+func ReplaceApp(executablePath string, app_new string) error {
+	prevPath := executablePath + UPDATE_PREV_SUFFIX
+	os.Remove(prevPath)
+	if err := os.Link(executablePath, prevPath); err != nil {
+		if err := copyFile(executablePath, prevPath); err != nil {
+			return fmt.Errorf("keep the previous version: %w", err)
+		}
+	}
+	return os.Rename(app_new, executablePath)
+}
+
+// RestorePreviousApp renames <exe>.prev back over the executable.
+func RestorePreviousApp(executablePath string) error {
+	return os.Rename(executablePath+UPDATE_PREV_SUFFIX, executablePath)
 }
 
 func (c *ConfigYamlSupport) createForChildProcessCommand() (*exec.Cmd, error) {
@@ -86,7 +98,7 @@ func (c *ConfigYamlSupport) createForExecCommand(execConfig ExecConfig, workingD
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Setpgid: true,
 	}
-	cmd.Env = append(os.Environ(), c.GetEnvForExecProcess()...)
+	cmd.Env = append(GetProcessEnv(), c.GetEnvForExecProcess()...)
 	cmd.Dir = workingDir
 
 	// Set environment variables
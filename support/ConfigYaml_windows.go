@@ -11,9 +11,29 @@ import (
 	"strconv"
 )
 
-func ReplaceApp(executablePath string, app_new string) *exec.Cmd {
-	cmd := exec.Command("cmd", "ping google.co.id && del "+executablePath+" && move "+app_new+" "+executablePath)
-	return cmd
+// ReplaceApp renames the running executable to <exe>.prev, Windows can not replace a running
+// executable but can rename it, then renames the new one to the executable.
+func ReplaceApp(executablePath string, app_new string) error {
+	prevPath := executablePath + UPDATE_PREV_SUFFIX
+	os.Remove(prevPath)
+	if err := os.Rename(executablePath, prevPath); err != nil {
+		return fmt.Errorf("keep the previous version: %w", err)
+	}
+	if err := os.Rename(app_new, executablePath); err != nil {
+		os.Rename(prevPath, executablePath)
+		return err
+	}
+	return nil
+}
+
+// RestorePreviousApp renames the new executable away, it may still be running, then <exe>.prev back.
+func RestorePreviousApp(executablePath string) error {
+	badPath := executablePath + ".bad"
+	os.Remove(badPath)
+	if err := os.Rename(executablePath, badPath); err != nil {
+		return err
+	}
+	return os.Rename(executablePath+UPDATE_PREV_SUFFIX, executablePath)
 }
 
 func (c *ConfigYamlSupport) createForChildProcessCommand() (*exec.Cmd, error) {
@@ -46,8 +66,6 @@ func (c *ConfigYamlSupport) createForChildProcessCommand() (*exec.Cmd, error) {
 		cmd = exec.Command(executablePath, "child_process", "--config", config_path)
 	}
 
-	cmd.Env = append(os.Environ(), c.GetEnv()...)
-
 	return cmd, nil
 }
 
@@ -56,6 +74,8 @@ func (c *ConfigYamlSupport) createForChildProcessCommand() (*exec.Cmd, error) {
 func (c *ConfigYamlSupport) createForExecCommand(execConfig ExecConfig, workingDir string) *exec.Cmd {
 	cmd := exec.Command("cmd", "/C", execConfig.Cmd)
 	cmd.Dir = workingDir
+	// The environment without the secret, the exec asks for one on its env
+	cmd.Env = GetProcessEnv()
 
 	// Set environment variables
 	for key, value := range execConfig.Env {
@@ -0,0 +1,387 @@
+package support
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	support_helper "job_item/support/helper"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterBrokerType("http", BrokerTypeRegistration{
+		Fields: []ConfigField{
+			{Name: "end_point", Kind: CONFIG_KIND_STRING},
+			{Name: "poll_timeout", Kind: CONFIG_KIND_NUMBER},
+		},
+		// Empty end_point uses the Job Manager end_point, checked by ValidateConfigData
+		Validate: func(path string, v map[string]interface{}, errs *ConfigErrors) {
+			if configHas(v, "end_point") {
+				validateUrl(path+".end_point", configString(v, "end_point"), errs)
+			}
+		},
+		NewConnection: func() BrokerConInterface {
+			return &HttpBrokerConnection{}
+		},
+		Construct: func(conn BrokerConInterface) (BrokerConnectionInterface, error) {
+			return HttpSupportConstruct(conn.GetConnection().(HttpBrokerConnection))
+		},
+	})
+}
+
+// HttpSupport is a broker connection over plain HTTP(S) to the Job Manager,
+// for the site that blocks every outbound port except HTTPS.
+//
+// Protocol:
+//   - POST <end_point>/api/worker/broker/publish {"topic", "data"}
+//   - POST <end_point>/api/worker/broker/poll {"client_id", "timeout_second", "subscriptions": [{"topic", "group"}]}
+//     returns 200 {"messages": [{"topic", "group", "data"}]} or 204 when nothing arrives before the timeout.
+//     The Job Manager delivers the message of a group to only one client of the group.
+//
+// Every request carries "project_id" and "secret_key" in the body like the other Job Manager call.
+// A poll is never canceled for a new subscription, the message already taken by the Job Manager
+// would be lost, the new subscription list goes with the next poll. SubSync and BasicSubSync do not
+// wait for it, they poll on their own client id for their timeout.
+func HttpSupportConstruct(props HttpBrokerConnection) (*HttpSupport, error) {
+	clientId, err := support_helper.GenerateUUIDv7()
+	if err != nil {
+		return nil, err
+	}
+	gg := HttpSupport{
+		httpConfInfo: props,
+		clientId:     clientId,
+		outbox:       BrokerOutboxConstruct(props.Key),
+		subs:         BrokerSubscriptionRegistryConstruct(props.Key),
+		listeners:    map[int]*httpListener{},
+		client:       &http.Client{},
+	}
+	err = gg.ConnectPubSub()
+	return &gg, err
+}
+
+type httpListener struct {
+	topic    string
+	group    string
+	callback func(message string)
+}
+
+type httpBrokerMessage struct {
+	Topic string `json:"topic"`
+	Group string `json:"group,omitempty"`
+	Data  string `json:"data"`
+}
+
+type HttpSupport struct {
+	httpConfInfo HttpBrokerConnection
+	clientId     string
+	client       *http.Client
+	key          string
+	outbox       *BrokerOutbox
+	subs         *BrokerSubscriptionRegistry
+	mutex        sync.Mutex
+	lastId       int
+	listeners    map[int]*httpListener
+	// Cancel the wait without subscription so the first one is sent right away
+	cancelIdle context.CancelFunc
+	// Cancel the running poll on Close
+	cancelPoll context.CancelFunc
+}
+
+func (c *HttpSupport) GetRefreshPubSub() string {
+	return BROKER_REFRESH_PUBSUB
+}
+
+func (c *HttpSupport) endPoint() string {
+	endPoint := c.httpConfInfo.End_point
+	if endPoint == "" && Helper != nil && Helper.ConfigYaml != nil {
+		endPoint = Helper.ConfigYaml.GetConfigData().End_point
+	}
+	return strings.TrimRight(endPoint, "/")
+}
+
+func (c *HttpSupport) pollTimeout() int {
+	if c.httpConfInfo.Poll_timeout > 0 {
+		return c.httpConfInfo.Poll_timeout
+	}
+	return 25
+}
+
+func (c *HttpSupport) request(ctx context.Context, path string, body map[string]any) (*http.Response, error) {
+	if Helper != nil && Helper.ConfigYaml != nil {
+		credential := Helper.ConfigYaml.GetConfigData().Credential
+		body["project_id"] = credential.Project_id
+		body["secret_key"] = credential.Secret_key
+	}
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, "POST", c.endPoint()+path, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	return c.client.Do(request)
+}
+
+func (c *HttpSupport) ConnectPubSub() error {
+	if c.endPoint() == "" {
+		return errors.New("http broker needs end_point")
+	}
+	fmt.Println("HTTP Broker Connection inf :: ", c.endPoint())
+	c.subs.SetConnected(true)
+	go c.pollLoop()
+	return nil
+}
+
+// pollLoop long-polls the Job Manager for the message of every listener.
+func (c *HttpSupport) pollLoop() {
+	for !c.subs.IsClosed() {
+		c.mutex.Lock()
+		subscriptions := []httpBrokerMessage{}
+		for _, v := range c.listeners {
+			subscriptions = append(subscriptions, httpBrokerMessage{Topic: v.topic, Group: v.group})
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.pollTimeout()+10)*time.Second)
+		if len(subscriptions) == 0 {
+			c.cancelIdle = cancel
+		} else {
+			c.cancelPoll = cancel
+		}
+		c.mutex.Unlock()
+
+		if len(subscriptions) == 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			c.mutex.Lock()
+			c.cancelIdle = nil
+			c.mutex.Unlock()
+			cancel()
+			continue
+		}
+
+		messages, err := c.poll(ctx, c.clientId, c.pollTimeout(), subscriptions)
+		c.mutex.Lock()
+		c.cancelPoll = nil
+		c.mutex.Unlock()
+		cancel()
+		if err != nil {
+			if c.subs.IsClosed() {
+				return
+			}
+			if c.subs.IsConnected() {
+				fmt.Println("Disconnected from HTTP broker, attempting to reconnect:", err)
+			}
+			c.subs.SetConnected(false)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		if !c.subs.IsConnected() {
+			fmt.Println("Successfully reconnected to HTTP broker")
+			c.outbox.Flush(c.publish)
+			c.subs.SetConnected(true)
+			Helper.EventBus.GetBus().Publish(BROKER_REFRESH_PUBSUB, nil)
+		}
+		for _, msg := range messages {
+			c.dispatch(msg)
+		}
+	}
+}
+
+func (c *HttpSupport) poll(ctx context.Context, clientId string, timeoutSecond int, subscriptions []httpBrokerMessage) ([]httpBrokerMessage, error) {
+	response, err := c.request(ctx, "/api/worker/broker/poll", map[string]any{
+		"client_id":      clientId,
+		"timeout_second": timeoutSecond,
+		"subscriptions":  subscriptions,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		return nil, fmt.Errorf("poll returned status %d: %s", response.StatusCode, string(body))
+	}
+	bodyData := struct {
+		Messages []httpBrokerMessage `json:"messages"`
+	}{}
+	if err := json.NewDecoder(response.Body).Decode(&bodyData); err != nil {
+		return nil, err
+	}
+	return bodyData.Messages, nil
+}
+
+func (c *HttpSupport) dispatch(msg httpBrokerMessage) {
+	c.mutex.Lock()
+	callbacks := []func(message string){}
+	for _, v := range c.listeners {
+		if v.topic == msg.Topic && v.group == msg.Group {
+			callbacks = append(callbacks, v.callback)
+		}
+	}
+	c.mutex.Unlock()
+	for _, callback := range callbacks {
+		callback(msg.Data)
+	}
+}
+
+// listen adds the listener, sent with the next poll. Only the wait without subscription is
+// cut short, the running poll finishes so the message it carries is not lost.
+func (c *HttpSupport) listen(topic string, group string, callback func(message string)) func() {
+	c.mutex.Lock()
+	c.lastId++
+	id := c.lastId
+	c.listeners[id] = &httpListener{topic: topic, group: group, callback: callback}
+	if c.cancelIdle != nil {
+		c.cancelIdle()
+	}
+	c.mutex.Unlock()
+	return func() {
+		c.mutex.Lock()
+		delete(c.listeners, id)
+		c.mutex.Unlock()
+	}
+}
+
+// Interface from BrokerConnectionInterface
+func (c *HttpSupport) Pub(topic string, msg string) {
+	c.outbox.Publish(c.IsConnected(), topic, msg, c.publish)
+}
+
+func (c *HttpSupport) publish(topic string, msg string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	response, err := c.request(ctx, "/api/worker/broker/publish", map[string]any{
+		"topic": topic,
+		"data":  msg,
+	})
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusCreated && response.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("publish returned status %d", response.StatusCode)
+	}
+	return nil
+}
+
+// listenSync waits one message up to the timeout. Returns (isTimeout, error).
+// The long poll of the connection only takes the new subscription on its next round, so the wait
+// polls alone as <client_id>.<n> with the timeout left, then leaves with an empty poll.
+func (c *HttpSupport) listenSync(topic string, group string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
+	c.mutex.Lock()
+	c.lastId++
+	clientId := fmt.Sprint(c.clientId, ".", c.lastId)
+	c.mutex.Unlock()
+	defer c.leave(clientId)
+
+	subscriptions := []httpBrokerMessage{{Topic: topic, Group: group}}
+	deadline := time.Now().Add(time.Duration(opts.Timeout_second) * time.Second)
+	for !c.subs.IsClosed() {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		// The Job Manager holds the poll for whole second, the request gives it a margin
+		ctx, cancel := context.WithTimeout(context.Background(), remaining+2*time.Second)
+		messages, err := c.poll(ctx, clientId, int(math.Ceil(remaining.Seconds())), subscriptions)
+		cancel()
+		if err != nil {
+			fmt.Println("HTTP broker sync poll error :: ", topic, " :: ", err)
+			time.Sleep(min(time.Second, time.Until(deadline)))
+			continue
+		}
+		for _, msg := range messages {
+			if msg.Topic == topic && msg.Group == group {
+				fmt.Printf("\n unSubscribeFinish: %s\n", msg.Data)
+				callback(msg.Data, nil)
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// leave sends the empty poll of the client id no longer polled, the Job Manager drops its subscription.
+func (c *HttpSupport) leave(clientId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := c.poll(ctx, clientId, 0, []httpBrokerMessage{}); err != nil {
+		fmt.Println("HTTP broker leave error :: ", clientId, " :: ", err)
+	}
+}
+
+// Interface from BrokerConnectionInterface
+func (c *HttpSupport) Sub(topic string, group_id string, callback func(message string)) (func(), error) {
+	return c.subs.Subscribe(topic, func() (func(), error) {
+		return c.listen(topic, group_id, callback), nil
+	})
+}
+
+// Interface from BrokerConnectionInterface
+func (c *HttpSupport) SubSync(uuidItem string, group_id string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
+	return c.listenSync(uuidItem, group_id, callback, opts)
+}
+
+// Interface from BrokerConnectionInterface
+func (c *HttpSupport) BasicSub(topic string, callback func(message string)) (func(), error) {
+	return c.subs.Subscribe(topic, func() (func(), error) {
+		return c.listen(topic, "", callback), nil
+	})
+}
+
+// Interface from BrokerConnectionInterface
+func (c *HttpSupport) BasicSubSync(topic string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
+	return c.listenSync(topic, "", callback, opts)
+}
+
+// Interface from BrokerConnectionInterface
+func (c *HttpSupport) SetKey_P(key string) {
+	c.key = key
+}
+
+// Interface from BrokerConnectionInterface
+func (c *HttpSupport) GetKey_P() string {
+	return c.key
+}
+
+// Interface from BrokerConnectionInterface
+func (c *HttpSupport) GetBroker_P() any {
+	return c
+}
+
+// Interface from BrokerConnectionInterface
+func (c *HttpSupport) IsConnected() bool {
+	return c.subs.IsConnected()
+}
+
+// Interface from BrokerConnectionInterface
+func (c *HttpSupport) Close() {
+	c.subs.Close()
+	c.mutex.Lock()
+	c.listeners = map[int]*httpListener{}
+	// The poll loop sees the closed registry and stops
+	for _, cancel := range []context.CancelFunc{c.cancelIdle, c.cancelPoll} {
+		if cancel != nil {
+			cancel()
+		}
+	}
+	c.mutex.Unlock()
+}
+
+// Interface from SupportInterface
+func (c *HttpSupport) GetObject() any {
+	return c
+}
@@ -0,0 +1,216 @@
+package support_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	support "job_item/support"
+	"job_item/support/brokertest"
+)
+
+type httpStubMessage struct {
+	Topic string `json:"topic"`
+	Group string `json:"group,omitempty"`
+	Data  string `json:"data"`
+}
+
+// httpBrokerStub is the publish and poll side of the Job Manager for the http broker.
+// The message of a group goes to one client of the group in turn, the message without
+// group to every client, each client keeps its message until its next poll.
+type httpBrokerStub struct {
+	mutex         sync.Mutex
+	subscriptions map[string][]httpStubMessage
+	queue         map[string][]httpStubMessage
+	turn          map[string]int
+	wake          chan struct{}
+}
+
+func newHttpBrokerStub() *httpBrokerStub {
+	return &httpBrokerStub{
+		subscriptions: map[string][]httpStubMessage{},
+		queue:         map[string][]httpStubMessage{},
+		turn:          map[string]int{},
+		wake:          make(chan struct{}),
+	}
+}
+
+func (s *httpBrokerStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body := struct {
+		Topic         string            `json:"topic"`
+		Data          string            `json:"data"`
+		ClientId      string            `json:"client_id"`
+		TimeoutSecond int               `json:"timeout_second"`
+		Subscriptions []httpStubMessage `json:"subscriptions"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	switch r.URL.Path {
+	case "/api/worker/broker/publish":
+		s.publish(body.Topic, body.Data)
+		w.WriteHeader(http.StatusOK)
+	case "/api/worker/broker/poll":
+		messages := s.poll(r, body.ClientId, body.Subscriptions, time.Duration(body.TimeoutSecond)*time.Second)
+		if len(messages) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"messages": messages})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *httpBrokerStub) publish(topic string, data string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	groups := map[string][]string{}
+	for clientId, subscriptions := range s.subscriptions {
+		for _, v := range subscriptions {
+			if v.Topic != topic {
+				continue
+			}
+			if v.Group == "" {
+				s.queue[clientId] = append(s.queue[clientId], httpStubMessage{Topic: topic, Data: data})
+				continue
+			}
+			groups[v.Group] = append(groups[v.Group], clientId)
+		}
+	}
+	for group, clients := range groups {
+		clientId := clients[s.turn[topic+"/"+group]%len(clients)]
+		s.turn[topic+"/"+group]++
+		s.queue[clientId] = append(s.queue[clientId], httpStubMessage{Topic: topic, Group: group, Data: data})
+	}
+	close(s.wake)
+	s.wake = make(chan struct{})
+}
+
+func (s *httpBrokerStub) poll(r *http.Request, clientId string, subscriptions []httpStubMessage, timeout time.Duration) []httpStubMessage {
+	deadline := time.After(timeout)
+	s.mutex.Lock()
+	if len(subscriptions) == 0 {
+		// The client leaves
+		delete(s.subscriptions, clientId)
+		delete(s.queue, clientId)
+		s.mutex.Unlock()
+		return nil
+	}
+	s.subscriptions[clientId] = subscriptions
+	for {
+		if messages := s.queue[clientId]; len(messages) > 0 {
+			delete(s.queue, clientId)
+			s.mutex.Unlock()
+			return messages
+		}
+		wake := s.wake
+		s.mutex.Unlock()
+		select {
+		case <-wake:
+		case <-deadline:
+			return nil
+		case <-r.Context().Done():
+			return nil
+		}
+		s.mutex.Lock()
+	}
+}
+
+func httpBrokerFactory(endPoint string, pollTimeout int) brokertest.Factory {
+	return func() (support.BrokerConnectionInterface, error) {
+		decoded, err := support.DecodeBrokerConnection("broker_connection", map[string]interface{}{
+			"key":          "brokertest",
+			"name":         "Broker test",
+			"type":         "http",
+			"end_point":    endPoint,
+			"poll_timeout": pollTimeout,
+		})
+		if err != nil {
+			return nil, err
+		}
+		gg, err := support.NewBrokerConnection(decoded)
+		if err != nil {
+			return nil, err
+		}
+		gg.SetKey_P("brokertest")
+		return gg, nil
+	}
+}
+
+// go test ./support -run TestHttpBroker
+func TestHttpBroker(t *testing.T) {
+	server := httptest.NewServer(newHttpBrokerStub())
+	defer server.Close()
+	// A new subscription goes out with the next poll
+	runBrokerTest(t, httpBrokerFactory(server.URL, 1), brokertest.Options{Settle: 1500 * time.Millisecond})
+}
+
+// A sync wait shorter than poll_timeout does not wait the running long poll:
+// it times out on time, receives the message and the group message goes to one waiter.
+func TestHttpBrokerSyncDuringPoll(t *testing.T) {
+	stub := newHttpBrokerStub()
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	factory := httpBrokerFactory(server.URL, 25)
+	conn, err := factory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	publisher, err := factory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+	// The long poll of the connection is running with this subscription
+	unsubscribe, err := conn.BasicSub("other", func(message string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+	time.Sleep(1500 * time.Millisecond)
+
+	start := time.Now()
+	isTimeout, err := conn.SubSync("task_finish", "group", func(message string, err error) {
+		t.Errorf("callback called on timeout with %q", message)
+	}, support.SubSyncOpts{Timeout_second: 1})
+	if elapsed := time.Since(start); !isTimeout || err != nil || elapsed > 3*time.Second {
+		t.Fatalf("SubSync returned (%v, %v) after %s, want (true, nil) after about 1s", isTimeout, err, elapsed)
+	}
+
+	received := make(chan string, 2)
+	wg := sync.WaitGroup{}
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn.SubSync("task_finish", "group", func(message string, err error) {
+				received <- message
+			}, support.SubSyncOpts{Timeout_second: 3})
+		}()
+	}
+	time.Sleep(500 * time.Millisecond)
+	publisher.Pub("task_finish", "done")
+	wg.Wait()
+	close(received)
+	messages := []string{}
+	for message := range received {
+		messages = append(messages, message)
+	}
+	if len(messages) != 1 || messages[0] != "done" {
+		t.Fatalf("received %v, want the message once", messages)
+	}
+
+	// Only the long poll of the connection is left on the Job Manager
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+	if len(stub.subscriptions) != 1 {
+		t.Fatalf("%d client on the Job Manager, the sync wait did not leave", len(stub.subscriptions))
+	}
+}
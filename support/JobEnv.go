@@ -0,0 +1,66 @@
+package support
+
+import (
+	"os"
+	"runtime"
+	"strings"
+)
+
+// JobEnvConfig is the environment given to a job command.
+type JobEnvConfig struct {
+	// Variable of the worker passed to the job beside the default one, "AWS_*" matches the prefix
+	Allow []string `yaml:"allow" json:"allow,omitempty"`
+	// Lifetime of JOB_ITEM_TASK_TOKEN, default 86400 (1 day)
+	Token_ttl_second int `yaml:"token_ttl_second" json:"token_ttl_second,omitempty"`
+}
+
+// The project secret key, only given to the job with expose_project_key, never taken from the worker
+const JOB_PROJECT_KEY_ENV = "JOB_ITEM_PROJECT_KEY"
+
+// Variable of the worker every job gets, what a shell and the job_item save and upload command need
+var defaultJobEnvAllow = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TERM", "TZ", "LANG", "LANGUAGE", "LC_*",
+	"TMPDIR", "TMP", "TEMP",
+	// Windows
+	"SYSTEMROOT", "SYSTEMDRIVE", "WINDIR", "COMSPEC", "PATHEXT", "USERPROFILE", "USERNAME",
+	"APPDATA", "LOCALAPPDATA", "PROGRAMDATA", "PROGRAMFILES*", "NUMBER_OF_PROCESSORS", "PROCESSOR_ARCHITECTURE",
+	// Set by the main process for the child process
+	"JOB_ITEM_IDENTITY_ID", "JOB_ITEM_BASE_URL", "JOB_ITEM_SHARE_DATA_*",
+}
+
+// GetJobProcessEnv returns the variable of the worker on the allow list, the default one and allow,
+// a variable holding a resolved secret or the project secret key is never given.
+func GetJobProcessEnv(allow []string) []string {
+	patterns := append(append([]string{}, defaultJobEnvAllow...), allow...)
+	env := []string{}
+	for _, v := range os.Environ() {
+		name, value, _ := strings.Cut(v, "=")
+		if IsSecretValue(value) || matchEnvName([]string{JOB_PROJECT_KEY_ENV}, name) || !matchEnvName(patterns, name) {
+			continue
+		}
+		env = append(env, v)
+	}
+	return env
+}
+
+func matchEnvName(patterns []string, name string) bool {
+	// The variable name of Windows is case insensitive
+	if runtime.GOOS == "windows" {
+		name = strings.ToUpper(name)
+	}
+	for _, pattern := range patterns {
+		if runtime.GOOS == "windows" {
+			pattern = strings.ToUpper(pattern)
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+			continue
+		}
+		if name == pattern {
+			return true
+		}
+	}
+	return false
+}
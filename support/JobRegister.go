@@ -0,0 +1,71 @@
+package support
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"job_item/support/model"
+	"net/http"
+	"strings"
+)
+
+// GetUnregisteredJobs returns the job subscribed to nothing because its event is not on the Job Manager project.
+// The local job and the nested event "<event>.<nested>" are not returned, a nested job is registered under its parent.
+func (c *ConfigYamlSupport) GetUnregisteredJobs() []ConfigJob {
+	jobs := []ConfigJob{}
+	if c.ConfigData.End_point == "" {
+		return jobs
+	}
+	for _, v := range c.ConfigData.Jobs {
+		if v.Local || strings.Contains(v.Event, ".") || len(c.GetJobEvents(v)) > 0 {
+			continue
+		}
+		jobs = append(jobs, v)
+	}
+	return jobs
+}
+
+// registerMissingJobs registers the event of the unregistered job on the Job Manager project
+// when register_jobs is set, and adds the returned job data to the project.
+func (c *ConfigYamlSupport) registerMissingJobs() ([]model.JobData, error) {
+	if !c.ConfigData.Register_jobs {
+		return nil, nil
+	}
+	missing := c.GetUnregisteredJobs()
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	jobs := []map[string]string{}
+	for _, v := range missing {
+		jobs = append(jobs, map[string]string{"event": v.Event, "name": v.Name})
+	}
+	jsonDataParam, err := json.Marshal(map[string]interface{}{
+		"project_id": c.ConfigData.Credential.Project_id,
+		"secret_key": c.ConfigData.Credential.Secret_key,
+		"jobs":       jobs,
+	})
+	if err != nil {
+		return nil, err
+	}
+	endpoint := fmt.Sprint(c.ConfigData.End_point, "/api/worker/job/register")
+	c.printGroupName(fmt.Sprintf("Register %d job event on the Job Manager: %s", len(jobs), endpoint))
+	response, err := http.Post(endpoint, "application/json", bytes.NewBuffer(jsonDataParam))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, &ConfigServerStatusError{Status_code: response.StatusCode, Status: response.Status}
+	}
+	bodyData := struct {
+		Return []model.JobData
+	}{}
+	if err := json.NewDecoder(response.Body).Decode(&bodyData); err != nil {
+		return nil, err
+	}
+	for _, v := range bodyData.Return {
+		c.printGroupName("Job event registered :: " + v.Event)
+	}
+	c.ConfigData.Project.Job_datas = append(c.ConfigData.Project.Job_datas, bodyData.Return...)
+	return bodyData.Return, nil
+}
@@ -0,0 +1,114 @@
+package support
+
+import (
+	"encoding/json"
+	"errors"
+	"job_item/support/model"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// jobRegisterTestSupport has every kind of job: registered, nested, local and missing.
+func jobRegisterTestSupport(endPoint string) *ConfigYamlSupport {
+	c := &ConfigYamlSupport{}
+	c.ConfigData.End_point = endPoint
+	c.ConfigData.Credential.Project_id = "project"
+	c.ConfigData.Credential.Secret_key = "key"
+	c.ConfigData.Register_jobs = true
+	c.ConfigData.Jobs = []ConfigJob{
+		{Event: "backup", Cmd: "backup.sh"},
+		{Event: "backup.upload", Cmd: "upload.sh"},
+		{Event: "cleanup", Cmd: "cleanup.sh", Local: true},
+		{Event: "report", Name: "Report", Cmd: "report.sh"},
+		{Event: "export.csv", Cmd: "csv.sh"},
+	}
+	c.ConfigData.Project.Job_datas = []model.JobData{
+		{Event: "backup", Nested_jobs: &[]model.NestedJob{{Event: "upload"}}},
+	}
+	return c
+}
+
+func jobEvents(jobs []ConfigJob) []string {
+	events := []string{}
+	for _, v := range jobs {
+		events = append(events, v.Event)
+	}
+	return events
+}
+
+// The local job and the nested event are never registered, only the missing top level job.
+func TestGetUnregisteredJobs(t *testing.T) {
+	c := jobRegisterTestSupport("http://job-manager")
+	if got := jobEvents(c.GetUnregisteredJobs()); !reflect.DeepEqual(got, []string{"report"}) {
+		t.Fatalf("GetUnregisteredJobs() = %v, want [report]", got)
+	}
+
+	// Without the Job Manager every job is local
+	c.ConfigData.End_point = ""
+	if got := c.GetUnregisteredJobs(); len(got) != 0 {
+		t.Fatalf("GetUnregisteredJobs() without end_point = %v, want none", jobEvents(got))
+	}
+}
+
+func TestRegisterMissingJobs(t *testing.T) {
+	var received map[string]interface{}
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/worker/job/register" {
+			t.Errorf("request %s %s", r.Method, r.URL.Path)
+		}
+		received = map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"return": []model.JobData{{Uuid: "job-uuid", Event: "report", Name: "Report"}},
+		})
+	}))
+	defer server.Close()
+
+	c := jobRegisterTestSupport(server.URL)
+	registered, err := c.registerMissingJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"project_id": "project",
+		"secret_key": "key",
+		"jobs":       []interface{}{map[string]interface{}{"event": "report", "name": "Report"}},
+	}
+	if !reflect.DeepEqual(received, want) {
+		t.Fatalf("register body = %v, want %v", received, want)
+	}
+	if len(registered) != 1 || registered[0].Uuid != "job-uuid" {
+		t.Fatalf("registered = %v, want the returned job data", registered)
+	}
+	// The job is subscribed now and not registered again
+	if got := c.GetJobEvents(c.ConfigData.Jobs[3]); !reflect.DeepEqual(got, []string{"report"}) {
+		t.Fatalf("GetJobEvents(report) = %v after register", got)
+	}
+	received = nil
+	if registered, err := c.registerMissingJobs(); err != nil || registered != nil || received != nil {
+		t.Fatalf("second register sent %v, returned %v, %v", received, registered, err)
+	}
+
+	// The non-200 answer is an error and changes nothing
+	status = http.StatusForbidden
+	c = jobRegisterTestSupport(server.URL)
+	registered, err = c.registerMissingJobs()
+	var statusError *ConfigServerStatusError
+	if !errors.As(err, &statusError) || statusError.Status_code != http.StatusForbidden || registered != nil {
+		t.Fatalf("registerMissingJobs() = %v, %v, want the 403 status error", registered, err)
+	}
+	if len(c.ConfigData.Project.Job_datas) != 1 {
+		t.Fatalf("job data changed to %v on error", c.ConfigData.Project.Job_datas)
+	}
+
+	// Without register_jobs nothing is sent
+	c.ConfigData.Register_jobs = false
+	received = nil
+	if registered, err := c.registerMissingJobs(); err != nil || registered != nil || received != nil {
+		t.Fatalf("register_jobs false sent %v, returned %v, %v", received, registered, err)
+	}
+}
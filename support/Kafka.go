@@ -0,0 +1,529 @@
+package support
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+func init() {
+	RegisterBrokerType("kafka", BrokerTypeRegistration{
+		Fields: []ConfigField{
+			{Name: "host", Kind: CONFIG_KIND_STRING},
+			{Name: "port", Kind: CONFIG_KIND_NUMBER},
+			{Name: "brokers", Kind: CONFIG_KIND_LIST},
+			{Name: "sasl_mechanism", Kind: CONFIG_KIND_STRING},
+			{Name: "user", Kind: CONFIG_KIND_STRING},
+			{Name: "password", Kind: CONFIG_KIND_STRING},
+			{Name: "topic_prefix", Kind: CONFIG_KIND_STRING},
+			{Name: "events_topic", Kind: CONFIG_KIND_STRING},
+		},
+		Validate: func(path string, v map[string]interface{}, errs *ConfigErrors) {
+			if len(configStringList(v["brokers"])) == 0 && !(configHas(v, "host") && configHas(v, "port")) {
+				errs.add(path+".brokers", "brokers or host and port is required")
+			}
+			switch configString(v, "sasl_mechanism") {
+			case "", "none", "plain", "scram-sha-256", "scram-sha-512":
+			default:
+				errs.add(path+".sasl_mechanism", "expected one of none, plain, scram-sha-256, scram-sha-512, got %q", configString(v, "sasl_mechanism"))
+			}
+		},
+		NewConnection: func() BrokerConInterface {
+			return &KafkaBrokerConnection{}
+		},
+		Construct: func(conn BrokerConInterface) (BrokerConnectionInterface, error) {
+			return KafkaSupportConstruct(conn.GetConnection().(KafkaBrokerConnection))
+		},
+	})
+}
+
+// The Kafka topic of every task topic, see isKafkaTaskTopic
+const KAFKA_DEFAULT_EVENTS_TOPIC = "job_item.events"
+
+func KafkaSupportConstruct(props KafkaBrokerConnection) (*KafkaSupport, error) {
+	gg := KafkaSupport{
+		kafkaConfInfo: props,
+		outbox:        BrokerOutboxConstruct(props.Key),
+		subs:          BrokerSubscriptionRegistryConstruct(props.Key),
+		tasks:         map[string]*kafkaPendingMessage{},
+		routers:       map[string]*kafkaRouter{},
+	}
+	err := gg.ConnectPubSub()
+	return &gg, err
+}
+
+// KafkaSupport drives the job from Kafka topic.
+// The job event is consumed with a consumer group and the offset is committed
+// only after the job publishes <task_id>_finish, so a crashed worker leaves the job for the group.
+// The task topic is published on one events topic keyed by the topic, and every other subscription
+// without a group reads the partitions directly, so only the group of the config lives on the cluster.
+type KafkaSupport struct {
+	kafkaConfInfo KafkaBrokerConnection
+	brokers       []string
+	dialer        *kafka.Dialer
+	writer        *kafka.Writer
+	key           string
+	outbox        *BrokerOutbox
+	subs          *BrokerSubscriptionRegistry
+	tasksMu       sync.Mutex
+	tasks         map[string]*kafkaPendingMessage
+	routersMu     sync.Mutex
+	// The partition reader of each Kafka topic by its name, open until Close
+	routers map[string]*kafkaRouter
+}
+
+func (c *KafkaSupport) GetRefreshPubSub() string {
+	return BROKER_REFRESH_PUBSUB
+}
+
+// KafkaTopicName maps the dotted <project_uuid>.<event> convention to a valid Kafka topic name.
+// The character outside [a-zA-Z0-9._-] is replaced by "_" and the name is limited to 249 character.
+func KafkaTopicName(prefix string, topic string) string {
+	return BrokerTopicName("kafka", prefix+topic)
+}
+
+func (c *KafkaSupport) topicName(topic string) string {
+	return KafkaTopicName(c.kafkaConfInfo.Topic_prefix, topic)
+}
+
+// The per task topic, there is one for every task so they are not a Kafka topic each
+var kafkaTaskTopicSuffixes = []string{"_who", "_worker", "_listen", "_listen.callback", "_process", "_finish", "_failed", ".notif_add"}
+
+// isKafkaTaskTopic returns true for the <task_id>_<suffix> topic, routed on the events topic.
+func isKafkaTaskTopic(topic string) bool {
+	logicalName := TopicLogicalName(topic)
+	for _, suffix := range kafkaTaskTopicSuffixes {
+		if strings.HasSuffix(logicalName, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// kafkaTopic returns the Kafka topic and the message key of the topic.
+// The task topic goes on the events topic with the topic as key, the key keeps the order of the task
+// on one partition. The other topic, like the job event, has its own Kafka topic and no key.
+func (c *KafkaSupport) kafkaTopic(topic string) (string, string) {
+	if isKafkaTaskTopic(topic) {
+		eventsTopic := c.kafkaConfInfo.Events_topic
+		if eventsTopic == "" {
+			eventsTopic = KAFKA_DEFAULT_EVENTS_TOPIC
+		}
+		return c.topicName(eventsTopic), topic
+	}
+	return c.topicName(topic), ""
+}
+
+func (c *KafkaSupport) saslMechanism() (sasl.Mechanism, error) {
+	switch c.kafkaConfInfo.Sasl_mechanism {
+	case "", "none":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: c.kafkaConfInfo.User, Password: c.kafkaConfInfo.Password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, c.kafkaConfInfo.User, c.kafkaConfInfo.Password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, c.kafkaConfInfo.User, c.kafkaConfInfo.Password)
+	}
+	return nil, fmt.Errorf("unsupported sasl_mechanism: %s", c.kafkaConfInfo.Sasl_mechanism)
+}
+
+func (c *KafkaSupport) tlsConfig() (*tls.Config, error) {
+	if !c.kafkaConfInfo.Secure {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{}
+	if c.kafkaConfInfo.CAFile != "" {
+		caCert, err := os.ReadFile(c.kafkaConfInfo.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		caCertPool := x509.NewCertPool()
+		caCertPool.AppendCertsFromPEM(caCert)
+		tlsConfig.RootCAs = caCertPool
+	}
+	if c.kafkaConfInfo.CertFile != "" && c.kafkaConfInfo.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.kafkaConfInfo.CertFile, c.kafkaConfInfo.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client cert/key: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func (c *KafkaSupport) ConnectPubSub() error {
+	c.brokers = c.kafkaConfInfo.Brokers
+	if len(c.brokers) == 0 {
+		c.brokers = []string{c.kafkaConfInfo.Host + ":" + strconv.Itoa(c.kafkaConfInfo.Port)}
+	}
+	fmt.Println("Kafka Connection inf :: ", strings.Join(c.brokers, ","))
+
+	mechanism, err := c.saslMechanism()
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	c.dialer = &kafka.Dialer{
+		Timeout:       10 * time.Second,
+		DualStack:     true,
+		SASLMechanism: mechanism,
+		TLS:           tlsConfig,
+	}
+	c.writer = &kafka.Writer{
+		Addr:         kafka.TCP(c.brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireOne,
+		// Only the events topic and the job event topic are created, the task topic is a key
+		AllowAutoTopicCreation: true,
+		Transport: &kafka.Transport{
+			SASL: mechanism,
+			TLS:  tlsConfig,
+		},
+	}
+
+	// Retry mechanism
+	for {
+		err = c.ping()
+		if err == nil {
+			break
+		}
+		fmt.Println("Kafka connection failed, retrying in 5-10 seconds:", err.Error())
+		time.Sleep(5 * time.Second) // Delay before retrying
+	}
+	fmt.Println("Successfully connected to Kafka server - main")
+	c.subs.SetConnected(true)
+	go c.monitorConnection()
+	return nil
+}
+
+// ping dials one of the broker to check the connection.
+func (c *KafkaSupport) ping() error {
+	var err error
+	for _, broker := range c.brokers {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		var conn *kafka.Conn
+		conn, err = c.dialer.DialContext(ctx, "tcp", broker)
+		cancel()
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+	}
+	return err
+}
+
+// monitorConnection keeps the health state accurate, kafka-go readers and writer reconnect by themselves.
+func (c *KafkaSupport) monitorConnection() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if c.subs.IsClosed() {
+			return
+		}
+		err := c.ping()
+		if err != nil {
+			if c.subs.IsConnected() {
+				fmt.Println("Disconnected from Kafka server, attempting to reconnect:", err)
+			}
+			c.subs.SetConnected(false)
+			continue
+		}
+		if !c.subs.IsConnected() {
+			fmt.Println("Successfully reconnected to Kafka server")
+			c.outbox.Flush(c.publish)
+			c.subs.SetConnected(true)
+			Helper.EventBus.GetBus().Publish(BROKER_REFRESH_PUBSUB, nil)
+		}
+	}
+}
+
+// Interface from BrokerConnectionInterface
+// Publishing <task_id>_finish commits the offset of the job message.
+func (c *KafkaSupport) Pub(topic string, msg string) {
+	c.outbox.Publish(c.IsConnected(), topic, msg, c.publish)
+	if logicalName := TopicLogicalName(topic); strings.HasSuffix(logicalName, "_finish") {
+		c.finishTask(strings.TrimSuffix(logicalName, "_finish"))
+	}
+}
+
+func (c *KafkaSupport) publish(topic string, msg string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	name, key := c.kafkaTopic(topic)
+	message := kafka.Message{
+		Topic: name,
+		Value: []byte(msg),
+	}
+	if key != "" {
+		message.Key = []byte(key)
+	}
+	return c.writer.WriteMessages(ctx, message)
+}
+
+// isJobEventTopic returns true for the <project_uuid>.<event> topic.
+func isJobEventTopic(topic string) bool {
+	if Helper == nil || Helper.ConfigYaml == nil {
+		return false
+	}
+	uuid := Helper.ConfigYaml.GetConfigData().Uuid
+	if uuid == "" {
+		return false
+	}
+	return strings.HasPrefix(topic, TopicJobEvent(uuid, ""))
+}
+
+func (c *KafkaSupport) newReader(topic string, group string) *kafka.Reader {
+	return kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     c.brokers,
+		GroupID:     group,
+		Topic:       c.topicName(topic),
+		Dialer:      c.dialer,
+		StartOffset: kafka.LastOffset,
+	})
+}
+
+// consume reads the topic with the consumer group until the subscription is canceled.
+// The job event message is committed after the job finish, the other message right after the callback.
+func (c *KafkaSupport) consume(topic string, group string, callback func(message string)) (func(), error) {
+	reader := c.newReader(topic, group)
+	tracker := &kafkaOffsetTracker{reader: reader, pending: map[int][]*kafkaPendingMessage{}}
+	deferCommit := isJobEventTopic(topic)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for {
+			m, err := reader.FetchMessage(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				fmt.Println("Kafka fetch message error :: ", topic, " :: ", err)
+				time.Sleep(time.Second)
+				continue
+			}
+			pending := tracker.add(m)
+			taskId := ""
+			if deferCommit {
+				messageObject := struct {
+					Task_id string `json:"task_id"`
+				}{}
+				json.Unmarshal(m.Value, &messageObject)
+				taskId = messageObject.Task_id
+			}
+			if taskId != "" {
+				c.tasksMu.Lock()
+				c.tasks[taskId] = pending
+				c.tasksMu.Unlock()
+			}
+			callback(string(m.Value))
+			if taskId == "" {
+				tracker.done(pending)
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		reader.Close()
+	}, nil
+}
+
+// finishTask commits the offset of the job message of the task.
+func (c *KafkaSupport) finishTask(taskId string) {
+	c.tasksMu.Lock()
+	pending, ok := c.tasks[taskId]
+	delete(c.tasks, taskId)
+	c.tasksMu.Unlock()
+	if ok {
+		pending.tracker.done(pending)
+	}
+}
+
+// consumeSync waits one message of the consumer group up to the timeout. Returns (isTimeout, error).
+func (c *KafkaSupport) consumeSync(topic string, group string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
+	reader := c.newReader(topic, group)
+	defer reader.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(opts.Timeout_second)*time.Second)
+	defer cancel()
+	m, err := reader.FetchMessage(ctx)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return true, nil
+		}
+		return true, err
+	}
+	reader.CommitMessages(context.Background(), m)
+	fmt.Printf("\n unSubscribeFinish: %s\n", m.Value)
+	callback(string(m.Value), nil)
+	return false, nil
+}
+
+// route subscribes on the router of the Kafka topic, no consumer group is created.
+func (c *KafkaSupport) route(topic string, group string, callback func(message string)) (func(), error) {
+	name, key := c.kafkaTopic(topic)
+	router, err := c.getRouter(name, key != "")
+	if err != nil {
+		return nil, err
+	}
+	return router.add(key, group, callback), nil
+}
+
+// routeSync waits one message of the router up to the timeout. Returns (isTimeout, error).
+func (c *KafkaSupport) routeSync(topic string, group string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
+	received := make(chan string, 1)
+	remove, err := c.route(topic, group, func(message string) {
+		select {
+		case received <- message:
+		default:
+		}
+	})
+	if err != nil {
+		return true, err
+	}
+	defer remove()
+	select {
+	case message := <-received:
+		callback(message, nil)
+		return false, nil
+	case <-time.After(time.Duration(opts.Timeout_second) * time.Second):
+		return true, nil
+	}
+}
+
+// Interface from BrokerConnectionInterface
+// The group of a topic with its own Kafka topic is the Kafka consumer group, so only one worker of the group
+// receives the message. The task topic is only listened by the worker running the task, the group picks one
+// subscriber of the connection.
+func (c *KafkaSupport) Sub(topic string, group_id string, callback func(message string)) (func(), error) {
+	if group_id == "" {
+		return c.BasicSub(topic, callback)
+	}
+	return c.subs.Subscribe(topic, func() (func(), error) {
+		if isKafkaTaskTopic(topic) {
+			return c.route(topic, group_id, callback)
+		}
+		return c.consume(topic, group_id, callback)
+	})
+}
+
+// Interface from BrokerConnectionInterface
+func (c *KafkaSupport) SubSync(uuidItem string, group_id string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
+	if group_id != "" && !isKafkaTaskTopic(uuidItem) {
+		return c.consumeSync(uuidItem, group_id, callback, opts)
+	}
+	return c.routeSync(uuidItem, group_id, callback, opts)
+}
+
+// Interface from BrokerConnectionInterface
+func (c *KafkaSupport) BasicSub(topic string, callback func(message string)) (func(), error) {
+	return c.subs.Subscribe(topic, func() (func(), error) {
+		return c.route(topic, "", callback)
+	})
+}
+
+// Interface from BrokerConnectionInterface
+func (c *KafkaSupport) BasicSubSync(topic string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
+	return c.routeSync(topic, "", callback, opts)
+}
+
+// GetBrokers returns the address of the broker, brokers or host and port.
+func (c *KafkaSupport) GetBrokers() []string {
+	return c.brokers
+}
+
+// Interface from BrokerConnectionInterface
+func (c *KafkaSupport) SetKey_P(key string) {
+	c.key = key
+}
+
+// Interface from BrokerConnectionInterface
+func (c *KafkaSupport) GetKey_P() string {
+	return c.key
+}
+
+// Interface from BrokerConnectionInterface
+func (c *KafkaSupport) GetBroker_P() any {
+	return c
+}
+
+// Interface from BrokerConnectionInterface
+func (c *KafkaSupport) IsConnected() bool {
+	return c.writer != nil && c.subs.IsConnected()
+}
+
+// Interface from BrokerConnectionInterface
+// Every group reader is closed by its subscription, the router and the writer are left.
+func (c *KafkaSupport) Close() {
+	c.subs.Close()
+	c.routersMu.Lock()
+	for name, router := range c.routers {
+		router.close()
+		delete(c.routers, name)
+	}
+	c.routersMu.Unlock()
+	if c.writer != nil {
+		c.writer.Close()
+	}
+}
+
+// Interface from SupportInterface
+func (c *KafkaSupport) GetObject() any {
+	return c
+}
+
+type kafkaPendingMessage struct {
+	msg     kafka.Message
+	done    bool
+	tracker *kafkaOffsetTracker
+}
+
+// kafkaOffsetTracker commits the offset per partition in order,
+// the offset is committed only when every earlier message of the partition is done.
+type kafkaOffsetTracker struct {
+	mutex   sync.Mutex
+	reader  *kafka.Reader
+	pending map[int][]*kafkaPendingMessage
+}
+
+func (t *kafkaOffsetTracker) add(msg kafka.Message) *kafkaPendingMessage {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	p := &kafkaPendingMessage{msg: msg, tracker: t}
+	t.pending[msg.Partition] = append(t.pending[msg.Partition], p)
+	return p
+}
+
+func (t *kafkaOffsetTracker) done(p *kafkaPendingMessage) {
+	t.mutex.Lock()
+	p.done = true
+	list := t.pending[p.msg.Partition]
+	var last *kafkaPendingMessage
+	for len(list) > 0 && list[0].done {
+		last = list[0]
+		list = list[1:]
+	}
+	t.pending[p.msg.Partition] = list
+	t.mutex.Unlock()
+
+	if last != nil {
+		if err := t.reader.CommitMessages(context.Background(), last.msg); err != nil {
+			fmt.Println("Kafka commit offset error :: ", err)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package support
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaRouter reads every partition of one Kafka topic without consumer group and gives the message
+// to the subscriber of its key. It is opened once per connection and topic, so a subscription added
+// later only registers its callback and does not miss the message published during a group join.
+// Only the events topic is keyed, the message of the other topic goes to every subscriber.
+type kafkaRouter struct {
+	topic   string
+	keyed   bool
+	mutex   sync.Mutex
+	subs    map[string][]*kafkaRouterSub
+	next    map[string]int
+	cancel  context.CancelFunc
+	readers []*kafka.Reader
+}
+
+type kafkaRouterSub struct {
+	group    string
+	callback func(message string)
+}
+
+// getRouter returns the router of the Kafka topic, opened on first use.
+func (c *KafkaSupport) getRouter(topic string, keyed bool) (*kafkaRouter, error) {
+	c.routersMu.Lock()
+	defer c.routersMu.Unlock()
+	if router, ok := c.routers[topic]; ok {
+		return router, nil
+	}
+	router, err := c.openRouter(topic, keyed)
+	if err != nil {
+		return nil, err
+	}
+	c.routers[topic] = router
+	return router, nil
+}
+
+// openRouter starts a reader on every partition at its last offset.
+// The offset is read before it returns, so a message published after is never skipped.
+func (c *KafkaSupport) openRouter(topic string, keyed bool) (*kafkaRouter, error) {
+	partitions, err := c.ensureTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	router := &kafkaRouter{
+		topic:  topic,
+		keyed:  keyed,
+		subs:   map[string][]*kafkaRouterSub{},
+		next:   map[string]int{},
+		cancel: cancel,
+	}
+	for _, partition := range partitions {
+		offset, err := c.lastOffset(topic, partition.ID)
+		if err != nil {
+			router.close()
+			return nil, err
+		}
+		reader := kafka.NewReader(kafka.ReaderConfig{
+			Brokers:   c.brokers,
+			Topic:     topic,
+			Partition: partition.ID,
+			Dialer:    c.dialer,
+			MaxWait:   time.Second,
+		})
+		if err := reader.SetOffset(offset); err != nil {
+			reader.Close()
+			router.close()
+			return nil, err
+		}
+		router.readers = append(router.readers, reader)
+		go router.read(ctx, reader)
+	}
+	return router, nil
+}
+
+// dial opens a connection to the first broker that answers.
+func (c *KafkaSupport) dial(ctx context.Context) (*kafka.Conn, error) {
+	var err error
+	for _, broker := range c.brokers {
+		var conn *kafka.Conn
+		conn, err = c.dialer.DialContext(ctx, "tcp", broker)
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// ensureTopic returns the partitions of the topic, the topic is created with the default
+// partition and replication of the cluster when it does not exist yet.
+func (c *KafkaSupport) ensureTopic(topic string) ([]kafka.Partition, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	created := false
+	for {
+		partitions, err := conn.ReadPartitions(topic)
+		if err == nil && len(partitions) > 0 {
+			return partitions, nil
+		}
+		if err != nil && !errors.Is(err, kafka.UnknownTopicOrPartition) && !errors.Is(err, kafka.LeaderNotAvailable) {
+			return nil, err
+		}
+		if !created {
+			created = true
+			client := &kafka.Client{Addr: kafka.TCP(c.brokers...), Transport: c.writer.Transport}
+			res, err := client.CreateTopics(ctx, &kafka.CreateTopicsRequest{
+				Topics: []kafka.TopicConfig{{Topic: topic, NumPartitions: -1, ReplicationFactor: -1}},
+			})
+			if err != nil {
+				return nil, err
+			}
+			if err := res.Errors[topic]; err != nil && !errors.Is(err, kafka.TopicAlreadyExists) {
+				return nil, fmt.Errorf("create topic %s: %w", topic, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("topic %s has no partition: %w", topic, ctx.Err())
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// lastOffset returns the offset the next message of the partition gets.
+func (c *KafkaSupport) lastOffset(topic string, partition int) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var err error
+	for _, broker := range c.brokers {
+		var leader *kafka.Conn
+		leader, err = c.dialer.DialLeader(ctx, "tcp", broker, topic, partition)
+		if err != nil {
+			continue
+		}
+		offset, err := leader.ReadLastOffset()
+		leader.Close()
+		return offset, err
+	}
+	return 0, err
+}
+
+func (r *kafkaRouter) read(ctx context.Context, reader *kafka.Reader) {
+	for {
+		m, err := reader.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Println("Kafka read message error :: ", r.topic, " :: ", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		key := ""
+		if r.keyed {
+			key = string(m.Key)
+		}
+		r.dispatch(key, string(m.Value))
+	}
+}
+
+// dispatch calls every subscriber of the key without group and one subscriber of each group, in turn.
+// The callback runs on the reader of the partition, so the message of one key keeps its order.
+func (r *kafkaRouter) dispatch(key string, message string) {
+	r.mutex.Lock()
+	callbacks := []func(message string){}
+	groups := map[string][]*kafkaRouterSub{}
+	for _, sub := range r.subs[key] {
+		if sub.group == "" {
+			callbacks = append(callbacks, sub.callback)
+			continue
+		}
+		groups[sub.group] = append(groups[sub.group], sub)
+	}
+	for group, subs := range groups {
+		index := r.next[key+"\x00"+group] % len(subs)
+		r.next[key+"\x00"+group] = index + 1
+		callbacks = append(callbacks, subs[index].callback)
+	}
+	r.mutex.Unlock()
+	for _, callback := range callbacks {
+		callback(message)
+	}
+}
+
+// add registers the callback on the key, the returned function removes it and is safe to call twice.
+func (r *kafkaRouter) add(key string, group string, callback func(message string)) func() {
+	sub := &kafkaRouterSub{group: group, callback: callback}
+	r.mutex.Lock()
+	r.subs[key] = append(r.subs[key], sub)
+	r.mutex.Unlock()
+	return func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		for i, v := range r.subs[key] {
+			if v == sub {
+				r.subs[key] = append(r.subs[key][:i:i], r.subs[key][i+1:]...)
+				break
+			}
+		}
+		if len(r.subs[key]) == 0 {
+			delete(r.subs, key)
+			for name := range r.next {
+				if strings.HasPrefix(name, key+"\x00") {
+					delete(r.next, name)
+				}
+			}
+		}
+	}
+}
+
+func (r *kafkaRouter) close() {
+	r.cancel()
+	for _, reader := range r.readers {
+		reader.Close()
+	}
+}
@@ -0,0 +1,90 @@
+package support_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	support "job_item/support"
+	support_helper "job_item/support/helper"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// docker run -d --name kafka -p 9092:9092 apache/kafka:3.7.0
+// JOB_ITEM_TEST_KAFKA=127.0.0.1:9092 go test ./support -run Kafka
+func TestKafkaBroker(t *testing.T) {
+	factory, opts := brokerFromEnv(t, "KAFKA", map[string]interface{}{
+		"type": "kafka",
+	})
+	// The consumer group of the queue group check takes a few second to join
+	opts.Settle = 5 * time.Second
+	opts.Wait = 10 * time.Second
+	runBrokerTest(t, factory, opts)
+}
+
+// The task topic goes on the events topic in order and never becomes a Kafka topic.
+func TestKafkaTaskTopic(t *testing.T) {
+	factory, _ := brokerFromEnv(t, "KAFKA", map[string]interface{}{
+		"type": "kafka",
+	})
+	conn, err := factory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	taskId, _ := support_helper.GenerateUUIDv7()
+	mutex := sync.Mutex{}
+	received := []string{}
+	unsubscribe, err := conn.BasicSub(support.TopicTaskProcess(taskId), func(message string) {
+		mutex.Lock()
+		received = append(received, message)
+		mutex.Unlock()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	total := 20
+	for i := range total {
+		conn.Pub(support.TopicTaskProcess(taskId), fmt.Sprint("line-", i))
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		mutex.Lock()
+		count := len(received)
+		mutex.Unlock()
+		if count >= total {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(received) != total {
+		t.Fatalf("received %d message, want %d", len(received), total)
+	}
+	for i, message := range received {
+		if message != fmt.Sprint("line-", i) {
+			t.Fatalf("message %d is %q, the order is lost", i, message)
+		}
+	}
+
+	admin, err := kafka.Dial("tcp", conn.GetBroker_P().(*support.KafkaSupport).GetBrokers()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	partitions, err := admin.ReadPartitions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, partition := range partitions {
+		if partition.Topic == support.KafkaTopicName("", support.TopicTaskProcess(taskId)) {
+			t.Fatalf("the task topic %s is created on the cluster", partition.Topic)
+		}
+	}
+}
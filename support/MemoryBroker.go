@@ -0,0 +1,228 @@
+package support
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MemoryBrokerServer is an in-process stand-in for a message broker.
+// Every MemorySupport created on the same server shares the topics, so it can
+// play several workers of one queue group.
+func MemoryBrokerServerConstruct() *MemoryBrokerServer {
+	return &MemoryBrokerServer{
+		subs:   map[int]*memorySubscription{},
+		groups: map[string]int{},
+	}
+}
+
+type memorySubscription struct {
+	client   *MemorySupport
+	topic    string
+	group    string
+	callback func(message string)
+}
+
+type MemoryBrokerServer struct {
+	mutex   sync.Mutex
+	lastId  int
+	subs    map[int]*memorySubscription
+	clients []*MemorySupport
+	// Round robin position by "<topic>\x00<group>"
+	groups map[string]int
+}
+
+func (c *MemoryBrokerServer) subscribe(client *MemorySupport, topic string, group string, callback func(message string)) func() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lastId++
+	id := c.lastId
+	c.subs[id] = &memorySubscription{client: client, topic: topic, group: group, callback: callback}
+	return func() {
+		c.mutex.Lock()
+		delete(c.subs, id)
+		c.mutex.Unlock()
+	}
+}
+
+// publish delivers the message to every subscription without group
+// and to one subscription of each group.
+func (c *MemoryBrokerServer) publish(topic string, msg string) {
+	c.mutex.Lock()
+	callbacks := []func(message string){}
+	groups := map[string][]*memorySubscription{}
+	for id := 1; id <= c.lastId; id++ {
+		sub, ok := c.subs[id]
+		if !ok || sub.topic != topic || !sub.client.IsConnected() {
+			continue
+		}
+		if sub.group == "" {
+			callbacks = append(callbacks, sub.callback)
+			continue
+		}
+		groups[sub.group] = append(groups[sub.group], sub)
+	}
+	for group, subs := range groups {
+		position := c.groups[topic+"\x00"+group]
+		callbacks = append(callbacks, subs[position%len(subs)].callback)
+		c.groups[topic+"\x00"+group] = position + 1
+	}
+	c.mutex.Unlock()
+
+	for _, callback := range callbacks {
+		go callback(msg)
+	}
+}
+
+// Restart drops every client connection and subscription like a broker restart,
+// then lets the clients connect again.
+func (c *MemoryBrokerServer) Restart() {
+	c.mutex.Lock()
+	c.subs = map[int]*memorySubscription{}
+	clients := append([]*MemorySupport{}, c.clients...)
+	c.mutex.Unlock()
+
+	for _, client := range clients {
+		client.subs.SetConnected(false)
+	}
+	for _, client := range clients {
+		client.reconnect()
+	}
+}
+
+// MemorySupportConstruct creates a broker connection on the memory server.
+func MemorySupportConstruct(server *MemoryBrokerServer, key string) *MemorySupport {
+	gg := MemorySupport{
+		server: server,
+		outbox: BrokerOutboxConstruct(key),
+		subs:   BrokerSubscriptionRegistryConstruct(key),
+	}
+	server.mutex.Lock()
+	server.clients = append(server.clients, &gg)
+	server.mutex.Unlock()
+	gg.subs.SetConnected(true)
+	return &gg
+}
+
+// MemorySupport is a BrokerConnectionInterface on a MemoryBrokerServer,
+// for the broker contract check and for running a job without a broker.
+type MemorySupport struct {
+	server *MemoryBrokerServer
+	key    string
+	outbox *BrokerOutbox
+	subs   *BrokerSubscriptionRegistry
+	// OnPublish is called with every published message, after the delivery
+	OnPublish func(topic string, msg string)
+}
+
+func (c *MemorySupport) GetRefreshPubSub() string {
+	return BROKER_REFRESH_PUBSUB
+}
+
+func (c *MemorySupport) reconnect() {
+	c.subs.Resubscribe()
+	c.outbox.Flush(c.publish)
+	c.subs.SetConnected(true)
+	if Helper != nil && Helper.EventBus != nil {
+		Helper.EventBus.GetBus().Publish(BROKER_REFRESH_PUBSUB, nil)
+	}
+}
+
+// Interface from BrokerConnectionInterface
+func (c *MemorySupport) Pub(topic string, msg string) {
+	c.outbox.Publish(c.IsConnected(), topic, msg, c.publish)
+}
+
+func (c *MemorySupport) publish(topic string, msg string) error {
+	if !c.IsConnected() {
+		return errors.New("memory broker is not connected")
+	}
+	c.server.publish(topic, msg)
+	if c.OnPublish != nil {
+		c.OnPublish(topic, msg)
+	}
+	return nil
+}
+
+// subscribeSync waits one message up to the timeout. Returns (isTimeout, error).
+func (c *MemorySupport) subscribeSync(topic string, group string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
+	msgCh := make(chan string, 1)
+	unsubscribe := c.server.subscribe(c, topic, group, func(message string) {
+		select {
+		case msgCh <- message:
+		default:
+		}
+	})
+	defer unsubscribe()
+	select {
+	case msg := <-msgCh:
+		fmt.Printf("\n unSubscribeFinish: %s\n", msg)
+		callback(msg, nil)
+		return false, nil
+	case <-time.After(time.Duration(opts.Timeout_second) * time.Second):
+		return true, nil
+	}
+}
+
+// Interface from BrokerConnectionInterface
+func (c *MemorySupport) Sub(topic string, group_id string, callback func(message string)) (func(), error) {
+	return c.subs.Subscribe(topic, func() (func(), error) {
+		return c.server.subscribe(c, topic, group_id, callback), nil
+	})
+}
+
+// Interface from BrokerConnectionInterface
+func (c *MemorySupport) SubSync(uuidItem string, group_id string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
+	return c.subscribeSync(uuidItem, group_id, callback, opts)
+}
+
+// Interface from BrokerConnectionInterface
+func (c *MemorySupport) BasicSub(topic string, callback func(message string)) (func(), error) {
+	return c.subs.Subscribe(topic, func() (func(), error) {
+		return c.server.subscribe(c, topic, "", callback), nil
+	})
+}
+
+// Interface from BrokerConnectionInterface
+func (c *MemorySupport) BasicSubSync(topic string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
+	return c.subscribeSync(topic, "", callback, opts)
+}
+
+// Interface from BrokerConnectionInterface
+func (c *MemorySupport) SetKey_P(key string) {
+	c.key = key
+}
+
+// Interface from BrokerConnectionInterface
+func (c *MemorySupport) GetKey_P() string {
+	return c.key
+}
+
+// Interface from BrokerConnectionInterface
+func (c *MemorySupport) GetBroker_P() any {
+	return c
+}
+
+// Interface from BrokerConnectionInterface
+func (c *MemorySupport) IsConnected() bool {
+	return c.subs.IsConnected()
+}
+
+// Interface from BrokerConnectionInterface
+func (c *MemorySupport) Close() {
+	c.subs.Close()
+	c.server.mutex.Lock()
+	for i, client := range c.server.clients {
+		if client == c {
+			c.server.clients = append(c.server.clients[:i], c.server.clients[i+1:]...)
+			break
+		}
+	}
+	c.server.mutex.Unlock()
+}
+
+// Interface from SupportInterface
+func (c *MemorySupport) GetObject() any {
+	return c
+}
@@ -0,0 +1,20 @@
+package support_test
+
+import (
+	"testing"
+
+	support "job_item/support"
+	"job_item/support/brokertest"
+)
+
+func TestMemoryBroker(t *testing.T) {
+	server := support.MemoryBrokerServerConstruct()
+	runBrokerTest(t, func() (support.BrokerConnectionInterface, error) {
+		return support.MemorySupportConstruct(server, "memory"), nil
+	}, brokertest.Options{
+		Restart: func() error {
+			server.Restart()
+			return nil
+		},
+	})
+}
@@ -0,0 +1,283 @@
+package support
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	support_helper "job_item/support/helper"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func init() {
+	RegisterBrokerType("mqtt", BrokerTypeRegistration{
+		Fields: []ConfigField{
+			{Name: "host", Kind: CONFIG_KIND_STRING, Required: true},
+			{Name: "port", Kind: CONFIG_KIND_NUMBER, Required: true},
+			{Name: "user", Kind: CONFIG_KIND_STRING},
+			{Name: "password", Kind: CONFIG_KIND_STRING},
+			{Name: "client_id", Kind: CONFIG_KIND_STRING},
+		},
+		NewConnection: func() BrokerConInterface {
+			return &MqttBrokerConnection{}
+		},
+		Construct: func(conn BrokerConInterface) (BrokerConnectionInterface, error) {
+			return MqttSupportConstruct(conn.GetConnection().(MqttBrokerConnection))
+		},
+	})
+}
+
+func MqttSupportConstruct(props MqttBrokerConnection) (*MqttSupport, error) {
+	gg := MqttSupport{
+		mqttConfInfo: props,
+		outbox:       BrokerOutboxConstruct(props.Key),
+		subs:         BrokerSubscriptionRegistryConstruct(props.Key),
+	}
+	err := gg.ConnectPubSub()
+	return &gg, err
+}
+
+type MqttSupport struct {
+	client       mqtt.Client
+	mqttConfInfo MqttBrokerConnection
+	key          string
+	outbox       *BrokerOutbox
+	subs         *BrokerSubscriptionRegistry
+	// Set by the first OnConnect, the handler runs on the goroutine of paho
+	hasConnected atomic.Bool
+}
+
+func (c *MqttSupport) GetRefreshPubSub() string {
+	return BROKER_REFRESH_PUBSUB
+}
+
+func (c *MqttSupport) tlsConfig() (*tls.Config, error) {
+	if !c.mqttConfInfo.Secure {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{}
+	if c.mqttConfInfo.CAFile != "" {
+		caCert, err := os.ReadFile(c.mqttConfInfo.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		caCertPool := x509.NewCertPool()
+		caCertPool.AppendCertsFromPEM(caCert)
+		tlsConfig.RootCAs = caCertPool
+	}
+	if c.mqttConfInfo.CertFile != "" && c.mqttConfInfo.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.mqttConfInfo.CertFile, c.mqttConfInfo.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client cert/key: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func (c *MqttSupport) ConnectPubSub() error {
+	scheme := "tcp://"
+	if c.mqttConfInfo.Secure {
+		scheme = "ssl://"
+	}
+	url := scheme + c.mqttConfInfo.Host + ":" + strconv.Itoa(c.mqttConfInfo.Port)
+	fmt.Println("MQTT Connection inf :: ", url)
+
+	clientId := c.mqttConfInfo.Client_id
+	if clientId == "" {
+		uuid, err := support_helper.GenerateUUIDv7()
+		if err != nil {
+			return err
+		}
+		clientId = "job_item_" + uuid
+	}
+
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(url)
+	opts.SetClientID(clientId)
+	opts.SetUsername(c.mqttConfInfo.User)
+	opts.SetPassword(c.mqttConfInfo.Password)
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+	opts.SetAutoReconnect(true)
+	opts.SetMaxReconnectInterval(30 * time.Second)
+	// With clean session the broker forgets the subscription on reconnect,
+	// the subscription registry subscribes them again on the connect handler.
+	opts.SetCleanSession(true)
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		if c.hasConnected.Swap(true) {
+			fmt.Println("Reconnected to MQTT server:", url)
+			go func() {
+				c.subs.Resubscribe()
+				c.outbox.Flush(c.publish)
+				c.subs.SetConnected(true)
+				Helper.EventBus.GetBus().Publish(BROKER_REFRESH_PUBSUB, nil)
+			}()
+			return
+		}
+		c.subs.SetConnected(true)
+	})
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		fmt.Println("Disconnected from MQTT server, attempting to reconnect:", err)
+		c.subs.SetConnected(false)
+	})
+
+	c.client = mqtt.NewClient(opts)
+
+	// Retry mechanism
+	for {
+		token := c.client.Connect()
+		token.Wait()
+		if token.Error() == nil {
+			break
+		}
+		fmt.Println("MQTT connection failed, retrying in 5-10 seconds:", token.Error().Error())
+		time.Sleep(5 * time.Second) // Delay before retrying
+	}
+	fmt.Println("Successfully connected to MQTT server - main")
+	return nil
+}
+
+// mqttQos returns QoS 0 for telemetry and QoS 1 for the job and status topics.
+func mqttQos(topic string) byte {
+	if GetOutboxClass(topic) == OUTBOX_CLASS_TELEMETRY {
+		return 0
+	}
+	return 1
+}
+
+// mqttSharedTopic returns the shared subscription filter so only one worker of the group receives the message.
+func mqttSharedTopic(topic string, group string) string {
+	topic = BrokerTopicName("mqtt", topic)
+	if group == "" {
+		return topic
+	}
+	return "$share/" + group + "/" + topic
+}
+
+// Interface from BrokerConnectionInterface
+func (c *MqttSupport) Pub(topic string, msg string) {
+	c.outbox.Publish(c.IsConnected(), topic, msg, c.publish)
+}
+
+func (c *MqttSupport) publish(topic string, msg string) error {
+	if c.client == nil {
+		return errors.New("mqtt client is not connected")
+	}
+	token := c.client.Publish(BrokerTopicName("mqtt", topic), mqttQos(topic), false, msg)
+	token.Wait()
+	return token.Error()
+}
+
+func (c *MqttSupport) subscribe(filter string, callback func(message string)) (func(), error) {
+	token := c.client.Subscribe(filter, 1, func(client mqtt.Client, msg mqtt.Message) {
+		callback(string(msg.Payload()))
+	})
+	token.Wait()
+	if token.Error() != nil {
+		return nil, token.Error()
+	}
+	return func() {
+		c.client.Unsubscribe(filter).Wait()
+	}, nil
+}
+
+// Interface from BrokerConnectionInterface
+// The group become a shared subscription $share/<group>/<topic>.
+func (c *MqttSupport) Sub(topic string, group_id string, callback func(message string)) (func(), error) {
+	filter := mqttSharedTopic(topic, group_id)
+	return c.subs.Subscribe(filter, func() (func(), error) {
+		return c.subscribe(filter, callback)
+	})
+}
+
+// subscribeSync waits one message on the filter up to the timeout. Returns (isTimeout, error).
+func (c *MqttSupport) subscribeSync(filter string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
+	msgCh := make(chan string, 1)
+	token := c.client.Subscribe(filter, 1, func(client mqtt.Client, msg mqtt.Message) {
+		select {
+		case msgCh <- string(msg.Payload()):
+		default:
+		}
+	})
+	token.Wait()
+	if token.Error() != nil {
+		return true, token.Error()
+	}
+	defer c.client.Unsubscribe(filter).Wait()
+
+	select {
+	case msg := <-msgCh:
+		fmt.Printf("\n unSubscribeFinish: %s\n", msg)
+		callback(msg, nil)
+		return false, nil
+	case <-time.After(time.Duration(opts.Timeout_second) * time.Second):
+		return true, nil
+	}
+}
+
+// Interface from BrokerConnectionInterface
+func (c *MqttSupport) SubSync(uuidItem string, group_id string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
+	return c.subscribeSync(mqttSharedTopic(uuidItem, group_id), callback, opts)
+}
+
+// Interface from BrokerConnectionInterface
+func (c *MqttSupport) BasicSub(topic string, callback func(message string)) (func(), error) {
+	filter := mqttSharedTopic(topic, "")
+	return c.subs.Subscribe(filter, func() (func(), error) {
+		return c.subscribe(filter, callback)
+	})
+}
+
+// Interface from BrokerConnectionInterface
+func (c *MqttSupport) BasicSubSync(topic string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
+	return c.subscribeSync(mqttSharedTopic(topic, ""), callback, opts)
+}
+
+// Interface from BrokerConnectionInterface
+func (c *MqttSupport) SetKey_P(key string) {
+	c.key = key
+}
+
+// Interface from BrokerConnectionInterface
+func (c *MqttSupport) GetKey_P() string {
+	return c.key
+}
+
+// Interface from BrokerConnectionInterface
+func (c *MqttSupport) GetBroker_P() any {
+	return c
+}
+
+// Interface from BrokerConnectionInterface
+func (c *MqttSupport) IsConnected() bool {
+	if c.client == nil || !c.client.IsConnectionOpen() {
+		return false
+	}
+	return true
+}
+
+// Interface from BrokerConnectionInterface
+func (c *MqttSupport) Close() {
+	c.subs.Close()
+	if c.client != nil {
+		// Disconnect stops the auto reconnect
+		c.client.Disconnect(250)
+	}
+}
+
+// Interface from SupportInterface
+func (c *MqttSupport) GetObject() any {
+	return c
+}
@@ -0,0 +1,11 @@
+package support_test
+
+import "testing"
+
+// JOB_ITEM_TEST_MQTT=127.0.0.1:1883 go test ./support -run TestMqttBroker
+func TestMqttBroker(t *testing.T) {
+	factory, opts := brokerFromEnv(t, "MQTT", map[string]interface{}{
+		"type": "mqtt",
+	})
+	runBrokerTest(t, factory, opts)
+}
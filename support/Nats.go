@@ -2,13 +2,74 @@ package support
 
 import (
 	"fmt"
-	"log"
 	"strconv"
+	"strings"
 	"time"
 
 	nats "github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
 )
 
+func init() {
+	RegisterBrokerType("nats", BrokerTypeRegistration{
+		Fields: []ConfigField{
+			{Name: "host", Kind: CONFIG_KIND_STRING},
+			{Name: "port", Kind: CONFIG_KIND_NUMBER},
+			{Name: "servers", Kind: CONFIG_KIND_LIST},
+			{Name: "auth_type", Kind: CONFIG_KIND_STRING, Required: true},
+			{Name: "user", Kind: CONFIG_KIND_STRING},
+			{Name: "password", Kind: CONFIG_KIND_STRING},
+			{Name: "token", Kind: CONFIG_KIND_STRING},
+			{Name: "nkey_seed", Kind: CONFIG_KIND_STRING},
+			{Name: "nkey_file", Kind: CONFIG_KIND_STRING},
+			{Name: "creds", Kind: CONFIG_KIND_STRING},
+			{Name: "creds_file", Kind: CONFIG_KIND_STRING},
+		},
+		Validate: func(path string, v map[string]interface{}, errs *ConfigErrors) {
+			if !configHas(v, "servers") && !(configHas(v, "host") && configHas(v, "port")) {
+				errs.add(path+".host", "host and port or servers is required")
+			}
+			authType := configString(v, "auth_type")
+			switch authType {
+			case "none":
+			case "token":
+				if !configHas(v, "token") {
+					errs.add(path+".token", "is required for auth_type token")
+				}
+			case "user_password", "user_password_bcrypt":
+				if !configHas(v, "user") || !configHas(v, "password") {
+					errs.add(path+".user", "user and password are required for auth_type %s", authType)
+				}
+			case "nkey":
+				if !configHasOne(v, "nkey_seed", "nkey_file") {
+					errs.add(path+".nkey_seed", "nkey_seed or nkey_file is required for auth_type nkey")
+				}
+			case "creds":
+				if !configHasOne(v, "creds", "creds_file") {
+					errs.add(path+".creds", "creds or creds_file is required for auth_type creds")
+				}
+			default:
+				errs.add(path+".auth_type", "expected one of none, token, user_password, nkey, creds, got %q", authType)
+			}
+			if isTrue(v["secure"]) && !configHas(v, "ca_file") {
+				errs.add(path+".ca_file", "is required when secure is true")
+			}
+		},
+		// The nkey seed and the .creds file can come with the TLS download bundle
+		BundleFiles: []string{"nkey_file", "creds_file"},
+		NewConnection: func() BrokerConInterface {
+			return &NatsBrokerConnection{}
+		},
+		Construct: func(conn BrokerConInterface) (BrokerConnectionInterface, error) {
+			natSupport, err := NatsSupportConstruct(conn.GetConnection().(NatsBrokerConnection))
+			if err != nil {
+				return nil, err
+			}
+			return &natSupport, nil
+		},
+	})
+}
+
 type NatsConfInfo struct {
 	NATS_HOST string
 	NATS_PORT int
@@ -17,6 +78,8 @@ type NatsConfInfo struct {
 func NatsSupportConstruct(props NatsBrokerConnection) (NatsSupport, error) {
 	gg := NatsSupport{
 		natConfInfo: props,
+		outbox:      BrokerOutboxConstruct(props.Key),
+		subs:        BrokerSubscriptionRegistryConstruct(props.Key),
 	}
 	err := gg.ConnectPubSub()
 	return gg, err
@@ -30,43 +93,114 @@ type NatsSupport struct {
 	nc          *nats.Conn
 	natConfInfo NatsBrokerConnection
 	key         string
+	outbox      *BrokerOutbox
+	subs        *BrokerSubscriptionRegistry
 }
 
-func (c *NatsSupport) ConnectPubSub() error {
-	natsHost := c.natConfInfo.Host
-	natsPort := c.natConfInfo.Port
-	natsAuthType := c.natConfInfo.Auth_type
-	natsUser := c.natConfInfo.User
-	natsPassword := c.natConfInfo.Password
-	natsToken := c.natConfInfo.Token
+// serverUrls returns the server list of the cluster, or the host and port.
+func (c *NatsSupport) serverUrls() []string {
+	urls := []string{}
+	for _, server := range c.natConfInfo.Servers {
+		if !strings.Contains(server, "://") {
+			server = "nats://" + server
+		}
+		urls = append(urls, server)
+	}
+	if c.natConfInfo.Host != "" {
+		urls = append(urls, "nats://"+c.natConfInfo.Host+":"+strconv.Itoa(c.natConfInfo.Port))
+	}
+	return urls
+}
 
-	// Connect to a server
-	url := fmt.Sprint("nats://" + natsHost + ":" + strconv.Itoa(natsPort))
-	switch natsAuthType {
+// authOptions returns the nats.go option of the auth_type.
+// The credential never goes into the URL so it never shows up in the log.
+func (c *NatsSupport) authOptions() ([]nats.Option, error) {
+	conf := c.natConfInfo
+	switch conf.Auth_type {
+	case "", "none":
+		return nil, nil
 	case "token":
-		url = fmt.Sprint("nats://" + natsToken + "@" + natsHost + ":" + strconv.Itoa(natsPort))
-	case "user_password":
-		url = fmt.Sprint("nats://" + natsUser + ":" + natsPassword + "@" + natsHost + ":" + strconv.Itoa(natsPort))
-	case "user_password_bcrypt":
-		url = fmt.Sprint("nats://" + natsUser + ":" + natsPassword + "@" + natsHost + ":" + strconv.Itoa(natsPort))
+		return []nats.Option{nats.Token(conf.Token)}, nil
+	case "user_password", "user_password_bcrypt":
+		return []nats.Option{nats.UserInfo(conf.User, conf.Password)}, nil
+	case "nkey":
+		if conf.Nkey_file != "" {
+			opt, err := nats.NkeyOptionFromSeed(conf.Nkey_file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read nkey_file: %w", err)
+			}
+			return []nats.Option{opt}, nil
+		}
+		if conf.Nkey_seed == "" {
+			return nil, fmt.Errorf("NATS auth_type nkey needs nkey_seed or nkey_file")
+		}
+		kp, err := nkeys.FromSeed([]byte(conf.Nkey_seed))
+		if err != nil {
+			return nil, fmt.Errorf("invalid nkey_seed: %w", err)
+		}
+		publicKey, err := kp.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		return []nats.Option{nats.Nkey(publicKey, kp.Sign)}, nil
+	case "creds":
+		if conf.Creds_file != "" {
+			return []nats.Option{nats.UserCredentials(conf.Creds_file)}, nil
+		}
+		if conf.Creds == "" {
+			return nil, fmt.Errorf("NATS auth_type creds needs creds or creds_file")
+		}
+		userJwt, err := nkeys.ParseDecoratedJWT([]byte(conf.Creds))
+		if err != nil {
+			return nil, fmt.Errorf("invalid creds: %w", err)
+		}
+		kp, err := nkeys.ParseDecoratedNKey([]byte(conf.Creds))
+		if err != nil {
+			return nil, fmt.Errorf("invalid creds: %w", err)
+		}
+		return []nats.Option{nats.UserJWT(func() (string, error) {
+			return userJwt, nil
+		}, kp.Sign)}, nil
+	}
+	return nil, fmt.Errorf("unsupported NATS auth_type: %s", conf.Auth_type)
+}
+
+func (c *NatsSupport) ConnectPubSub() error {
+	// Connect to a server
+	url := strings.Join(c.serverUrls(), ",")
+	fmt.Println("Nats Connection inf :: ", url, "auth_type:", c.natConfInfo.Auth_type)
+
+	authOpts, err := c.authOptions()
+	if err != nil {
+		return err
 	}
-	fmt.Println("Nats Connection inf :: ", url)
 
 	// Build connection options
 	var opts []nats.Option
 	opts = append(opts,
 		nats.MaxReconnects(-1),
 		nats.ReconnectWait(5*time.Second),
+		// nats.go picks the first server at random and learns the other node of the cluster
+		nats.DiscoveredServersHandler(func(nc *nats.Conn) {
+			fmt.Println("Discovered NATS servers:", strings.Join(nc.DiscoveredServers(), ", "))
+		}),
 		nats.ReconnectHandler(func(nc *nats.Conn) {
-			fmt.Println("Reconnected to NATS server:", nc.ConnectedUrl())
+			fmt.Println("Reconnected to NATS server:", nc.ConnectedUrlRedacted())
+			// nats.go subscribes again by itself, only the health state and the outbox are handled here
+			c.subs.SetConnected(true)
+			// Send the message queued while disconnected
+			go c.outbox.Flush(c.publish)
 		}),
 		nats.DisconnectHandler(func(nc *nats.Conn) {
 			fmt.Println("Disconnected from NATS server, attempting to reconnect...")
+			c.subs.SetConnected(false)
 		}),
 		nats.ClosedHandler(func(nc *nats.Conn) {
 			fmt.Println("Connection to NATS server closed, attempting to reconnect...")
+			c.subs.SetConnected(false)
 		}),
 	)
+	opts = append(opts, authOpts...)
 	// Add TLS option if Secure is true
 	if c.natConfInfo.Secure {
 		if c.natConfInfo.CAFile == "" {
@@ -81,7 +215,6 @@ func (c *NatsSupport) ConnectPubSub() error {
 	}
 
 	// Retry mechanism
-	var err error
 	for {
 		nc, connErr := nats.Connect(url, opts...)
 		if connErr == nil {
@@ -93,37 +226,46 @@ func (c *NatsSupport) ConnectPubSub() error {
 		time.Sleep(5 * time.Second) // Delay before retrying
 		err = connErr
 	}
-	fmt.Println("Successfully reconnected to NATS server - main")
+	fmt.Println("Successfully reconnected to NATS server - main", c.nc.ConnectedUrlRedacted())
+	c.subs.SetConnected(true)
 	// Wanna tester add publish at below
 
 	return err
 }
 
 // Interface from BrokerConnectionInterface
+// While disconnected the message is queued on the outbox and flushed in order after reconnect.
 func (c *NatsSupport) Pub(topic string, msg string) {
-	c.nc.Publish(topic, []byte(msg))
+	c.outbox.Publish(c.IsConnected(), topic, msg, c.publish)
+}
+
+func (c *NatsSupport) publish(topic string, msg string) error {
+	if c.nc == nil {
+		return fmt.Errorf("connection is not open")
+	}
+	return c.nc.Publish(BrokerTopicName("nats", topic), []byte(msg))
 }
 
 // Interface from BrokerConnectionInterface
 func (c *NatsSupport) Sub(topic string, group_id string, callback func(message string)) (func(), error) {
-	unsubribce, err := c.nc.QueueSubscribe(topic, group_id, func(msg *nats.Msg) {
-		callback(string(msg.Data))
+	return c.subs.Subscribe(topic, func() (func(), error) {
+		unsubribce, err := c.nc.QueueSubscribe(BrokerTopicName("nats", topic), group_id, func(msg *nats.Msg) {
+			callback(string(msg.Data))
+		})
+		if err != nil {
+			return nil, err
+		}
+		return func() {
+			unsubribce.Unsubscribe()
+		}, nil
 	})
-	if err != nil {
-		log.Fatal(err)
-		return nil, err
-	}
-	return func() {
-		unsubribce.Unsubscribe()
-	}, nil
 }
 
 // Interface from BrokerConnectionInterface
 // Improved: Waits for a message up to the specified duration. Returns (isTimeout, error).
 func (c *NatsSupport) SubSync(uuidItem string, group_id string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
-	unsubribce, err := c.nc.QueueSubscribeSync(uuidItem, group_id)
+	unsubribce, err := c.nc.QueueSubscribeSync(BrokerTopicName("nats", uuidItem), group_id)
 	if err != nil {
-		log.Fatal(err)
 		return true, err
 	}
 
@@ -158,23 +300,23 @@ func (c *NatsSupport) SubSync(uuidItem string, group_id string, callback func(me
 
 // Interface from BrokerConnectionInterface
 func (c *NatsSupport) BasicSub(topic string, callback func(message string)) (func(), error) {
-	unsubribce, err := c.nc.Subscribe(topic, func(msg *nats.Msg) {
-		callback(string(msg.Data))
+	return c.subs.Subscribe(topic, func() (func(), error) {
+		unsubribce, err := c.nc.Subscribe(BrokerTopicName("nats", topic), func(msg *nats.Msg) {
+			callback(string(msg.Data))
+		})
+		if err != nil {
+			return nil, err
+		}
+		return func() {
+			unsubribce.Unsubscribe()
+		}, nil
 	})
-	if err != nil {
-		log.Fatal(err)
-		return nil, err
-	}
-	return func() {
-		unsubribce.Unsubscribe()
-	}, nil
 }
 
 // Interface from BrokerConnectionInterface
 func (c *NatsSupport) BasicSubSync(uuidItem string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
-	unsubribce, err := c.nc.SubscribeSync(uuidItem)
+	unsubribce, err := c.nc.SubscribeSync(BrokerTopicName("nats", uuidItem))
 	if err != nil {
-		log.Fatal(err)
 		return true, err
 	}
 
@@ -207,6 +349,14 @@ func (c *NatsSupport) BasicSubSync(uuidItem string, callback func(message string
 	return true, nil
 }
 
+// Interface from BrokerServerInfoInterface
+func (c *NatsSupport) GetConnectedServer() string {
+	if c.nc == nil {
+		return ""
+	}
+	return c.nc.ConnectedUrlRedacted()
+}
+
 // Interface from BrokerConnectionInterface
 func (c *NatsSupport) SetKey_P(key string) {
 	c.key = key
@@ -229,3 +379,11 @@ func (c *NatsSupport) IsConnected() bool {
 	}
 	return true
 }
+
+// Interface from BrokerConnectionInterface
+func (c *NatsSupport) Close() {
+	c.subs.Close()
+	if c.nc != nil {
+		c.nc.Close()
+	}
+}
@@ -0,0 +1,12 @@
+package support_test
+
+import "testing"
+
+// JOB_ITEM_TEST_NATS=127.0.0.1:4222 go test ./support -run TestNatsBroker
+func TestNatsBroker(t *testing.T) {
+	factory, opts := brokerFromEnv(t, "NATS", map[string]interface{}{
+		"type":      "nats",
+		"auth_type": "none",
+	})
+	runBrokerTest(t, factory, opts)
+}
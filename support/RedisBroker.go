@@ -11,9 +11,62 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+func init() {
+	RegisterBrokerType("redis", BrokerTypeRegistration{
+		Fields: []ConfigField{
+			{Name: "mode", Kind: CONFIG_KIND_STRING},
+			{Name: "host", Kind: CONFIG_KIND_STRING},
+			{Name: "port", Kind: CONFIG_KIND_NUMBER},
+			{Name: "master_name", Kind: CONFIG_KIND_STRING},
+			{Name: "sentinel_addrs", Kind: CONFIG_KIND_LIST},
+			{Name: "sentinel_password", Kind: CONFIG_KIND_STRING},
+			{Name: "cluster_addrs", Kind: CONFIG_KIND_LIST},
+			{Name: "username", Kind: CONFIG_KIND_STRING},
+			{Name: "password", Kind: CONFIG_KIND_STRING, Required: true},
+			{Name: "db", Kind: CONFIG_KIND_NUMBER},
+		},
+		Validate: func(path string, v map[string]interface{}, errs *ConfigErrors) {
+			switch configString(v, "mode") {
+			case "", REDIS_MODE_STANDALONE:
+				if !configHas(v, "host") || !configHas(v, "port") {
+					errs.add(path+".host", "host and port are required")
+				}
+				if !configHas(v, "db") {
+					errs.add(path+".db", "is required")
+				}
+			case REDIS_MODE_SENTINEL:
+				if !configHas(v, "master_name") {
+					errs.add(path+".master_name", "is required for mode sentinel")
+				}
+				if len(configStringList(v["sentinel_addrs"])) == 0 {
+					errs.add(path+".sentinel_addrs", "is required for mode sentinel")
+				}
+				if !configHas(v, "db") {
+					errs.add(path+".db", "is required")
+				}
+			case REDIS_MODE_CLUSTER:
+				// Redis Cluster has only db 0
+				if len(configStringList(v["cluster_addrs"])) == 0 {
+					errs.add(path+".cluster_addrs", "is required for mode cluster")
+				}
+			default:
+				errs.add(path+".mode", "expected one of standalone, sentinel, cluster, got %q", configString(v, "mode"))
+			}
+		},
+		NewConnection: func() BrokerConInterface {
+			return &RedisBrokerConnection{}
+		},
+		Construct: func(conn BrokerConInterface) (BrokerConnectionInterface, error) {
+			return NewRedisSupportConstruct(conn.GetConnection().(RedisBrokerConnection))
+		},
+	})
+}
+
 type RedisSupport struct {
-	client *redis.Client
+	client redis.UniversalClient
 	key    string
+	outbox *BrokerOutbox
+	subs   *BrokerSubscriptionRegistry
 }
 
 func NewRedisSupportConstruct(config RedisBrokerConnection) (*RedisSupport, error) {
@@ -38,27 +91,99 @@ func NewRedisSupportConstruct(config RedisBrokerConnection) (*RedisSupport, erro
 			}
 		}
 	}
-	client := redis.NewClient(&redis.Options{
-		Addr:      fmt.Sprintf("%s:%d", config.Host, config.Port),
-		Password:  config.Password, // no password set
-		DB:        config.Db,       // use default DB
-		TLSConfig: tlsConfig,
-	})
+	var client redis.UniversalClient
+	switch config.Mode {
+	case REDIS_MODE_SENTINEL:
+		// The failover client asks the sentinel for the master and follows the failover
+		client = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       config.Master_name,
+			SentinelAddrs:    config.Sentinel_addrs,
+			SentinelPassword: config.Sentinel_password,
+			Username:         config.Username,
+			Password:         config.Password,
+			DB:               config.Db,
+			TLSConfig:        tlsConfig,
+		})
+	case REDIS_MODE_CLUSTER:
+		// PUBLISH is broadcast to every node of the cluster so pub/sub works from any node,
+		// the lock key lives on the node of its slot
+		client = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     config.Cluster_addrs,
+			Username:  config.Username,
+			Password:  config.Password,
+			TLSConfig: tlsConfig,
+		})
+	default:
+		client = redis.NewClient(&redis.Options{
+			Addr:      fmt.Sprintf("%s:%d", config.Host, config.Port),
+			Username:  config.Username,
+			Password:  config.Password, // no password set
+			DB:        config.Db,       // use default DB
+			TLSConfig: tlsConfig,
+		})
+	}
 	if err := client.Ping(context.Background()).Err(); err != nil {
 		fmt.Println("Failed to connect to Redis:", err)
+		client.Close()
 		return nil, err
 	}
-	return &RedisSupport{
+	gg := &RedisSupport{
 		client: client,
 		key:    config.Key,
-	}, nil
+		outbox: BrokerOutboxConstruct(config.Key),
+		subs:   BrokerSubscriptionRegistryConstruct(config.Key),
+	}
+	gg.subs.SetConnected(true)
+	go gg.monitorConnection()
+	return gg, nil
 }
 
+// monitorConnection pings the server periodically to keep the health state accurate.
+// go-redis reconnects and subscribes the pubsub again by itself, after the connection is back
+// the queued message is flushed and refresh_pubsub is published like the other broker.
+func (r *RedisSupport) monitorConnection() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if r.subs.IsClosed() {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		err := r.client.Ping(ctx).Err()
+		cancel()
+		if err != nil {
+			if r.subs.IsConnected() {
+				fmt.Println("Disconnected from Redis server, attempting to reconnect:", err)
+			}
+			r.subs.SetConnected(false)
+			continue
+		}
+		if !r.subs.IsConnected() {
+			fmt.Println("Successfully reconnected to Redis server")
+			r.outbox.Flush(r.publish)
+			r.subs.SetConnected(true)
+			Helper.EventBus.GetBus().Publish(BROKER_REFRESH_PUBSUB, nil)
+		}
+	}
+}
+
+// Pub queues the message on the outbox when the publish failed,
+// the queue is flushed in order on the next successful publish.
 func (r *RedisSupport) Pub(topic string, msg string) {
-	r.client.Publish(context.Background(), topic, msg)
+	r.outbox.Publish(r.IsConnected(), topic, msg, r.publish)
+}
+
+func (r *RedisSupport) publish(topic string, msg string) error {
+	return r.client.Publish(context.Background(), topic, msg).Err()
 }
 
 func (r *RedisSupport) Sub(uuidItem string, group string, callback func(message string)) (func(), error) {
+	return r.subs.Subscribe(uuidItem, func() (func(), error) {
+		return r.subscribe(uuidItem, group, callback)
+	})
+}
+
+func (r *RedisSupport) subscribe(uuidItem string, group string, callback func(message string)) (func(), error) {
 	pubsub := r.client.Subscribe(context.Background(), uuidItem)
 	go func() {
 		for msg := range pubsub.Channel() {
@@ -87,25 +212,25 @@ func (r *RedisSupport) Sub(uuidItem string, group string, callback func(message
 func (r *RedisSupport) SubSync(uuidItem string, group string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
 	pubsub := r.client.Subscribe(context.Background(), uuidItem)
 	defer pubsub.Close()
-	select {
-	case msg := <-pubsub.Channel():
-		lockKey := fmt.Sprintf("lock:%s:%s:%x", uuidItem, group, msg.Payload)
-		ok, err := r.client.SetNX(context.Background(), lockKey, "1", 10*time.Second).Result()
-		if err != nil {
-			callback("", fmt.Errorf("Redis lock error: %v", err))
-			return true, err
-		}
-		if ok {
-			callback(msg.Payload, nil)
-			return false, nil
-		} else {
-			// Did not get lock, skip
-			callback("", fmt.Errorf("lock not acquired"))
+	timeout := time.After(time.Duration(opts.Timeout_second) * time.Second)
+	for {
+		select {
+		case msg := <-pubsub.Channel():
+			lockKey := fmt.Sprintf("lock:%s:%s:%x", uuidItem, group, msg.Payload)
+			ok, err := r.client.SetNX(context.Background(), lockKey, "1", 10*time.Second).Result()
+			if err != nil {
+				callback("", fmt.Errorf("Redis lock error: %v", err))
+				return true, err
+			}
+			if ok {
+				callback(msg.Payload, nil)
+				return false, nil
+			}
+			// Another worker of the group got it, wait for the next one
+		case <-timeout:
+			// Like the other brokers, a timeout is only the returned flag, the callback is for a message
 			return true, nil
 		}
-	case <-time.After(time.Duration(opts.Timeout_second) * time.Second):
-		callback("", fmt.Errorf("timeout"))
-		return true, nil
 	}
 }
 
@@ -122,7 +247,7 @@ func (r *RedisSupport) GetKey_P() string {
 }
 
 func (r *RedisSupport) IsConnected() bool {
-	return r.client != nil
+	return r.client != nil && r.subs.IsConnected()
 }
 
 func (c *RedisSupport) GetRefreshPubSub() string {
@@ -130,13 +255,15 @@ func (c *RedisSupport) GetRefreshPubSub() string {
 }
 
 func (r *RedisSupport) BasicSub(topic string, callback func(message string)) (func(), error) {
-	pubsub := r.client.Subscribe(context.Background(), topic)
-	go func() {
-		for msg := range pubsub.Channel() {
-			callback(msg.Payload)
-		}
-	}()
-	return func() { pubsub.Close() }, nil
+	return r.subs.Subscribe(topic, func() (func(), error) {
+		pubsub := r.client.Subscribe(context.Background(), topic)
+		go func() {
+			for msg := range pubsub.Channel() {
+				callback(msg.Payload)
+			}
+		}()
+		return func() { pubsub.Close() }, nil
+	})
 }
 
 func (r *RedisSupport) BasicSubSync(topic string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
@@ -144,6 +271,12 @@ func (r *RedisSupport) BasicSubSync(topic string, callback func(message string,
 	return gg, err
 }
 
+// Interface from BrokerConnectionInterface
+func (r *RedisSupport) Close() {
+	r.subs.Close()
+	r.client.Close()
+}
+
 // Interface from SupportInterface
 func (r *RedisSupport) GetObject() any {
 	return r
@@ -0,0 +1,16 @@
+package support_test
+
+import (
+	"os"
+	"testing"
+)
+
+// JOB_ITEM_TEST_REDIS=127.0.0.1:6379 go test ./support -run TestRedisBroker
+func TestRedisBroker(t *testing.T) {
+	factory, opts := brokerFromEnv(t, "REDIS", map[string]interface{}{
+		"type":     "redis",
+		"password": os.Getenv("JOB_ITEM_TEST_REDIS_PASSWORD"),
+		"db":       0,
+	})
+	runBrokerTest(t, factory, opts)
+}
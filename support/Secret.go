@@ -0,0 +1,196 @@
+package support
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"job_item/src/helper"
+)
+
+// A config value can be a reference to a secret instead of the secret itself:
+//   - secret://file/secrets/db_password   the content of the file, relative to config.yaml
+//   - secret://file//run/secrets/db       the content of the absolute file
+//   - secret://env/DB_PASSWORD            the environment variable
+//   - secret://vault/secret/app#password  the field of a Vault KV secret
+//
+// The reference is resolved when the config is loaded. The resolved value is hidden
+// from the log and is not passed to a job or exec process unless it asks for it
+// on its own env.
+const SECRET_SCHEME = "secret://"
+
+// SecretProvider resolves the reference after "secret://<name>/".
+type SecretProvider interface {
+	Resolve(ref string) (string, error)
+}
+
+// SecretProviderFunc lets a function be a SecretProvider.
+type SecretProviderFunc func(ref string) (string, error)
+
+func (f SecretProviderFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+var secretProviders = map[string]SecretProvider{}
+var secretProvidersMutex sync.RWMutex
+
+// RegisterSecretProvider registers the provider of "secret://<name>/...", the last one registered wins.
+func RegisterSecretProvider(name string, provider SecretProvider) {
+	secretProvidersMutex.Lock()
+	defer secretProvidersMutex.Unlock()
+	secretProviders[name] = provider
+}
+
+func getSecretProvider(name string) (SecretProvider, bool) {
+	secretProvidersMutex.RLock()
+	defer secretProvidersMutex.RUnlock()
+	provider, ok := secretProviders[name]
+	return provider, ok
+}
+
+func getSecretProviderNames() []string {
+	secretProvidersMutex.RLock()
+	defer secretProvidersMutex.RUnlock()
+	names := []string{}
+	for name := range secretProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterSecretProvider("file", SecretProviderFunc(func(ref string) (string, error) {
+		content, err := os.ReadFile(filepath.Clean(ref))
+		if err != nil {
+			return "", err
+		}
+		// Like the docker secret, the trailing newline is not part of the secret
+		return strings.TrimRight(string(content), "\r\n"), nil
+	}))
+	RegisterSecretProvider("env", SecretProviderFunc(func(ref string) (string, error) {
+		value, ok := os.LookupEnv(ref)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", ref)
+		}
+		return value, nil
+	}))
+}
+
+// IsSecretRef returns true when the value is a "secret://" reference.
+func IsSecretRef(value string) bool {
+	return strings.HasPrefix(value, SECRET_SCHEME)
+}
+
+// ResolveSecret returns the secret of the reference and remembers it to hide it from the log.
+func ResolveSecret(value string) (string, error) {
+	name, ref, provider, err := parseSecretRef(value)
+	if err != nil {
+		return "", err
+	}
+	secret, err := provider.Resolve(ref)
+	if err != nil {
+		return "", fmt.Errorf("secret provider %s: %w", name, err)
+	}
+	RegisterSecretValue(secret)
+	return secret, nil
+}
+
+// parseSecretRef returns the provider name, the reference and the provider of a "secret://" value.
+func parseSecretRef(value string) (string, string, SecretProvider, error) {
+	name, ref, ok := strings.Cut(strings.TrimPrefix(value, SECRET_SCHEME), "/")
+	if !ok || ref == "" {
+		return "", "", nil, fmt.Errorf("expected secret://<provider>/<reference>, got %q", value)
+	}
+	provider, ok := getSecretProvider(name)
+	if !ok {
+		return "", "", nil, fmt.Errorf("unknown secret provider %q, expected one of %s", name, strings.Join(getSecretProviderNames(), ", "))
+	}
+	return name, ref, provider, nil
+}
+
+// Every resolved secret value, to hide it from the log
+var secretValues sync.Map
+
+// RegisterSecretValue hides the value from the log.
+func RegisterSecretValue(value string) {
+	// A very short value would hide every log line
+	if len(value) < 4 {
+		return
+	}
+	secretValues.Store(value, true)
+}
+
+// IsSecretValue returns true when the value is a resolved secret.
+func IsSecretValue(value string) bool {
+	_, ok := secretValues.Load(value)
+	return ok
+}
+
+// MaskSecrets replaces every resolved secret inside the text with "******".
+func MaskSecrets(text string) string {
+	secretValues.Range(func(key, _ any) bool {
+		text = strings.ReplaceAll(text, key.(string), "******")
+		return true
+	})
+	return text
+}
+
+// resolveSecrets resolves every "secret://" value of the config.
+// The path of the resolved value is kept to tell the child process which value is a secret.
+func (c *ConfigYamlSupport) resolveSecrets() error {
+	errs := ConfigErrors{}
+	resolve := func(v interface{}, prefix string) {
+		helper.WalkStrings(v, func(path string, value string) string {
+			if !IsSecretRef(value) {
+				return value
+			}
+			secret, err := ResolveSecret(value)
+			if err != nil {
+				errs.add(prefix+path, "%s", err.Error())
+				return value
+			}
+			c.secret_paths = append(c.secret_paths, prefix+path)
+			return secret
+		})
+	}
+	// The Vault token can come from a file or the environment
+	resolve(&c.ConfigData.Secrets, "secrets.")
+	if len(errs) > 0 {
+		return errs
+	}
+	RegisterSecretProvider("vault", VaultSecretProviderConstruct(c.ConfigData.Secrets.Vault))
+	resolve(&c.ConfigData, "")
+	if len(errs) > 0 {
+		return errs
+	}
+	// The child process gets the resolved config, the main process tells which value is a secret
+	known := map[string]bool{}
+	for _, path := range c.secret_paths {
+		known[path] = true
+	}
+	helper.WalkStrings(&c.ConfigData, func(path string, value string) string {
+		if known[path] {
+			RegisterSecretValue(value)
+		}
+		return value
+	})
+	return nil
+}
+
+// GetProcessEnv returns the environment of the worker for a job or exec process, without
+// the config handoff of the child process and without the variable holding a secret, like the one read by secret://env.
+func GetProcessEnv() []string {
+	env := []string{}
+	for _, v := range os.Environ() {
+		name, value, _ := strings.Cut(v, "=")
+		if name == CONFIG_HANDOFF_ENV || IsSecretValue(value) {
+			continue
+		}
+		env = append(env, v)
+	}
+	return env
+}
@@ -0,0 +1,133 @@
+package support
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VaultSecretConfig is the secrets.vault of the config, an empty field uses the Vault environment variable.
+type VaultSecretConfig struct {
+	// Default VAULT_ADDR, like http://127.0.0.1:8200
+	Address string `yaml:"address"`
+	// Default VAULT_TOKEN
+	Token string `yaml:"token"`
+	// Default VAULT_NAMESPACE, Vault Enterprise only
+	Namespace string `yaml:"namespace"`
+	// Version of the KV secrets engine, 1 or 2 (default)
+	Kv_version int `yaml:"kv_version"`
+}
+
+type SecretsConfig struct {
+	Vault VaultSecretConfig `yaml:"vault"`
+}
+
+// VaultSecretProviderConstruct creates the provider of secret://vault/<mount>/<path>#<field>.
+func VaultSecretProviderConstruct(config VaultSecretConfig) *VaultSecretProvider {
+	if config.Address == "" {
+		config.Address = os.Getenv("VAULT_ADDR")
+	}
+	if config.Token == "" {
+		config.Token = os.Getenv("VAULT_TOKEN")
+	}
+	if config.Namespace == "" {
+		config.Namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+	if config.Kv_version == 0 {
+		config.Kv_version = 2
+	}
+	return &VaultSecretProvider{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+		cache:  map[string]map[string]interface{}{},
+	}
+}
+
+type VaultSecretProvider struct {
+	config VaultSecretConfig
+	client *http.Client
+	mutex  sync.Mutex
+	// Data of the secret by "<mount>/<path>", several field of one secret are read once
+	cache map[string]map[string]interface{}
+}
+
+// Interface from SecretProvider
+func (c *VaultSecretProvider) Resolve(ref string) (string, error) {
+	secretPath, field, ok := strings.Cut(ref, "#")
+	mount, path, hasPath := strings.Cut(secretPath, "/")
+	if !ok || field == "" || !hasPath || path == "" {
+		return "", fmt.Errorf("expected secret://vault/<mount>/<path>#<field>, got %q", ref)
+	}
+	data, err := c.read(mount, path)
+	if err != nil {
+		return "", err
+	}
+	value, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("field %q is not found on %s", field, secretPath)
+	}
+	if text, ok := value.(string); ok {
+		return text, nil
+	}
+	jsonValue, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonValue), nil
+}
+
+func (c *VaultSecretProvider) read(mount string, path string) (map[string]interface{}, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if data, ok := c.cache[mount+"/"+path]; ok {
+		return data, nil
+	}
+	if c.config.Address == "" || c.config.Token == "" {
+		return nil, errors.New("address and token are required, set secrets.vault or VAULT_ADDR and VAULT_TOKEN")
+	}
+
+	endpoint := fmt.Sprintf("%s/v1/%s/%s", strings.TrimRight(c.config.Address, "/"), mount, path)
+	if c.config.Kv_version == 2 {
+		endpoint = fmt.Sprintf("%s/v1/%s/data/%s", strings.TrimRight(c.config.Address, "/"), mount, path)
+	}
+	request, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("X-Vault-Token", c.config.Token)
+	if c.config.Namespace != "" {
+		request.Header.Set("X-Vault-Namespace", c.config.Namespace)
+	}
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body := struct {
+		Data   map[string]interface{} `json:"data"`
+		Errors []string               `json:"errors"`
+	}{}
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil && response.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("invalid response from %s: %w", endpoint, err)
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s %s", endpoint, response.Status, strings.Join(body.Errors, ", "))
+	}
+
+	data := body.Data
+	if c.config.Kv_version == 2 {
+		// KV version 2 wraps the secret with its metadata
+		data, _ = body.Data["data"].(map[string]interface{})
+		if data == nil {
+			return nil, fmt.Errorf("%s/%s is deleted or destroyed", mount, path)
+		}
+	}
+	c.cache[mount+"/"+path] = data
+	return data, nil
+}
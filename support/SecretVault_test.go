@@ -0,0 +1,121 @@
+package support_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	support "job_item/support"
+)
+
+// vaultTestServer answers the KV read like Vault, only with the token "root".
+func vaultTestServer(t *testing.T, kvVersion int, requests *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]any{"errors": []string{"permission denied"}})
+			return
+		}
+		if r.Header.Get("X-Vault-Namespace") != "team" {
+			t.Errorf("namespace header is %q, want team", r.Header.Get("X-Vault-Namespace"))
+		}
+		secret := map[string]any{"broker_password": "s3cret", "port": 5672}
+		switch {
+		case kvVersion == 2 && r.URL.Path == "/v1/secret/data/job_item":
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"data": secret, "metadata": map[string]any{"version": 1}}})
+		case kvVersion == 1 && r.URL.Path == "/v1/kv/job_item":
+			json.NewEncoder(w).Encode(map[string]any{"data": secret})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]any{"errors": []string{}})
+		}
+	}))
+}
+
+func TestVaultSecretProvider(t *testing.T) {
+	for _, kv := range []struct {
+		version int
+		mount   string
+	}{{2, "secret"}, {1, "kv"}} {
+		requests := atomic.Int32{}
+		server := vaultTestServer(t, kv.version, &requests)
+		provider := support.VaultSecretProviderConstruct(support.VaultSecretConfig{
+			Address:    server.URL,
+			Token:      "root",
+			Namespace:  "team",
+			Kv_version: kv.version,
+		})
+
+		value, err := provider.Resolve(kv.mount + "/job_item#broker_password")
+		if err != nil || value != "s3cret" {
+			t.Errorf("kv %d: Resolve = %q, %v, want s3cret", kv.version, value, err)
+		}
+		// A field that is not a string comes as JSON, the secret is read once
+		value, err = provider.Resolve(kv.mount + "/job_item#port")
+		if err != nil || value != "5672" {
+			t.Errorf("kv %d: Resolve port = %q, %v, want 5672", kv.version, value, err)
+		}
+		if requests.Load() != 1 {
+			t.Errorf("kv %d: %d request to Vault, want 1", kv.version, requests.Load())
+		}
+		if _, err := provider.Resolve(kv.mount + "/job_item#missing"); err == nil {
+			t.Errorf("kv %d: missing field returned no error", kv.version)
+		}
+		if _, err := provider.Resolve(kv.mount + "/other#field"); err == nil || !strings.Contains(err.Error(), "404") {
+			t.Errorf("kv %d: missing secret error = %v, want 404", kv.version, err)
+		}
+		server.Close()
+	}
+}
+
+func TestVaultSecretProviderError(t *testing.T) {
+	requests := atomic.Int32{}
+	server := vaultTestServer(t, 2, &requests)
+	defer server.Close()
+
+	for _, ref := range []string{"secret/job_item", "secret#field", "secret/#field"} {
+		provider := support.VaultSecretProviderConstruct(support.VaultSecretConfig{Address: server.URL, Token: "root"})
+		if _, err := provider.Resolve(ref); err == nil {
+			t.Errorf("Resolve(%q) returned no error", ref)
+		}
+	}
+	provider := support.VaultSecretProviderConstruct(support.VaultSecretConfig{Address: server.URL, Token: "wrong", Namespace: "team"})
+	if _, err := provider.Resolve("secret/job_item#broker_password"); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("wrong token error = %v, want permission denied", err)
+	}
+}
+
+// vault server -dev -dev-root-token-id=root
+// JOB_ITEM_TEST_VAULT=1 VAULT_ADDR=http://127.0.0.1:8200 VAULT_TOKEN=root go test ./support -run Vault
+func TestVaultSecretProviderDevServer(t *testing.T) {
+	if os.Getenv("JOB_ITEM_TEST_VAULT") == "" {
+		t.Skip("set JOB_ITEM_TEST_VAULT=1 with VAULT_ADDR and VAULT_TOKEN to run against a Vault dev server")
+	}
+	address := strings.TrimRight(os.Getenv("VAULT_ADDR"), "/")
+	body, _ := json.Marshal(map[string]any{"data": map[string]any{"broker_password": "dev-secret"}})
+	request, err := http.NewRequest("POST", address+"/v1/secret/data/job_item_test", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("write the test secret: %s", response.Status)
+	}
+
+	provider := support.VaultSecretProviderConstruct(support.VaultSecretConfig{})
+	value, err := provider.Resolve("secret/job_item_test#broker_password")
+	if err != nil || value != "dev-secret" {
+		t.Fatalf("Resolve = %q, %v, want dev-secret", value, err)
+	}
+}
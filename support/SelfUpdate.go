@@ -0,0 +1,291 @@
+package support
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UpdatePublicKey is the base64 ed25519 public key the update must be signed with, set at build time:
+//
+//	go build -ldflags "-X job_item/support.UpdatePublicKey=<base64>"
+//
+// A binary without the key never updates itself.
+var UpdatePublicKey = ""
+
+const DEFAULT_UPDATE_HEALTH_SECOND = 60
+
+// How often the /health of the new version is asked during update.health_second
+const UPDATE_HEALTH_POLL = time.Second
+
+// The child process serves its /health on this address, given by the main process
+const CHILD_HEALTH_ENV = "JOB_ITEM_CHILD_HEALTH_ADDR"
+
+// Address of the /health of the child process, by its *exec.Cmd
+var childHealthAddrs sync.Map
+
+// The previous executable is kept as <exe>.prev, the version rolled back is written in <exe>.rollback
+const UPDATE_PREV_SUFFIX = ".prev"
+const UPDATE_ROLLBACK_SUFFIX = ".rollback"
+
+// UpdateConfig is the update of the config.
+type UpdateConfig struct {
+	// Do not install the new version given by the Job Manager
+	Disabled bool `yaml:"disabled" json:"disabled,omitempty"`
+	// How long the child process of the new version must keep running and healthy, default 60.
+	// The previous version is restored when it exits or its /health is not 200 before
+	Health_second int `yaml:"health_second" json:"health_second,omitempty"`
+}
+
+func (c UpdateConfig) GetHealthDuration() time.Duration {
+	if c.Health_second <= 0 {
+		return DEFAULT_UPDATE_HEALTH_SECOND * time.Second
+	}
+	return time.Duration(c.Health_second) * time.Second
+}
+
+// UpdateSignedMessage returns what the signature of the update signs. The version is signed
+// with the checksum, so an older signed binary can not be served as a new version.
+func UpdateSignedMessage(versionNumber int, sha256Hex string) []byte {
+	return []byte(fmt.Sprintf("job_item update %d %s", versionNumber, strings.ToLower(sha256Hex)))
+}
+
+// VerifyUpdate checks the signature of the checksum and the version against UpdatePublicKey.
+func VerifyUpdate(versionNumber int, sha256Hex string, signature string) error {
+	if UpdatePublicKey == "" {
+		return errors.New("the binary is built without update public key, self-update is disabled")
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(UpdatePublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return errors.New("the update public key of the binary is not a base64 ed25519 public key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("the update signature is not base64: %w", err)
+	}
+	if !ed25519.Verify(publicKey, UpdateSignedMessage(versionNumber, sha256Hex), sig) {
+		return errors.New("the update signature does not match")
+	}
+	return nil
+}
+
+// GenerateUpdateKey returns a new base64 ed25519 key pair to sign the update.
+func GenerateUpdateKey() (string, string, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(publicKey), base64.StdEncoding.EncodeToString(privateKey), nil
+}
+
+// SignUpdate returns the SHA-256 hex of the binary and its signature with the base64 private key,
+// the value the Job Manager gives as job_item_sha256 and job_item_signature.
+func SignUpdate(privateKeyBase64 string, versionNumber int, path string) (string, string, error) {
+	privateKey, err := base64.StdEncoding.DecodeString(strings.TrimSpace(privateKeyBase64))
+	if err != nil || len(privateKey) != ed25519.PrivateKeySize {
+		return "", "", errors.New("the private key is not a base64 ed25519 private key")
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", "", err
+	}
+	sha256Hex := hex.EncodeToString(hash.Sum(nil))
+	signature := ed25519.Sign(ed25519.PrivateKey(privateKey), UpdateSignedMessage(versionNumber, sha256Hex))
+	return sha256Hex, base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// getExecutablePath returns the path of the running executable without symlink.
+func getExecutablePath() (string, error) {
+	executablePath, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(executablePath)
+}
+
+// getRolledBackVersion returns the last version rolled back, 0 without rollback.
+func getRolledBackVersion(executablePath string) int {
+	content, err := os.ReadFile(executablePath + UPDATE_ROLLBACK_SUFFIX)
+	if err != nil {
+		return 0
+	}
+	versionNumber, _ := strconv.Atoi(strings.TrimSpace(string(content)))
+	return versionNumber
+}
+
+// DownloadNewApp downloads the version given by the Job Manager next to the executable, checks its
+// SHA-256 and its signature, then replaces the executable and keeps the running one as <exe>.prev.
+// The child process started after it runs the new version. It returns false when nothing is installed.
+func (c *ConfigYamlSupport) DownloadNewApp(versionNumber int) (bool, error) {
+	if c.ConfigData.Update.Disabled {
+		c.printGroupName("Update is disabled, keep the running version")
+		return false, nil
+	}
+	executablePath, err := getExecutablePath()
+	if err != nil {
+		return false, err
+	}
+	if rolledBack := getRolledBackVersion(executablePath); versionNumber <= rolledBack {
+		c.printGroupName(fmt.Sprintf("Version %d was rolled back, wait for a newer version", rolledBack))
+		return false, nil
+	}
+	if c.ConfigData.Job_item_sha256 == "" || c.ConfigData.Job_item_signature == "" {
+		return false, errors.New("the new version has no job_item_sha256 or job_item_signature")
+	}
+	// Fail before the download when the binary can not verify anything
+	if UpdatePublicKey == "" {
+		return false, errors.New("the binary is built without update public key, self-update is disabled")
+	}
+
+	// Next to the executable so the rename stays on the same file system
+	out, err := os.CreateTemp(filepath.Dir(executablePath), filepath.Base(executablePath)+".*.new")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(out.Name())
+
+	resp, err := http.Get(c.ConfigData.Job_item_link)
+	if err != nil {
+		out.Close()
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		out.Close()
+		return false, fmt.Errorf("server returned non-200 status code: %d", resp.StatusCode)
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, hash), resp.Body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, err
+	}
+
+	sha256Hex := hex.EncodeToString(hash.Sum(nil))
+	if !strings.EqualFold(sha256Hex, c.ConfigData.Job_item_sha256) {
+		return false, fmt.Errorf("the SHA-256 of the download is %s, expected %s", sha256Hex, c.ConfigData.Job_item_sha256)
+	}
+	if err := VerifyUpdate(versionNumber, sha256Hex, c.ConfigData.Job_item_signature); err != nil {
+		return false, err
+	}
+	if err := os.Chmod(out.Name(), 0755); err != nil {
+		return false, err
+	}
+	if err := ReplaceApp(executablePath, out.Name()); err != nil {
+		return false, err
+	}
+	c.printGroupName(fmt.Sprintf("Version %d is installed, the previous one is kept as %s", versionNumber, executablePath+UPDATE_PREV_SUFFIX))
+	return true, nil
+}
+
+// RollbackApp restores <exe>.prev and remembers the version so it is not installed again.
+func (c *ConfigYamlSupport) RollbackApp(versionNumber int) error {
+	executablePath, err := getExecutablePath()
+	if err != nil {
+		return err
+	}
+	if err := RestorePreviousApp(executablePath); err != nil {
+		return err
+	}
+	c.printGroupName(fmt.Sprintf("Version %d is rolled back to the previous version", versionNumber))
+	return os.WriteFile(executablePath+UPDATE_ROLLBACK_SUFFIX, []byte(strconv.Itoa(versionNumber)), 0644)
+}
+
+// WaitUpdateHealth asks the /health of the child process of the new version every second during
+// update.health_second. It returns false when the child process exited, answered another status
+// than 200, or never answered 200 before the end. No answer yet is waited, the child is still starting.
+// isCurrent returns false once the child process was restarted on purpose, like for a config change,
+// then the check stops.
+func (c *ConfigYamlSupport) WaitUpdateHealth(cmd *exec.Cmd, isCurrent func() bool) bool {
+	exited := ProcessExited(cmd)
+	if exited == nil {
+		// Already exited
+		return !isCurrent()
+	}
+	healthAddr, _ := childHealthAddrs.Load(cmd)
+	healthUrl := fmt.Sprintf("http://%v/health", healthAddr)
+	client := &http.Client{Timeout: UPDATE_HEALTH_POLL}
+	deadline := time.After(c.GetConfigData().Update.GetHealthDuration())
+	ticker := time.NewTicker(UPDATE_HEALTH_POLL)
+	defer ticker.Stop()
+	healthy := false
+	for {
+		select {
+		case <-exited:
+			return !isCurrent()
+		case <-deadline:
+			if !healthy && isCurrent() {
+				c.printGroupName("The child process of the new version never answered 200 on " + healthUrl)
+				return false
+			}
+			return true
+		case <-ticker.C:
+			if !isCurrent() {
+				return true
+			}
+			response, err := client.Get(healthUrl)
+			if err != nil {
+				continue
+			}
+			response.Body.Close()
+			if response.StatusCode != http.StatusOK {
+				c.printGroupName(fmt.Sprintf("The child process of the new version answered %s on %s", response.Status, healthUrl))
+				return false
+			}
+			healthy = true
+		}
+	}
+}
+
+// reserveHealthAddr returns a free port on localhost for the /health of the child process.
+func reserveHealthAddr() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer listener.Close()
+	return listener.Addr().String(), nil
+}
+
+// copyFile copies the file with its permission.
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	stat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,166 @@
+package support
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyUpdate(t *testing.T) {
+	publicKey, privateKey, err := GenerateUpdateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary := filepath.Join(t.TempDir(), "job_item")
+	if err := os.WriteFile(binary, []byte("new version"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	sha256Hex, signature, err := SignUpdate(privateKey, 4, binary)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	defer func(key string) { UpdatePublicKey = key }(UpdatePublicKey)
+	UpdatePublicKey = ""
+	if err := VerifyUpdate(4, sha256Hex, signature); err == nil {
+		t.Errorf("verified without public key")
+	}
+
+	UpdatePublicKey = publicKey
+	if err := VerifyUpdate(4, sha256Hex, signature); err != nil {
+		t.Errorf("verify: %v", err)
+	}
+	// The checksum is compared without case
+	if err := VerifyUpdate(4, strings.ToUpper(sha256Hex), signature); err != nil {
+		t.Errorf("verify upper case checksum: %v", err)
+	}
+	// An older signed binary can not be served as a new version
+	if err := VerifyUpdate(5, sha256Hex, signature); err == nil {
+		t.Errorf("verified with another version")
+	}
+	tampered := []byte(sha256Hex)
+	tampered[0] ^= 1
+	if err := VerifyUpdate(4, string(tampered), signature); err == nil {
+		t.Errorf("verified with another checksum")
+	}
+	if err := VerifyUpdate(4, sha256Hex, "not base64!"); err == nil {
+		t.Errorf("verified with a bad signature")
+	}
+	otherPublicKey, _, _ := GenerateUpdateKey()
+	UpdatePublicKey = otherPublicKey
+	if err := VerifyUpdate(4, sha256Hex, signature); err == nil {
+		t.Errorf("verified with another public key")
+	}
+}
+
+// startHealthTestChild starts a process standing for the child process with its /health on the server.
+func startHealthTestChild(t *testing.T, server *httptest.Server) *exec.Cmd {
+	t.Helper()
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("sleep can not start: %v", err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill() })
+	if server != nil {
+		childHealthAddrs.Store(cmd, strings.TrimPrefix(server.URL, "http://"))
+	} else {
+		// Nothing listens there
+		addr, err := reserveHealthAddr()
+		if err != nil {
+			t.Fatal(err)
+		}
+		childHealthAddrs.Store(cmd, addr)
+	}
+	waitWithTimeout(cmd, 10*time.Millisecond)
+	return cmd
+}
+
+func healthTestServer(t *testing.T, status func() int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			t.Errorf("path is %s, want /health", r.URL.Path)
+		}
+		w.WriteHeader(status())
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWaitUpdateHealth(t *testing.T) {
+	c := &ConfigYamlSupport{}
+	c.ConfigData.Update.Health_second = 2
+	isCurrent := func() bool { return true }
+
+	healthy := startHealthTestChild(t, healthTestServer(t, func() int { return http.StatusOK }))
+	if !c.WaitUpdateHealth(healthy, isCurrent) {
+		t.Errorf("healthy child is rolled back")
+	}
+
+	degraded := startHealthTestChild(t, healthTestServer(t, func() int { return http.StatusServiceUnavailable }))
+	started := time.Now()
+	if c.WaitUpdateHealth(degraded, isCurrent) {
+		t.Errorf("child answering 503 is kept")
+	}
+	if time.Since(started) > 1900*time.Millisecond {
+		t.Errorf("503 is seen after %v, want on the first poll", time.Since(started))
+	}
+
+	// Healthy first, then the broker is lost
+	c.ConfigData.Update.Health_second = 4
+	answered := 0
+	lost := startHealthTestChild(t, healthTestServer(t, func() int {
+		answered++
+		if answered > 1 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	}))
+	if c.WaitUpdateHealth(lost, isCurrent) {
+		t.Errorf("child answering 503 after 200 is kept")
+	}
+
+	// Nothing answers on its /health
+	c.ConfigData.Update.Health_second = 2
+	silent := startHealthTestChild(t, nil)
+	if c.WaitUpdateHealth(silent, isCurrent) {
+		t.Errorf("child never answering is kept")
+	}
+
+	// Restarted on purpose, the check stops
+	if !c.WaitUpdateHealth(startHealthTestChild(t, nil), func() bool { return false }) {
+		t.Errorf("child restarted on purpose is rolled back")
+	}
+}
+
+func TestWaitUpdateHealthExited(t *testing.T) {
+	c := &ConfigYamlSupport{}
+	c.ConfigData.Update.Health_second = 10
+	cmd := startHealthTestChild(t, healthTestServer(t, func() int { return http.StatusOK }))
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		cmd.Process.Kill()
+	}()
+	if c.WaitUpdateHealth(cmd, func() bool { return true }) {
+		t.Errorf("exited child is kept")
+	}
+
+	// The exit entry is removed once the exit was seen
+	deadline := time.Now().Add(time.Second)
+	for ProcessExited(cmd) != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ProcessExited(cmd) != nil {
+		t.Errorf("process exit entry is kept")
+	}
+	if _, ok := childHealthAddrs.Load(cmd); ok {
+		t.Errorf("health address entry is kept")
+	}
+	if c.WaitUpdateHealth(cmd, func() bool { return true }) {
+		t.Errorf("already exited child is kept")
+	}
+}
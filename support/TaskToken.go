@@ -0,0 +1,73 @@
+package support
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// A task token lets a job call the Job Manager for its own task without the project secret key.
+// The token is "<payload>.<signature>", both base64url without padding:
+//   - payload   {"project_id": "...", "task_id": "...", "exp": <unix second>}
+//   - signature HMAC-SHA256 of the payload text with the project secret key
+//
+// The Job Manager checks it with the secret key it already knows, like VerifyTaskToken does.
+type TaskTokenClaims struct {
+	Project_id string `json:"project_id"`
+	Task_id    string `json:"task_id"`
+	Exp        int64  `json:"exp"`
+}
+
+const DEFAULT_TASK_TOKEN_TTL = 24 * time.Hour
+
+var ErrTaskTokenInvalid = errors.New("invalid task token")
+var ErrTaskTokenExpired = errors.New("task token expired")
+
+// NewTaskToken signs a token for the task valid for ttl.
+func NewTaskToken(secretKey string, projectId string, taskId string, ttl time.Duration) string {
+	claims, _ := json.Marshal(TaskTokenClaims{
+		Project_id: projectId,
+		Task_id:    taskId,
+		Exp:        time.Now().Add(ttl).Unix(),
+	})
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	return payload + "." + signTaskToken(secretKey, payload)
+}
+
+// VerifyTaskToken checks the signature and the expiry and returns the claims.
+func VerifyTaskToken(secretKey string, token string) (TaskTokenClaims, error) {
+	claims := TaskTokenClaims{}
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(signTaskToken(secretKey, payload))) {
+		return claims, ErrTaskTokenInvalid
+	}
+	content, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return claims, ErrTaskTokenInvalid
+	}
+	if err := json.Unmarshal(content, &claims); err != nil {
+		return claims, ErrTaskTokenInvalid
+	}
+	if time.Now().Unix() > claims.Exp {
+		return claims, ErrTaskTokenExpired
+	}
+	return claims, nil
+}
+
+func signTaskToken(secretKey string, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// GetTaskTokenTtl returns the lifetime of the task token of the config.
+func (c JobEnvConfig) GetTaskTokenTtl() time.Duration {
+	if c.Token_ttl_second <= 0 {
+		return DEFAULT_TASK_TOKEN_TTL
+	}
+	return time.Duration(c.Token_ttl_second) * time.Second
+}
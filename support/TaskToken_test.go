@@ -0,0 +1,53 @@
+package support_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	support "job_item/support"
+)
+
+func TestTaskToken(t *testing.T) {
+	token := support.NewTaskToken("key", "project", "task", time.Minute)
+	claims, err := support.VerifyTaskToken("key", token)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if claims.Project_id != "project" || claims.Task_id != "task" {
+		t.Fatalf("claims are %+v", claims)
+	}
+
+	if _, err := support.VerifyTaskToken("other", token); !errors.Is(err, support.ErrTaskTokenInvalid) {
+		t.Errorf("wrong key: got %v, want %v", err, support.ErrTaskTokenInvalid)
+	}
+
+	// The payload of another task keeps the signature of the first one
+	other := support.NewTaskToken("key", "project", "other", time.Minute)
+	payload, _, _ := strings.Cut(other, ".")
+	_, signature, _ := strings.Cut(token, ".")
+	if _, err := support.VerifyTaskToken("key", payload+"."+signature); !errors.Is(err, support.ErrTaskTokenInvalid) {
+		t.Errorf("tampered payload: got %v, want %v", err, support.ErrTaskTokenInvalid)
+	}
+
+	for _, bad := range []string{"", "no-dot", token + "x"} {
+		if _, err := support.VerifyTaskToken("key", bad); !errors.Is(err, support.ErrTaskTokenInvalid) {
+			t.Errorf("token %q: got %v, want %v", bad, err, support.ErrTaskTokenInvalid)
+		}
+	}
+
+	expired := support.NewTaskToken("key", "project", "task", -time.Minute)
+	if _, err := support.VerifyTaskToken("key", expired); !errors.Is(err, support.ErrTaskTokenExpired) {
+		t.Errorf("expired: got %v, want %v", err, support.ErrTaskTokenExpired)
+	}
+}
+
+func TestTaskTokenTtl(t *testing.T) {
+	if ttl := (support.JobEnvConfig{}).GetTaskTokenTtl(); ttl != support.DEFAULT_TASK_TOKEN_TTL {
+		t.Errorf("default ttl is %v, want %v", ttl, support.DEFAULT_TASK_TOKEN_TTL)
+	}
+	if ttl := (support.JobEnvConfig{Token_ttl_second: 60}).GetTaskTokenTtl(); ttl != time.Minute {
+		t.Errorf("ttl is %v, want 1m", ttl)
+	}
+}
@@ -0,0 +1,159 @@
+package support
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Every topic the worker and the Job Manager exchange is built here.
+//
+// The logical name follows the existing convention:
+//   - <project_uuid>.<event>   job event
+//   - <project_uuid>.restart   restart the child process
+//   - <project_uuid>.config_update  fetch the config again and apply the change in place
+//   - <task_id>_who            host id of the worker taking the task
+//   - <task_id>_worker         timeout / terminate action from the Job Manager
+//   - <task_id>_listen         ping from the Job Manager, answered on <task_id>_listen.callback
+//   - <task_id>_process        output of the task
+//   - <task_id>_finish         last status of the task
+//   - <task_id>_failed         error of the task
+//   - <task_id>.notif_add      notification of the task
+//   - <identity_id>.shutdown   shutdown the main process
+//   - listen_<kind>_information hardware telemetry
+//
+// topic.prefix and topic.namespace are put in front of the logical name, so several
+// projects or staging and production can share one broker. The Job Manager must use
+// the same prefix and namespace.
+type TopicConfig struct {
+	// Usually the organization or the project
+	Prefix string `yaml:"prefix" json:"prefix,omitempty"`
+	// Usually the environment, like staging or production
+	Namespace string `yaml:"namespace" json:"namespace,omitempty"`
+}
+
+// GetTopicConfig returns the topic config of the loaded config.
+func GetTopicConfig() TopicConfig {
+	if Helper == nil || Helper.ConfigYaml == nil {
+		return TopicConfig{}
+	}
+	return Helper.ConfigYaml.GetConfigData().Topic
+}
+
+// topicScope returns "<prefix>.<namespace>." without the empty part.
+func (c TopicConfig) topicScope() string {
+	scope := ""
+	for _, part := range []string{c.Prefix, c.Namespace} {
+		if part != "" {
+			scope += part + "."
+		}
+	}
+	return scope
+}
+
+// TopicName puts the prefix and the namespace in front of the logical name.
+func TopicName(name string) string {
+	return GetTopicConfig().topicScope() + name
+}
+
+// TopicLogicalName removes the prefix and the namespace from the topic.
+func TopicLogicalName(topic string) string {
+	return strings.TrimPrefix(topic, GetTopicConfig().topicScope())
+}
+
+func TopicJobEvent(projectUuid string, event string) string {
+	return TopicName(fmt.Sprint(projectUuid, ".", event))
+}
+
+func TopicRestart(projectUuid string) string {
+	return TopicJobEvent(projectUuid, "restart")
+}
+
+func TopicConfigUpdate(projectUuid string) string {
+	return TopicJobEvent(projectUuid, "config_update")
+}
+
+func TopicTaskWho(taskId string) string {
+	return TopicName(taskId + "_who")
+}
+
+func TopicTaskWorker(taskId string) string {
+	return TopicName(taskId + "_worker")
+}
+
+func TopicTaskListen(taskId string) string {
+	return TopicName(taskId + "_listen")
+}
+
+func TopicTaskListenCallback(taskId string) string {
+	return TopicTaskListen(taskId) + ".callback"
+}
+
+func TopicTaskProcess(taskId string) string {
+	return TopicName(taskId + "_process")
+}
+
+func TopicTaskFinish(taskId string) string {
+	return TopicName(taskId + "_finish")
+}
+
+func TopicTaskFailed(taskId string) string {
+	return TopicName(taskId + "_failed")
+}
+
+func TopicTaskNotifAdd(taskId string) string {
+	return TopicName(taskId + ".notif_add")
+}
+
+func TopicShutdown(identityId string) string {
+	return TopicName(identityId + ".shutdown")
+}
+
+// TopicTelemetry returns the hardware telemetry topic, kind is host, cpu, mem or net.
+func TopicTelemetry(kind string) string {
+	return TopicName("listen_" + kind + "_information")
+}
+
+var (
+	natsInvalidTopicChar     = regexp.MustCompile(`[\s*>]`)
+	mqttInvalidTopicChar     = regexp.MustCompile(`[+#]`)
+	rabbitmqInvalidTopicChar = regexp.MustCompile(`[*#]`)
+	kafkaInvalidTopicChar    = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+)
+
+// BrokerTopicName maps the topic to a name the broker accepts.
+// A valid name is kept as it is, so the Job Manager can use the same name.
+//   - nats     : whitespace and the wildcard "*" and ">" become "_"
+//   - mqtt     : the wildcard "+" and "#" become "_", a leading "$" is reserved by the broker
+//   - rabbitmq : the wildcard "*" and "#" of the binding key become "_", the "amq." prefix is reserved,
+//     the routing key and the queue name are limited to 255 byte
+//   - kafka    : every character outside [a-zA-Z0-9._-] becomes "_", limited to 249 character
+//   - redis, http, websocket, memory : kept as it is
+func BrokerTopicName(brokerType string, topic string) string {
+	switch brokerType {
+	case "nats":
+		return natsInvalidTopicChar.ReplaceAllString(topic, "_")
+	case "mqtt":
+		topic = mqttInvalidTopicChar.ReplaceAllString(topic, "_")
+		if strings.HasPrefix(topic, "$") {
+			topic = "_" + topic[1:]
+		}
+		return topic
+	case "rabbitmq":
+		topic = rabbitmqInvalidTopicChar.ReplaceAllString(topic, "_")
+		if strings.HasPrefix(topic, "amq.") {
+			topic = "_" + topic
+		}
+		if len(topic) > 255 {
+			topic = topic[:255]
+		}
+		return topic
+	case "kafka":
+		topic = kafkaInvalidTopicChar.ReplaceAllString(topic, "_")
+		if len(topic) > 249 {
+			topic = topic[:249]
+		}
+		return topic
+	}
+	return topic
+}
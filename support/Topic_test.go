@@ -0,0 +1,84 @@
+package support_test
+
+import (
+	"strings"
+	"testing"
+
+	support "job_item/support"
+)
+
+// withTopicConfig runs the test with topic.prefix and topic.namespace on the running config.
+func withTopicConfig(t *testing.T, topic support.TopicConfig) {
+	previous := support.Helper.ConfigYaml
+	t.Cleanup(func() { support.Helper.ConfigYaml = previous })
+	configData := support.ConfigData{}
+	configData.Topic = topic
+	support.Helper.ConfigYaml = &support.ConfigYamlSupport{ConfigData: configData}
+}
+
+func TestBrokerTopicName(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	tests := []struct {
+		name       string
+		brokerType string
+		topic      string
+		want       string
+	}{
+		{"valid name is kept", "nats", "acme.prod.uuid.backup", "acme.prod.uuid.backup"},
+		{"nats wildcard", "nats", "uuid.back up*>", "uuid.back_up__"},
+		{"mqtt wildcard", "mqtt", "uuid/+/#", "uuid/_/_"},
+		{"mqtt reserved $", "mqtt", "$SYS.uuid", "_SYS.uuid"},
+		{"rabbitmq amq. prefix", "rabbitmq", "amq.uuid.backup", "_amq.uuid.backup"},
+		{"rabbitmq amq without dot", "rabbitmq", "amqp.uuid", "amqp.uuid"},
+		{"rabbitmq binding wildcard", "rabbitmq", "uuid.*.#", "uuid._._"},
+		{"rabbitmq 255 byte", "rabbitmq", long, long[:255]},
+		{"rabbitmq amq. prefix then 255 byte", "rabbitmq", "amq." + long, ("_amq." + long)[:255]},
+		{"kafka character", "kafka", "uuid/back up:1", "uuid_back_up_1"},
+		{"kafka 249 character", "kafka", long, long[:249]},
+		{"redis is kept", "redis", "uuid back*up", "uuid back*up"},
+		{"http is kept", "http", "$uuid#", "$uuid#"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := support.BrokerTopicName(tt.brokerType, tt.topic); got != tt.want {
+				t.Errorf("BrokerTopicName(%q, %q) = %q, want %q", tt.brokerType, tt.topic, got, tt.want)
+			}
+		})
+	}
+}
+
+// The logical name comes back from the topic built with the prefix and the namespace.
+func TestTopicLogicalName(t *testing.T) {
+	tests := []struct {
+		name      string
+		topic     support.TopicConfig
+		wantTopic string
+	}{
+		{"no scope", support.TopicConfig{}, "t1_finish"},
+		{"prefix", support.TopicConfig{Prefix: "acme"}, "acme.t1_finish"},
+		{"namespace", support.TopicConfig{Namespace: "prod"}, "prod.t1_finish"},
+		{"prefix and namespace", support.TopicConfig{Prefix: "acme", Namespace: "prod"}, "acme.prod.t1_finish"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withTopicConfig(t, tt.topic)
+			topic := support.TopicTaskFinish("t1")
+			if topic != tt.wantTopic {
+				t.Fatalf("TopicTaskFinish = %q, want %q", topic, tt.wantTopic)
+			}
+			if got := support.TopicLogicalName(topic); got != "t1_finish" {
+				t.Fatalf("TopicLogicalName(%q) = %q, want t1_finish", topic, got)
+			}
+			// The round trip through the name of every broker, a valid name is not mangled
+			for _, brokerType := range []string{"nats", "mqtt", "rabbitmq", "kafka", "redis"} {
+				if got := support.TopicLogicalName(support.BrokerTopicName(brokerType, topic)); got != "t1_finish" {
+					t.Errorf("%s: round trip of %q = %q", brokerType, topic, got)
+				}
+			}
+			// A topic without the scope is kept as it is
+			if got := support.TopicLogicalName("other.t1_finish"); got != "other.t1_finish" {
+				t.Errorf("TopicLogicalName of a foreign topic = %q", got)
+			}
+		})
+	}
+}
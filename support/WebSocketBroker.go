@@ -0,0 +1,487 @@
+package support
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func init() {
+	RegisterBrokerType("websocket", BrokerTypeRegistration{
+		Fields: []ConfigField{
+			{Name: "end_point", Kind: CONFIG_KIND_STRING},
+			{Name: "ack_timeout", Kind: CONFIG_KIND_NUMBER},
+		},
+		// Empty end_point uses the Job Manager end_point, checked by ValidateConfigData
+		Validate: func(path string, v map[string]interface{}, errs *ConfigErrors) {
+			if configHas(v, "end_point") {
+				validateUrl(path+".end_point", configString(v, "end_point"), errs)
+			}
+		},
+		NewConnection: func() BrokerConInterface {
+			return &WebSocketBrokerConnection{}
+		},
+		Construct: func(conn BrokerConInterface) (BrokerConnectionInterface, error) {
+			return WebSocketSupportConstruct(conn.GetConnection().(WebSocketBrokerConnection))
+		},
+	})
+}
+
+// WebSocketSupport is a broker connection over a single multiplexed WebSocket
+// to the Job Manager, for the worker behind NAT that can not reach a broker.
+//
+// Every frame is a JSON object:
+//   - client -> server {"op": "auth", "id", "project_id", "secret_key"} first, like the body of the other Job Manager call
+//   - client -> server {"op": "sub", "id", "sid", "topic", "group"}
+//   - client -> server {"op": "unsub", "id", "sid"}
+//   - client -> server {"op": "pub", "id", "topic", "data"}
+//   - server -> client {"op": "ack", "id", "error"} for every frame with id
+//   - server -> client {"op": "msg", "sid", "topic", "data"}
+//
+// The message of one subscription is given to its callback in the order it arrives.
+func WebSocketSupportConstruct(props WebSocketBrokerConnection) (*WebSocketSupport, error) {
+	gg := WebSocketSupport{
+		wsConfInfo: props,
+		outbox:     BrokerOutboxConstruct(props.Key),
+		subs:       BrokerSubscriptionRegistryConstruct(props.Key),
+		pending:    map[int]chan string{},
+		listeners:  map[int]*webSocketListener{},
+	}
+	err := gg.ConnectPubSub()
+	return &gg, err
+}
+
+type webSocketFrame struct {
+	Op    string `json:"op"`
+	Id    int    `json:"id,omitempty"`
+	Sid   int    `json:"sid,omitempty"`
+	Topic string `json:"topic,omitempty"`
+	Group string `json:"group,omitempty"`
+	Data  string `json:"data,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Only on the auth frame
+	Project_id string `json:"project_id,omitempty"`
+	Secret_key string `json:"secret_key,omitempty"`
+}
+
+// webSocketListener runs the callback of one subscription on its own goroutine, one message
+// after the other. The queue is not bounded so the read loop never waits a slow callback.
+type webSocketListener struct {
+	callback func(message string)
+	mutex    sync.Mutex
+	queue    []string
+	wake     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newWebSocketListener(callback func(message string)) *webSocketListener {
+	gg := &webSocketListener{
+		callback: callback,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go gg.run()
+	return gg
+}
+
+func (l *webSocketListener) push(message string) {
+	l.mutex.Lock()
+	l.queue = append(l.queue, message)
+	l.mutex.Unlock()
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (l *webSocketListener) run() {
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-l.wake:
+		}
+		for {
+			l.mutex.Lock()
+			if len(l.queue) == 0 {
+				l.mutex.Unlock()
+				break
+			}
+			message := l.queue[0]
+			l.queue = l.queue[1:]
+			l.mutex.Unlock()
+			select {
+			case <-l.done:
+				return
+			default:
+			}
+			l.callback(message)
+		}
+	}
+}
+
+// stop drops the message still queued, safe to call twice.
+func (l *webSocketListener) stop() {
+	l.stopOnce.Do(func() {
+		close(l.done)
+	})
+}
+
+type WebSocketSupport struct {
+	wsConfInfo WebSocketBrokerConnection
+	conn       *websocket.Conn
+	key        string
+	outbox     *BrokerOutbox
+	subs       *BrokerSubscriptionRegistry
+	writeMutex sync.Mutex
+	mutex      sync.Mutex
+	lastId     int
+	// Waiting ack by frame id, receives the error text of the ack
+	pending map[int]chan string
+	// Listener by subscription id
+	listeners map[int]*webSocketListener
+}
+
+func (c *WebSocketSupport) GetRefreshPubSub() string {
+	return BROKER_REFRESH_PUBSUB
+}
+
+func (c *WebSocketSupport) url() string {
+	endPoint := c.wsConfInfo.End_point
+	if endPoint == "" && Helper != nil && Helper.ConfigYaml != nil {
+		endPoint = Helper.ConfigYaml.GetConfigData().End_point
+	}
+	endPoint = strings.TrimRight(endPoint, "/")
+	if strings.HasPrefix(endPoint, "https://") {
+		endPoint = "wss://" + strings.TrimPrefix(endPoint, "https://")
+	} else if strings.HasPrefix(endPoint, "http://") {
+		endPoint = "ws://" + strings.TrimPrefix(endPoint, "http://")
+	}
+	return endPoint + "/api/worker/broker/ws"
+}
+
+func (c *WebSocketSupport) ackTimeout() time.Duration {
+	if c.wsConfInfo.Ack_timeout > 0 {
+		return time.Duration(c.wsConfInfo.Ack_timeout) * time.Second
+	}
+	return 10 * time.Second
+}
+
+func (c *WebSocketSupport) dial() error {
+	conn, _, err := websocket.DefaultDialer.Dial(c.url(), nil)
+	if err != nil {
+		return err
+	}
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	})
+	conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+
+	c.mutex.Lock()
+	c.conn = conn
+	// The subscription of the old connection is gone, the registry subscribes again
+	for _, listener := range c.listeners {
+		listener.stop()
+	}
+	c.listeners = map[int]*webSocketListener{}
+	c.mutex.Unlock()
+
+	// The reader is needed for the ack of the auth, until the auth is done a closed
+	// connection is reported by dial to its caller which retries, not by the reader
+	state := &atomic.Int32{}
+	go c.readLoop(conn, state)
+	go c.pingLoop(conn)
+
+	auth := webSocketFrame{Op: "auth"}
+	if Helper != nil && Helper.ConfigYaml != nil {
+		credential := Helper.ConfigYaml.GetConfigData().Credential
+		auth.Project_id = credential.Project_id
+		auth.Secret_key = credential.Secret_key
+	}
+	if err := c.send(auth); err != nil {
+		conn.Close()
+		return fmt.Errorf("auth: %w", err)
+	}
+	if !state.CompareAndSwap(WS_CONN_DIALING, WS_CONN_ESTABLISHED) {
+		return errors.New("auth: connection closed")
+	}
+	return nil
+}
+
+func (c *WebSocketSupport) ConnectPubSub() error {
+	fmt.Println("WebSocket Broker Connection inf :: ", c.url())
+	// Retry mechanism
+	for {
+		err := c.dial()
+		if err == nil {
+			break
+		}
+		fmt.Println("WebSocket connection failed, retrying in 5-10 seconds:", err.Error())
+		time.Sleep(5 * time.Second) // Delay before retrying
+	}
+	c.subs.SetConnected(true)
+	fmt.Println("Successfully connected to WebSocket broker - main")
+	return nil
+}
+
+func (c *WebSocketSupport) retryConnection() {
+	for {
+		time.Sleep(5 * time.Second)
+		if c.subs.IsClosed() {
+			return
+		}
+		err := c.dial()
+		if err != nil {
+			fmt.Println("WebSocket reconnect failed, retrying:", err.Error())
+			continue
+		}
+		fmt.Println("Successfully reconnected to WebSocket broker")
+		c.subs.Resubscribe()
+		c.outbox.Flush(c.publish)
+		c.subs.SetConnected(true)
+		Helper.EventBus.GetBus().Publish(BROKER_REFRESH_PUBSUB, nil)
+		return
+	}
+}
+
+// State of a connection, only the established one is reconnected by its reader
+const (
+	WS_CONN_DIALING int32 = iota
+	WS_CONN_ESTABLISHED
+	WS_CONN_FAILED
+)
+
+func (c *WebSocketSupport) readLoop(conn *websocket.Conn, state *atomic.Int32) {
+	for {
+		frame := webSocketFrame{}
+		if err := conn.ReadJSON(&frame); err != nil {
+			conn.Close()
+			c.failPending("connection closed")
+			// Closed before the auth is done, dial returns the error and its caller retries
+			if state.CompareAndSwap(WS_CONN_DIALING, WS_CONN_FAILED) {
+				return
+			}
+			c.subs.SetConnected(false)
+			if c.subs.IsClosed() {
+				return
+			}
+			fmt.Println("Disconnected from WebSocket broker, attempting to reconnect:", err)
+			go c.retryConnection()
+			return
+		}
+		switch frame.Op {
+		case "ack":
+			c.mutex.Lock()
+			ackCh, ok := c.pending[frame.Id]
+			delete(c.pending, frame.Id)
+			c.mutex.Unlock()
+			if ok {
+				ackCh <- frame.Error
+			}
+		case "msg":
+			c.mutex.Lock()
+			listener, ok := c.listeners[frame.Sid]
+			c.mutex.Unlock()
+			if ok {
+				listener.push(frame.Data)
+			}
+		}
+	}
+}
+
+func (c *WebSocketSupport) pingLoop(conn *websocket.Conn) {
+	ticker := time.NewTicker(25 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.writeMutex.Lock()
+		err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+		c.writeMutex.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// failPending releases every frame still waiting the ack of the closed connection.
+func (c *WebSocketSupport) failPending(reason string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for id, ackCh := range c.pending {
+		ackCh <- reason
+		delete(c.pending, id)
+	}
+}
+
+func (c *WebSocketSupport) nextId() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lastId++
+	return c.lastId
+}
+
+// send writes the frame and waits the ack from the Job Manager.
+func (c *WebSocketSupport) send(frame webSocketFrame) error {
+	frame.Id = c.nextId()
+	ackCh := make(chan string, 1)
+	c.mutex.Lock()
+	conn := c.conn
+	c.pending[frame.Id] = ackCh
+	c.mutex.Unlock()
+
+	removePending := func() {
+		c.mutex.Lock()
+		delete(c.pending, frame.Id)
+		c.mutex.Unlock()
+	}
+	if conn == nil {
+		removePending()
+		return errors.New("websocket is not connected")
+	}
+	c.writeMutex.Lock()
+	conn.SetWriteDeadline(time.Now().Add(c.ackTimeout()))
+	err := conn.WriteJSON(frame)
+	c.writeMutex.Unlock()
+	if err != nil {
+		removePending()
+		return err
+	}
+	select {
+	case ackErr := <-ackCh:
+		if ackErr != "" {
+			return errors.New(ackErr)
+		}
+		return nil
+	case <-time.After(c.ackTimeout()):
+		removePending()
+		return fmt.Errorf("no ack for %s %s", frame.Op, frame.Topic)
+	}
+}
+
+// Interface from BrokerConnectionInterface
+func (c *WebSocketSupport) Pub(topic string, msg string) {
+	c.outbox.Publish(c.IsConnected(), topic, msg, c.publish)
+}
+
+func (c *WebSocketSupport) publish(topic string, msg string) error {
+	return c.send(webSocketFrame{Op: "pub", Topic: topic, Data: msg})
+}
+
+func (c *WebSocketSupport) subscribe(topic string, group string, callback func(message string)) (func(), error) {
+	sid := c.nextId()
+	listener := newWebSocketListener(callback)
+	c.mutex.Lock()
+	c.listeners[sid] = listener
+	c.mutex.Unlock()
+	if err := c.send(webSocketFrame{Op: "sub", Sid: sid, Topic: topic, Group: group}); err != nil {
+		c.mutex.Lock()
+		delete(c.listeners, sid)
+		c.mutex.Unlock()
+		listener.stop()
+		return nil, err
+	}
+	return func() {
+		c.mutex.Lock()
+		_, ok := c.listeners[sid]
+		delete(c.listeners, sid)
+		c.mutex.Unlock()
+		listener.stop()
+		// Missing listener means the connection is already gone
+		if ok {
+			c.send(webSocketFrame{Op: "unsub", Sid: sid})
+		}
+	}, nil
+}
+
+// subscribeSync waits one message up to the timeout. Returns (isTimeout, error).
+func (c *WebSocketSupport) subscribeSync(topic string, group string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
+	msgCh := make(chan string, 1)
+	unsubscribe, err := c.subscribe(topic, group, func(message string) {
+		select {
+		case msgCh <- message:
+		default:
+		}
+	})
+	if err != nil {
+		return true, err
+	}
+	defer unsubscribe()
+	select {
+	case msg := <-msgCh:
+		fmt.Printf("\n unSubscribeFinish: %s\n", msg)
+		callback(msg, nil)
+		return false, nil
+	case <-time.After(time.Duration(opts.Timeout_second) * time.Second):
+		return true, nil
+	}
+}
+
+// Interface from BrokerConnectionInterface
+func (c *WebSocketSupport) Sub(topic string, group_id string, callback func(message string)) (func(), error) {
+	return c.subs.Subscribe(topic, func() (func(), error) {
+		return c.subscribe(topic, group_id, callback)
+	})
+}
+
+// Interface from BrokerConnectionInterface
+func (c *WebSocketSupport) SubSync(uuidItem string, group_id string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
+	return c.subscribeSync(uuidItem, group_id, callback, opts)
+}
+
+// Interface from BrokerConnectionInterface
+func (c *WebSocketSupport) BasicSub(topic string, callback func(message string)) (func(), error) {
+	return c.subs.Subscribe(topic, func() (func(), error) {
+		return c.subscribe(topic, "", callback)
+	})
+}
+
+// Interface from BrokerConnectionInterface
+func (c *WebSocketSupport) BasicSubSync(topic string, callback func(message string, err error), opts SubSyncOpts) (bool, error) {
+	return c.subscribeSync(topic, "", callback, opts)
+}
+
+// Interface from BrokerConnectionInterface
+func (c *WebSocketSupport) SetKey_P(key string) {
+	c.key = key
+}
+
+// Interface from BrokerConnectionInterface
+func (c *WebSocketSupport) GetKey_P() string {
+	return c.key
+}
+
+// Interface from BrokerConnectionInterface
+func (c *WebSocketSupport) GetBroker_P() any {
+	return c
+}
+
+// Interface from BrokerConnectionInterface
+func (c *WebSocketSupport) IsConnected() bool {
+	return c.subs.IsConnected()
+}
+
+// Interface from BrokerConnectionInterface
+func (c *WebSocketSupport) Close() {
+	c.subs.Close()
+	c.mutex.Lock()
+	conn := c.conn
+	for _, listener := range c.listeners {
+		listener.stop()
+	}
+	c.listeners = map[int]*webSocketListener{}
+	c.mutex.Unlock()
+	if conn != nil {
+		// The read loop sees the closed registry and does not reconnect
+		conn.Close()
+	}
+}
+
+// Interface from SupportInterface
+func (c *WebSocketSupport) GetObject() any {
+	return c
+}
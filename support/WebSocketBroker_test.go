@@ -0,0 +1,147 @@
+package support_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	support "job_item/support"
+
+	"github.com/gorilla/websocket"
+)
+
+// webSocketTestServer is the broker side of the Job Manager, it acks every frame
+// and sends every publish back to the subscription of the topic.
+// The auth of the first rejectAuth connections is refused, dials counts every connection.
+func webSocketTestServer(t *testing.T, rejectAuth int32, dials *atomic.Int32) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		dial := dials.Add(1)
+		writeMutex := sync.Mutex{}
+		write := func(frame map[string]any) {
+			writeMutex.Lock()
+			defer writeMutex.Unlock()
+			conn.WriteJSON(frame)
+		}
+		authenticated := false
+		sids := map[string][]float64{}
+		for {
+			frame := map[string]any{}
+			if err := conn.ReadJSON(&frame); err != nil {
+				return
+			}
+			if !authenticated && frame["op"] != "auth" {
+				t.Errorf("first frame is %v, want auth", frame["op"])
+				return
+			}
+			switch frame["op"] {
+			case "auth":
+				if dial <= rejectAuth {
+					write(map[string]any{"op": "ack", "id": frame["id"], "error": "invalid secret_key"})
+					return
+				}
+				authenticated = true
+			case "sub":
+				topic, _ := frame["topic"].(string)
+				sid, _ := frame["sid"].(float64)
+				sids[topic] = append(sids[topic], sid)
+			case "pub":
+				topic, _ := frame["topic"].(string)
+				for _, sid := range sids[topic] {
+					write(map[string]any{"op": "msg", "sid": sid, "topic": topic, "data": frame["data"]})
+				}
+			}
+			write(map[string]any{"op": "ack", "id": frame["id"]})
+		}
+	}))
+}
+
+// The message of one subscription arrives in the order it was published.
+func TestWebSocketBrokerOrder(t *testing.T) {
+	server := webSocketTestServer(t, 0, &atomic.Int32{})
+	defer server.Close()
+
+	conn, err := support.WebSocketSupportConstruct(support.WebSocketBrokerConnection{
+		Key:       "ws_test",
+		Type:      "websocket",
+		End_point: server.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	mutex := sync.Mutex{}
+	received := []string{}
+	unsubscribe, err := conn.BasicSub("task_process", func(message string) {
+		// A slow callback must not let the next message pass it
+		time.Sleep(time.Millisecond)
+		mutex.Lock()
+		received = append(received, message)
+		mutex.Unlock()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	total := 50
+	for i := range total {
+		conn.Pub("task_process", fmt.Sprint("line-", i))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mutex.Lock()
+		count := len(received)
+		mutex.Unlock()
+		if count >= total {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(received) != total {
+		t.Fatalf("received %d message, want %d", len(received), total)
+	}
+	for i, message := range received {
+		if message != fmt.Sprint("line-", i) {
+			t.Fatalf("message %d is %q, the order is lost", i, message)
+		}
+	}
+}
+
+// A refused auth is retried by the connect loop only, the reader of the closed
+// connection does not start a second retry loop.
+func TestWebSocketBrokerAuthRetry(t *testing.T) {
+	dials := &atomic.Int32{}
+	server := webSocketTestServer(t, 1, dials)
+	defer server.Close()
+
+	conn, err := support.WebSocketSupportConstruct(support.WebSocketBrokerConnection{
+		Key:       "ws_test",
+		Type:      "websocket",
+		End_point: server.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if !conn.IsConnected() {
+		t.Fatal("not connected after the retry")
+	}
+	// A second loop would dial again on the same 5 second step
+	time.Sleep(6 * time.Second)
+	if got := dials.Load(); got != 2 {
+		t.Fatalf("dialed %d time, want 2", got)
+	}
+}
@@ -0,0 +1,406 @@
+// Package brokertest checks a BrokerConnectionInterface implementation against
+// the contract the job listener relies on.
+//
+// Every implementation, including the memory broker, must pass:
+//   - Sub with a group delivers every message to exactly one member of the group
+//   - BasicSub delivers every message to every subscriber, also the repeated payload
+//   - SubSync and BasicSubSync return (true, nil) on timeout and (false, nil) with the message
+//   - the unsubscribe function stops the delivery and is safe to call twice
+//   - the subscription survives a broker restart
+package brokertest
+
+import (
+	"errors"
+	"fmt"
+	support "job_item/support"
+	support_helper "job_item/support/helper"
+	"sync"
+	"time"
+)
+
+// Factory opens a new connection to the broker under test, the check closes it once done.
+type Factory func() (support.BrokerConnectionInterface, error)
+
+type Options struct {
+	// How long a message may take to arrive, default 3 seconds
+	Wait time.Duration
+	// How long a new subscription takes before it receives, default 500 milliseconds
+	Settle time.Duration
+	// Restart the broker, nil skips the reconnect check
+	Restart func() error
+}
+
+// ErrSkipped is returned by the check that can not run with the options.
+var ErrSkipped = errors.New("skipped")
+
+type Result struct {
+	Name    string
+	Err     error
+	Skipped bool
+}
+
+type check struct {
+	name string
+	run  func(factory Factory, opts Options, topic string) error
+}
+
+var checks = []check{
+	{"queue_group_exclusivity", checkQueueGroup},
+	{"basic_sub_fan_out", checkFanOut},
+	{"repeated_payload", checkRepeatedPayload},
+	{"sub_sync_timeout", checkSubSyncTimeout},
+	{"sub_sync_receive", checkSubSyncReceive},
+	{"basic_sub_sync_timeout", checkBasicSubSyncTimeout},
+	{"basic_sub_sync_receive", checkBasicSubSyncReceive},
+	{"unsubscribe_idempotent", checkUnsubscribe},
+	{"reconnect", checkReconnect},
+}
+
+// Run runs every check and returns the result of each one.
+func Run(factory Factory, opts Options) []Result {
+	if opts.Wait <= 0 {
+		opts.Wait = 3 * time.Second
+	}
+	if opts.Settle <= 0 {
+		opts.Settle = 500 * time.Millisecond
+	}
+	runId, err := support_helper.GenerateUUIDv7()
+	if err != nil {
+		return []Result{{Name: "init", Err: err}}
+	}
+	results := []Result{}
+	for _, v := range checks {
+		topic := "brokertest." + runId + "." + v.name
+		err := runCheck(v, factory, opts, topic)
+		if errors.Is(err, ErrSkipped) {
+			results = append(results, Result{Name: v.name, Skipped: true})
+			continue
+		}
+		results = append(results, Result{Name: v.name, Err: err})
+	}
+	return results
+}
+
+// TestBroker runs every check and returns the joined error of the failed check.
+func TestBroker(factory Factory, opts Options) error {
+	errs := []error{}
+	for _, result := range Run(factory, opts) {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", result.Name, result.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runCheck turns the panic of the implementation into a failed check.
+func runCheck(v check, factory Factory, opts Options, topic string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return v.run(factory, opts, topic)
+}
+
+// collector counts the received message by payload.
+type collector struct {
+	mutex    sync.Mutex
+	received map[string]int
+	total    int
+}
+
+func newCollector() *collector {
+	return &collector{received: map[string]int{}}
+}
+
+func (c *collector) add(message string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.received[message]++
+	c.total++
+}
+
+func (c *collector) count(message string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.received[message]
+}
+
+func (c *collector) getTotal() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.total
+}
+
+// waitTotal waits until the collector has at least total message or the wait is over.
+func (c *collector) waitTotal(total int, wait time.Duration) {
+	deadline := time.Now().Add(wait)
+	for time.Now().Before(deadline) && c.getTotal() < total {
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func newConnections(factory Factory, total int) ([]support.BrokerConnectionInterface, error) {
+	conns := []support.BrokerConnectionInterface{}
+	for range total {
+		conn, err := factory()
+		if err != nil {
+			closeConnections(conns)
+			return nil, fmt.Errorf("open connection: %w", err)
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+// closeConnections closes every connection opened by the check, after its subscription is canceled.
+func closeConnections(conns []support.BrokerConnectionInterface) {
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+func checkQueueGroup(factory Factory, opts Options, topic string) error {
+	conns, err := newConnections(factory, 2)
+	if err != nil {
+		return err
+	}
+	defer closeConnections(conns)
+	received := newCollector()
+	for _, conn := range conns {
+		unsubscribe, err := conn.Sub(topic, "brokertest", received.add)
+		if err != nil {
+			return fmt.Errorf("sub: %w", err)
+		}
+		defer unsubscribe()
+	}
+	time.Sleep(opts.Settle)
+
+	total := 10
+	for i := range total {
+		conns[0].Pub(topic, fmt.Sprint("message-", i))
+	}
+	received.waitTotal(total, opts.Wait)
+	// Give the duplicate time to arrive
+	time.Sleep(opts.Settle)
+	for i := range total {
+		message := fmt.Sprint("message-", i)
+		if count := received.count(message); count != 1 {
+			return fmt.Errorf("%s received %d times by the group, want 1", message, count)
+		}
+	}
+	return nil
+}
+
+func checkFanOut(factory Factory, opts Options, topic string) error {
+	conns, err := newConnections(factory, 2)
+	if err != nil {
+		return err
+	}
+	defer closeConnections(conns)
+	collectors := []*collector{}
+	for _, conn := range conns {
+		received := newCollector()
+		unsubscribe, err := conn.BasicSub(topic, received.add)
+		if err != nil {
+			return fmt.Errorf("basic sub: %w", err)
+		}
+		defer unsubscribe()
+		collectors = append(collectors, received)
+	}
+	time.Sleep(opts.Settle)
+
+	total := 5
+	for i := range total {
+		conns[0].Pub(topic, fmt.Sprint("message-", i))
+	}
+	for index, received := range collectors {
+		received.waitTotal(total, opts.Wait)
+		if received.getTotal() != total {
+			return fmt.Errorf("subscriber %d received %d message, want %d", index, received.getTotal(), total)
+		}
+	}
+	return nil
+}
+
+func checkRepeatedPayload(factory Factory, opts Options, topic string) error {
+	conn, err := factory()
+	if err != nil {
+		return fmt.Errorf("open connection: %w", err)
+	}
+	defer conn.Close()
+	received := newCollector()
+	unsubscribe, err := conn.BasicSub(topic, received.add)
+	if err != nil {
+		return fmt.Errorf("basic sub: %w", err)
+	}
+	defer unsubscribe()
+	time.Sleep(opts.Settle)
+
+	conn.Pub(topic, "same")
+	conn.Pub(topic, "same")
+	received.waitTotal(2, opts.Wait)
+	if count := received.count("same"); count != 2 {
+		return fmt.Errorf("repeated payload received %d times, want 2", count)
+	}
+	return nil
+}
+
+// syncFunc is SubSync or BasicSubSync on the topic.
+type syncFunc func(conn support.BrokerConnectionInterface, topic string, callback func(message string, err error), opts support.SubSyncOpts) (bool, error)
+
+func subSync(conn support.BrokerConnectionInterface, topic string, callback func(message string, err error), opts support.SubSyncOpts) (bool, error) {
+	return conn.SubSync(topic, "brokertest", callback, opts)
+}
+
+func basicSubSync(conn support.BrokerConnectionInterface, topic string, callback func(message string, err error), opts support.SubSyncOpts) (bool, error) {
+	return conn.BasicSubSync(topic, callback, opts)
+}
+
+func checkSyncTimeout(factory Factory, opts Options, topic string, fn syncFunc) error {
+	conn, err := factory()
+	if err != nil {
+		return fmt.Errorf("open connection: %w", err)
+	}
+	defer conn.Close()
+	called := false
+	start := time.Now()
+	isTimeout, err := fn(conn, topic, func(message string, err error) {
+		called = true
+	}, support.SubSyncOpts{Timeout_second: 1})
+	elapsed := time.Since(start)
+	if err != nil {
+		return fmt.Errorf("returned error %w, want nil", err)
+	}
+	if !isTimeout {
+		return errors.New("returned isTimeout false without message")
+	}
+	if called {
+		return errors.New("callback called without message")
+	}
+	if elapsed < time.Second || elapsed > time.Second+opts.Wait {
+		return fmt.Errorf("returned after %s, want about 1s", elapsed)
+	}
+	return nil
+}
+
+func checkSyncReceive(factory Factory, opts Options, topic string, fn syncFunc) error {
+	conns, err := newConnections(factory, 2)
+	if err != nil {
+		return err
+	}
+	defer closeConnections(conns)
+	done := make(chan struct{})
+	// Publish until the sync subscription is ready and receives
+	go func() {
+		ticker := time.NewTicker(opts.Settle)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				conns[1].Pub(topic, "hello")
+			}
+		}
+	}()
+	received := ""
+	timeoutSecond := int((opts.Wait + opts.Settle).Seconds()) + 1
+	isTimeout, err := fn(conns[0], topic, func(message string, err error) {
+		received = message
+	}, support.SubSyncOpts{Timeout_second: timeoutSecond})
+	close(done)
+	if err != nil {
+		return fmt.Errorf("returned error %w, want nil", err)
+	}
+	if isTimeout {
+		return errors.New("timeout before the message arrived")
+	}
+	if received != "hello" {
+		return fmt.Errorf("callback received %q, want %q", received, "hello")
+	}
+	return nil
+}
+
+func checkSubSyncTimeout(factory Factory, opts Options, topic string) error {
+	return checkSyncTimeout(factory, opts, topic, subSync)
+}
+
+func checkSubSyncReceive(factory Factory, opts Options, topic string) error {
+	return checkSyncReceive(factory, opts, topic, subSync)
+}
+
+func checkBasicSubSyncTimeout(factory Factory, opts Options, topic string) error {
+	return checkSyncTimeout(factory, opts, topic, basicSubSync)
+}
+
+func checkBasicSubSyncReceive(factory Factory, opts Options, topic string) error {
+	return checkSyncReceive(factory, opts, topic, basicSubSync)
+}
+
+func checkUnsubscribe(factory Factory, opts Options, topic string) error {
+	conn, err := factory()
+	if err != nil {
+		return fmt.Errorf("open connection: %w", err)
+	}
+	defer conn.Close()
+	received := newCollector()
+	unsubscribe, err := conn.Sub(topic, "brokertest", received.add)
+	if err != nil {
+		return fmt.Errorf("sub: %w", err)
+	}
+	time.Sleep(opts.Settle)
+	conn.Pub(topic, "before")
+	received.waitTotal(1, opts.Wait)
+	if received.count("before") != 1 {
+		return errors.New("message before unsubscribe not received")
+	}
+
+	unsubscribe()
+	unsubscribe()
+	time.Sleep(opts.Settle)
+	conn.Pub(topic, "after")
+	time.Sleep(opts.Wait)
+	if received.count("after") != 0 {
+		return errors.New("message received after unsubscribe")
+	}
+	return nil
+}
+
+func checkReconnect(factory Factory, opts Options, topic string) error {
+	if opts.Restart == nil {
+		return ErrSkipped
+	}
+	conn, err := factory()
+	if err != nil {
+		return fmt.Errorf("open connection: %w", err)
+	}
+	defer conn.Close()
+	received := newCollector()
+	unsubscribe, err := conn.Sub(topic, "brokertest", received.add)
+	if err != nil {
+		return fmt.Errorf("sub: %w", err)
+	}
+	defer unsubscribe()
+	time.Sleep(opts.Settle)
+
+	if err := opts.Restart(); err != nil {
+		return fmt.Errorf("restart broker: %w", err)
+	}
+	// The broker needs time to come back and the client to notice it
+	deadline := time.Now().Add(opts.Wait * 10)
+	for time.Now().Before(deadline) && !conn.IsConnected() {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if !conn.IsConnected() {
+		return errors.New("not connected after restart")
+	}
+	time.Sleep(opts.Settle)
+
+	conn.Pub(topic, "after-restart")
+	received.waitTotal(1, opts.Wait)
+	if received.count("after-restart") != 1 {
+		return errors.New("subscription lost after restart")
+	}
+	return nil
+}